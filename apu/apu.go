@@ -0,0 +1,340 @@
+package apu
+
+// This package provides emulation of the audio processing unit in the 2A03.
+//
+// The APU is clocked by the CPU.  Each time the CPU executes an instruction the number of cycles
+// it took should be passed to Step(...).  For details see http://wiki.nesdev.com/w/index.php/APU
+
+import "cpu"
+
+// CPU addresses of the APU registers that aren't specific to a single channel.
+const (
+	// Channel enable (write) and length counter / interrupt status (read).
+	APUSTATUS = 0x4015
+
+	// Frame counter mode and IRQ inhibit (write).  Reads go to the 2nd controller.
+	APUFRAME = 0x4017
+)
+
+// CPU cycles at which the frame sequencer clocks the envelopes, length counters and sweep units.
+// The sequencer runs at (roughly) 240Hz.  There are two sequences, selected via $4017:
+//
+// 4-step:  Q  QH  Q  QH+IRQ
+// 5-step:  Q  QH  Q  -   QH
+//
+// where Q is a quarter frame, H is a half frame and IRQ is the frame interrupt.
+const (
+	frameStep1 = 7457
+	frameStep2 = 14913
+	frameStep3 = 22371
+	frameStep4 = 29829
+	frameStep5 = 37281
+
+	// The 4-step sequence raises the frame IRQ for the last 3 cycles of the sequence.
+	frameIRQStart4 = 29828
+
+	// The sequences repeat after this many CPU cycles.
+	frameLength4 = 29830
+	frameLength5 = 37282
+)
+
+type APU struct {
+	// $4000 -> $4003
+	pulse1 pulse
+
+	// $4004 -> $4007
+	pulse2 pulse
+
+	// $4008 -> $400B
+	triangle triangle
+
+	// $400C -> $400F
+	noise noise
+
+	// $4010 -> $4013
+	dmc dmc
+
+	// The frame counter and DMC interrupts are raised on this.
+	irq cpu.IRQLine
+
+	// Turns the output level into samples at the output sample rate.
+	resampler resampler
+
+	// How many CPU cycles into the current frame sequence are we?
+	frameCycle uint64
+
+	// $4017 bit 7.  Selects the 5-step sequence if set, the 4-step sequence otherwise.
+	fiveStep bool
+
+	// $4017 bit 6.  If set the frame interrupt flag is never raised.
+	frameIRQInhibit bool
+
+	// Set at the end of the 4-step sequence.  Visible in $4015 bit 6 and cleared by reading it.
+	frameIRQ bool
+
+	// A write to $4017 resets the sequencer after a 3 or 4 cycle delay.  This counts down the
+	// remaining delay, and is 0 if there is no pending reset.
+	frameResetDelay int
+
+	// The pulse channel timers are clocked every other CPU cycle.  This toggles every cycle.
+	oddCycle bool
+}
+
+// Allocate a new APU and initialize its internal state.
+func NewAPU() (apu *APU) {
+	apu = new(APU)
+
+	// Pulse 1 negates differently from pulse 2 in the sweep unit.
+	apu.pulse1.sweep.onesComplement = true
+
+	// The noise shift register is loaded with 1 on power-up, and the timer has the shortest
+	// period.
+	apu.noise.shiftReg = 1
+	apu.noise.period = noisePeriodTable[0]
+
+	// The DMC output unit starts out silent.
+	apu.dmc.period = dmcRateTable[0]
+	apu.dmc.bitsRemaining = 8
+	apu.dmc.silence = true
+	return
+}
+
+// The DMC reads its samples from CPU memory.  This must be called before the APU is clocked.
+func (apu *APU) ConnectMemory(mem cpu.MemoryInterface) {
+	apu.dmc.mem = mem
+}
+
+// Set the rate, in Hz, that samples are produced at for ReadSamples(...).  No samples are produced
+// until this is called.  Small changes to the rate are fine at any time and are how the output is
+// kept in sync with the audio device.
+func (apu *APU) SetSampleRate(rate float64) {
+	apu.resampler.setSampleRate(rate)
+}
+
+// Read the samples produced since the last call into 'out'.  Samples are signed 16-bit mono.
+// Returns how many samples were read, which is at most len(out).
+func (apu *APU) ReadSamples(out []int16) int {
+	return apu.resampler.read(out, len(out))
+}
+
+// Interrupts from the frame counter and DMC are sent to 'line'.
+func (apu *APU) ConnectIRQ(line cpu.IRQLine) {
+	apu.irq = line
+}
+
+// APU registers are mapped to [0x4000 -> 0x4013] and 0x4015 in the CPU address space.  Writes to
+// those addresses wind up here.
+func (apu *APU) WriteRegister(addr uint16, val uint8) {
+	switch {
+	case addr < 0x4004:
+		apu.pulse1.write(addr & 3, val)
+	case addr < 0x4008:
+		apu.pulse2.write(addr & 3, val)
+	case addr < 0x400c:
+		apu.triangle.write(addr & 3, val)
+	case addr < 0x4010:
+		apu.noise.write(addr & 3, val)
+	case addr < 0x4014:
+		apu.dmc.write(addr & 3, val)
+	case addr == APUSTATUS:
+		// ---D NT21: enable bits for each channel.  Disabling a channel also silences it
+		// immediately by clearing its length counter.
+		apu.pulse1.length.setEnabled(0 != (val & 0x01))
+		apu.pulse2.length.setEnabled(0 != (val & 0x02))
+		apu.triangle.length.setEnabled(0 != (val & 0x04))
+		apu.noise.length.setEnabled(0 != (val & 0x08))
+		apu.dmc.setEnabled(0 != (val & 0x10))
+	case addr == APUFRAME:
+		// MI-- ----
+		apu.fiveStep = 0x80 == (val & 0x80)
+		apu.frameIRQInhibit = 0x40 == (val & 0x40)
+		if apu.frameIRQInhibit {
+			apu.frameIRQ = false
+		}
+
+		// The sequencer is reset 3 cycles after the write if it happens on an APU cycle
+		// boundary, 4 otherwise.
+		if apu.oddCycle {
+			apu.frameResetDelay = 4
+		} else {
+			apu.frameResetDelay = 3
+		}
+	}
+
+	apu.updateIRQ()
+}
+
+// Reads of 0x4015 in the CPU address space wind up here.
+//
+// 7654 3210
+// |||| ||||
+// |||| |||+- Pulse 1 length counter > 0
+// |||| ||+-- Pulse 2 length counter > 0
+// |||| |+--- Triangle length counter > 0
+// |||| +---- Noise length counter > 0
+// |||+------ DMC active
+// ||+------- Open bus
+// |+-------- Frame interrupt
+// +--------- DMC interrupt
+func (apu *APU) ReadStatus() (val uint8) {
+	if apu.pulse1.length.count > 0 {
+		val |= 0x01
+	}
+	if apu.pulse2.length.count > 0 {
+		val |= 0x02
+	}
+	if apu.triangle.length.count > 0 {
+		val |= 0x04
+	}
+	if apu.noise.length.count > 0 {
+		val |= 0x08
+	}
+	if apu.dmc.bytesRemaining > 0 {
+		val |= 0x10
+	}
+	if apu.frameIRQ {
+		val |= 0x40
+	}
+	if apu.dmc.irqFlag {
+		val |= 0x80
+	}
+
+	// Reading clears the frame interrupt flag (but not the DMC one).
+	apu.frameIRQ = false
+	apu.updateIRQ()
+	return
+}
+
+// The IRQ line is asserted for as long as either interrupt flag is set.  Both stay set until
+// they are acknowledged via $4015/$4017 (or $4010 for the DMC).
+func (apu *APU) updateIRQ() {
+	if nil == apu.irq {
+		return
+	}
+
+	if apu.frameIRQ {
+		apu.irq.AssertIRQ(cpu.IRQ_APU_FRAME)
+	} else {
+		apu.irq.ReleaseIRQ(cpu.IRQ_APU_FRAME)
+	}
+
+	if apu.dmc.irqFlag {
+		apu.irq.AssertIRQ(cpu.IRQ_APU_DMC)
+	} else {
+		apu.irq.ReleaseIRQ(cpu.IRQ_APU_DMC)
+	}
+}
+
+// Advance the APU by 'cycles' CPU cycles.  Returns how many cycles the CPU is stalled for by DMC
+// sample fetches that happened during those cycles.  Time passes for the APU during a stall too,
+// so the caller is expected to Step(...) those as well.
+func (apu *APU) Step(cycles uint64) (stall uint64) {
+	for i := uint64(0); i < cycles; i++ {
+		stall += apu.clock()
+	}
+	return
+}
+
+// Advance the APU by a single CPU cycle.  Returns how many cycles the CPU is stalled for.
+func (apu *APU) clock() (stall uint64) {
+	// The triangle, noise and DMC timers run at the CPU rate.
+	apu.triangle.clockTimer()
+	apu.noise.clockTimer()
+	stall = apu.dmc.clockTimer()
+
+	// The pulse timers run at half the CPU rate.
+	if apu.oddCycle {
+		apu.pulse1.clockTimer()
+		apu.pulse2.clockTimer()
+	}
+	apu.oddCycle = !apu.oddCycle
+
+	apu.clockFrameSequencer()
+	apu.updateIRQ()
+
+	apu.resampler.addLevel(apu.Output())
+	return
+}
+
+// The frame sequencer generates the quarter- and half-frame signals that clock the envelopes,
+// the triangle's linear counter, the length counters and the sweep units.
+func (apu *APU) clockFrameSequencer() {
+	if apu.frameResetDelay > 0 {
+		apu.frameResetDelay--
+		if 0 == apu.frameResetDelay {
+			apu.frameCycle = 0
+			// Selecting the 5-step sequence immediately clocks everything.
+			if apu.fiveStep {
+				apu.quarterFrame()
+				apu.halfFrame()
+			}
+			return
+		}
+	}
+
+	apu.frameCycle++
+
+	if apu.fiveStep {
+		switch apu.frameCycle {
+		case frameStep1, frameStep3:
+			apu.quarterFrame()
+		case frameStep2, frameStep5:
+			apu.quarterFrame()
+			apu.halfFrame()
+		case frameLength5:
+			apu.frameCycle = 0
+		}
+		return
+	}
+
+	switch apu.frameCycle {
+	case frameStep1, frameStep3:
+		apu.quarterFrame()
+	case frameStep2:
+		apu.quarterFrame()
+		apu.halfFrame()
+	case frameIRQStart4:
+		apu.setFrameIRQ()
+	case frameStep4:
+		apu.quarterFrame()
+		apu.halfFrame()
+		apu.setFrameIRQ()
+	case frameLength4:
+		apu.setFrameIRQ()
+		apu.frameCycle = 0
+	}
+}
+
+// Raise the frame interrupt unless it's inhibited.
+func (apu *APU) setFrameIRQ() {
+	if !apu.frameIRQInhibit {
+		apu.frameIRQ = true
+	}
+}
+
+// Clock the envelopes and the triangle's linear counter.
+func (apu *APU) quarterFrame() {
+	apu.pulse1.env.clock()
+	apu.pulse2.env.clock()
+	apu.noise.env.clock()
+	apu.triangle.clockLinear()
+}
+
+// Clock the length counters and sweep units.
+func (apu *APU) halfFrame() {
+	apu.pulse1.length.clock()
+	apu.pulse2.length.clock()
+	apu.triangle.length.clock()
+	apu.noise.length.clock()
+
+	apu.pulse1.sweep.clock(&apu.pulse1.period)
+	apu.pulse2.sweep.clock(&apu.pulse2.period)
+}
+
+// The mixed output of all channels at this instant, from 0.0 to roughly 1.0.
+func (apu *APU) Output() float32 {
+	pulseOut := pulseMixTable[apu.pulse1.output() + apu.pulse2.output()]
+	tndOut := tndMixTable[3 * int(apu.triangle.output()) + 2 * int(apu.noise.output()) +
+			      int(apu.dmc.output())]
+	return pulseOut + tndOut
+}
@@ -0,0 +1,175 @@
+package apu
+
+import "cpu"
+
+// Timer periods for the DMC, in CPU cycles, selected by the low 4 bits of $4010.
+var dmcRateTable = [16]uint16 {
+	428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54,
+}
+
+// How many CPU cycles the CPU is stalled for when the DMC fetches a sample byte.  This is really
+// 1 to 4 cycles depending on what the CPU is doing, but 4 is by far the most common case.
+const dmcFetchStallCycles = 4
+
+// The delta modulation channel at $4010-$4013.  It plays 1-bit delta-encoded samples that are
+// read directly from CPU memory, stealing cycles from the CPU to do so.
+type dmc struct {
+	// Sample bytes are read from here.
+	mem cpu.MemoryInterface
+
+	// $4010 bit 7.  If set, the IRQ flag is raised when a non-looping sample ends.
+	irqEnabled bool
+
+	// $4010 bit 6.  If set, the sample restarts when it ends.
+	loop bool
+
+	// Set when a non-looping sample ends and irqEnabled is set.  Visible in $4015 bit 7.
+	irqFlag bool
+
+	// The timer period in CPU cycles, from dmcRateTable.
+	period uint16
+
+	// Counts down from 'period' - 1.  When it wraps, one bit of the shift register is played, so
+	// bits are played every 'period' cycles.
+	timer uint16
+
+	// The sample starts at 0xC000 + 64 * $4012.
+	sampleAddr uint16
+
+	// The sample is 16 * $4013 + 1 bytes long.
+	sampleLength uint16
+
+	// The memory reader's position in the sample.
+	currentAddr uint16
+
+	// How many bytes of the sample are left to read?  The channel is "active" when this is
+	// non-zero.
+	bytesRemaining uint16
+
+	// The memory reader fills this one byte buffer and the output unit empties it.
+	sampleBuffer uint8
+	sampleBufferFull bool
+
+	// The output unit plays the 8 bits of the shift register, LSB first.
+	shiftReg uint8
+	bitsRemaining uint8
+
+	// Set when the output unit starts a new byte with an empty sample buffer.  The output
+	// level is not changed while silenced.
+	silence bool
+
+	// The 7-bit output level.  Bits from the sample add or subtract 2 from this.
+	level uint8
+}
+
+// Apply a write to one of the four registers of the channel.  'reg' is 0 to 3.
+func (d *dmc) write(reg uint16, val uint8) {
+	switch reg {
+	case 0:
+		// IL-- RRRR
+		d.irqEnabled = 0x80 == (val & 0x80)
+		d.loop = 0x40 == (val & 0x40)
+		d.period = dmcRateTable[val & 0x0f]
+		if !d.irqEnabled {
+			d.irqFlag = false
+		}
+	case 1:
+		// -DDD DDDD: load the output level directly.
+		d.level = val & 0x7f
+	case 2:
+		d.sampleAddr = 0xc000 + uint16(val) * 64
+	case 3:
+		d.sampleLength = uint16(val) * 16 + 1
+	}
+}
+
+// Enable or disable the channel via $4015.
+func (d *dmc) setEnabled(on bool) {
+	d.irqFlag = false
+	if !on {
+		d.bytesRemaining = 0
+	} else if 0 == d.bytesRemaining {
+		d.restart()
+	}
+}
+
+// Start playing the sample from the beginning.
+func (d *dmc) restart() {
+	d.currentAddr = d.sampleAddr
+	d.bytesRemaining = d.sampleLength
+}
+
+// Clock the timer.  Called once per CPU cycle.  Returns how many cycles the CPU should be
+// stalled for because of a sample fetch.
+func (d *dmc) clockTimer() (stall uint64) {
+	stall = d.fillSampleBuffer()
+
+	if d.timer > 0 {
+		d.timer--
+		return
+	}
+	d.timer = d.period - 1
+
+	// Play one bit.  The level is kept within [0, 127].
+	if !d.silence {
+		if 1 == (d.shiftReg & 1) {
+			if d.level <= 125 {
+				d.level += 2
+			}
+		} else if d.level >= 2 {
+			d.level -= 2
+		}
+	}
+	d.shiftReg >>= 1
+
+	if d.bitsRemaining > 0 {
+		d.bitsRemaining--
+	}
+
+	// Start a new output cycle with the contents of the sample buffer.
+	if 0 == d.bitsRemaining {
+		d.bitsRemaining = 8
+		if d.sampleBufferFull {
+			d.silence = false
+			d.shiftReg = d.sampleBuffer
+			d.sampleBufferFull = false
+		} else {
+			d.silence = true
+		}
+	}
+	return
+}
+
+// The memory reader refills the sample buffer as soon as it is emptied.  Returns how many cycles
+// the CPU should be stalled for.
+func (d *dmc) fillSampleBuffer() uint64 {
+	if d.sampleBufferFull || 0 == d.bytesRemaining {
+		return 0
+	}
+
+	d.sampleBuffer = d.mem.Read(d.currentAddr)
+	d.sampleBufferFull = true
+
+	// The address wraps around to 0x8000, not 0x0000.
+	if 0xffff == d.currentAddr {
+		d.currentAddr = 0x8000
+	} else {
+		d.currentAddr++
+	}
+
+	d.bytesRemaining--
+	if 0 == d.bytesRemaining {
+		if d.loop {
+			d.restart()
+		} else if d.irqEnabled {
+			d.irqFlag = true
+		}
+	}
+
+	return dmcFetchStallCycles
+}
+
+// The current output level, 0 to 127.
+func (d *dmc) output() uint8 {
+	return d.level
+}
@@ -0,0 +1,24 @@
+package apu
+
+// The channels are mixed non-linearly on hardware.  The formulas below are from
+// http://wiki.nesdev.com/w/index.php/APU_Mixer and are precomputed into lookup tables.
+//
+//   pulse_out = 95.52 / (8128.0 / (pulse1 + pulse2) + 100)
+//   tnd_out = 163.67 / (24329.0 / (3 * triangle + 2 * noise + dmc) + 100)
+
+// Indexed by pulse1 + pulse2, each of which is 0 to 15.
+var pulseMixTable [31]float32
+
+// Indexed by 3 * triangle + 2 * noise + dmc.  The triangle and noise are 0 to 15 and the DMC is
+// 0 to 127.
+var tndMixTable [203]float32
+
+func init() {
+	for i := 1; i < len(pulseMixTable); i++ {
+		pulseMixTable[i] = float32(95.52 / (8128.0 / float64(i) + 100))
+	}
+
+	for i := 1; i < len(tndMixTable); i++ {
+		tndMixTable[i] = float32(163.67 / (24329.0 / float64(i) + 100))
+	}
+}
@@ -0,0 +1,72 @@
+package apu
+
+// Timer periods for the noise channel, in CPU cycles, selected by the low 4 bits of $400E.
+var noisePeriodTable = [16]uint16 {
+	4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068,
+}
+
+// The noise channel at $400C-$400F.  Pseudo-random output comes from a 15-bit linear feedback
+// shift register.
+type noise struct {
+	env envelope
+	length lengthCounter
+
+	// If set, feedback comes from bit 6 instead of bit 1, producing a short, metallic loop.
+	mode bool
+
+	// The timer period in CPU cycles, from noisePeriodTable.
+	period uint16
+
+	// Counts down from 'period' - 1, so the shift register is clocked every 'period' cycles.
+	timer uint16
+
+	// The linear feedback shift register.  Must never be 0, and is 1 at power-up.
+	shiftReg uint16
+}
+
+// Apply a write to one of the four registers of the channel.  'reg' is 0 to 3.
+func (n *noise) write(reg uint16, val uint8) {
+	switch reg {
+	case 0:
+		// --LC VVVV
+		n.length.halt = 0x20 == (val & 0x20)
+		n.env.write(val)
+	case 1:
+		// Unused.
+	case 2:
+		// M--- PPPP
+		n.mode = 0x80 == (val & 0x80)
+		n.period = noisePeriodTable[val & 0x0f]
+	case 3:
+		// LLLL L---
+		n.length.load(val >> 3)
+		n.env.start = true
+	}
+}
+
+// Clock the timer.  The periods in noisePeriodTable are in CPU cycles, so this is called once per
+// CPU cycle.
+func (n *noise) clockTimer() {
+	if n.timer > 0 {
+		n.timer--
+		return
+	}
+
+	n.timer = n.period - 1
+
+	var tap uint16 = 1
+	if n.mode {
+		tap = 6
+	}
+	feedback := (n.shiftReg ^ (n.shiftReg >> tap)) & 1
+	n.shiftReg >>= 1
+	n.shiftReg |= feedback << 14
+}
+
+// The current output level, 0 to 15.
+func (n *noise) output() uint8 {
+	if 0 == n.length.count || 1 == (n.shiftReg & 1) {
+		return 0
+	}
+	return n.env.output()
+}
@@ -0,0 +1,73 @@
+package apu
+
+// The 8-step waveforms selectable via the duty bits of the pulse channels' 1st register.
+var dutyTable = [4][8]uint8 {
+	{0, 1, 0, 0, 0, 0, 0, 0},
+	{0, 1, 1, 0, 0, 0, 0, 0},
+	{0, 1, 1, 1, 1, 0, 0, 0},
+	{1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+// One of the two square wave channels.  Pulse 1 is at $4000-$4003 and pulse 2 is at
+// $4004-$4007.
+type pulse struct {
+	env envelope
+	length lengthCounter
+	sweep sweep
+
+	// Which of the waveforms in dutyTable is being played.
+	duty uint8
+
+	// Where in the 8-step waveform are we?
+	dutyPos uint8
+
+	// The 11-bit timer period.  The timer is clocked every other CPU cycle.
+	period uint16
+
+	// Counts down from 'period'.  When it wraps the waveform advances one step.
+	timer uint16
+}
+
+// Apply a write to one of the four registers of the channel.  'reg' is 0 to 3.
+func (p *pulse) write(reg uint16, val uint8) {
+	switch reg {
+	case 0:
+		// DDLC VVVV
+		p.duty = val >> 6
+		p.length.halt = 0x20 == (val & 0x20)
+		p.env.write(val)
+	case 1:
+		p.sweep.write(val)
+	case 2:
+		// Low 8 bits of the period.
+		p.period = (p.period & 0x700) | uint16(val)
+	case 3:
+		// LLLL LTTT: length counter load and high 3 bits of the period.  This also restarts
+		// the envelope and the waveform.
+		p.period = (p.period & 0xff) | (uint16(val & 7) << 8)
+		p.length.load(val >> 3)
+		p.env.start = true
+		p.dutyPos = 0
+	}
+}
+
+// Clock the timer.  Called once per APU cycle (every 2 CPU cycles).
+func (p *pulse) clockTimer() {
+	if p.timer > 0 {
+		p.timer--
+	} else {
+		p.timer = p.period
+		p.dutyPos = (p.dutyPos + 1) & 7
+	}
+}
+
+// The current output level, 0 to 15.
+func (p *pulse) output() uint8 {
+	if 0 == p.length.count || p.sweep.mutes(p.period) {
+		return 0
+	}
+	if 0 == dutyTable[p.duty][p.dutyPos] {
+		return 0
+	}
+	return p.env.output()
+}
@@ -0,0 +1,156 @@
+package apu
+
+import "math"
+
+// The APU produces a new output level every CPU cycle, about 1.79 million times a second.  That
+// has to be brought down to something a sound card can play (44.1 or 48 kHz) without aliasing.
+//
+// Rather than filtering every one of those levels, we note that the output only changes every so
+// often, and represent it as a series of steps.  Each step is added to the output as a
+// band-limited step (an integrated windowed sinc) placed at its exact fractional position between
+// output samples.  This is the approach used by Blargg's blip_buf.
+
+// The NTSC CPU clock rate in Hz.
+const CPUClockRate = 1789773.0
+
+const (
+	// How many output samples each step is spread over.
+	resamplerTaps = 16
+
+	// How many fractional positions between output samples have their own kernel.
+	resamplerPhases = 64
+
+	// The cutoff of the low-pass filter, as a fraction of the output sample rate.  Slightly
+	// below the Nyquist frequency so that the transition band doesn't alias.
+	resamplerCutoff = 0.45
+
+	// How many output samples can be buffered before the oldest are dropped.
+	resamplerBufferSize = 8192
+)
+
+// kernel[phase][tap] is the band-limited impulse for a step that happens 'phase/resamplerPhases'
+// of the way between two output samples.  Each phase sums to 1.
+var kernel [resamplerPhases][resamplerTaps]float32
+
+func init() {
+	for phase := 0; phase < resamplerPhases; phase++ {
+		offset := float64(phase) / resamplerPhases
+		sum := 0.0
+		var taps [resamplerTaps]float64
+
+		for tap := 0; tap < resamplerTaps; tap++ {
+			// Distance from the center of the kernel, in output samples.
+			t := float64(tap) - resamplerTaps / 2 + 1 - offset
+
+			// Windowed sinc.
+			x := 2 * resamplerCutoff * t
+			sinc := 1.0
+			if 0 != x {
+				sinc = math.Sin(math.Pi * x) / (math.Pi * x)
+			}
+			window := 0.5 + 0.5 * math.Cos(math.Pi * t / (resamplerTaps / 2))
+
+			taps[tap] = sinc * window
+			sum += taps[tap]
+		}
+
+		for tap := 0; tap < resamplerTaps; tap++ {
+			kernel[phase][tap] = float32(taps[tap] / sum)
+		}
+	}
+}
+
+// Converts the per-cycle APU output level into signed 16-bit samples at the output rate.
+type resampler struct {
+	// How many output samples there are per CPU cycle.  0 if no output is wanted.
+	ratio float64
+
+	// Where the current CPU cycle falls, in output samples from the start of 'deltas'.
+	pos float64
+
+	// The changes in level that fall near each output sample.  These are summed up when the
+	// samples are read.
+	deltas [resamplerBufferSize + resamplerTaps]float32
+
+	// The last level passed to addLevel.
+	level float32
+
+	// The running sum of 'deltas' as samples are read out.
+	integrator float32
+
+	// State for the DC-blocking high-pass filter applied on output.  The NES output is always
+	// positive and has a big DC offset as channels turn on and off.
+	lastIn float32
+	lastOut float32
+}
+
+// Set the output sample rate in Hz.  Can be changed at any time to speed up or slow down output
+// slightly.
+func (rs *resampler) setSampleRate(rate float64) {
+	rs.ratio = rate / CPUClockRate
+}
+
+// Record the APU level for the current CPU cycle and advance to the next one.
+func (rs *resampler) addLevel(level float32) {
+	if 0 == rs.ratio {
+		return
+	}
+
+	if level != rs.level {
+		delta := level - rs.level
+		rs.level = level
+
+		whole := int(rs.pos)
+		phase := int((rs.pos - float64(whole)) * resamplerPhases)
+		for tap := 0; tap < resamplerTaps; tap++ {
+			rs.deltas[whole + tap] += delta * kernel[phase][tap]
+		}
+	}
+
+	rs.pos += rs.ratio
+
+	// Nobody is reading the samples.  Drop the oldest half rather than grow without bound.
+	if int(rs.pos) >= resamplerBufferSize {
+		rs.read(nil, resamplerBufferSize / 2)
+	}
+}
+
+// How many samples are complete and can be read.
+func (rs *resampler) available() int {
+	return int(rs.pos)
+}
+
+// Read up to 'count' completed samples into 'out', which may be nil to discard them.  Returns how
+// many were read.
+func (rs *resampler) read(out []int16, count int) int {
+	if count > rs.available() {
+		count = rs.available()
+	}
+
+	for i := 0; i < count; i++ {
+		rs.integrator += rs.deltas[i]
+
+		// y[n] = x[n] - x[n-1] + R * y[n-1] with a corner around 40 Hz.
+		filtered := rs.integrator - rs.lastIn + 0.995 * rs.lastOut
+		rs.lastIn = rs.integrator
+		rs.lastOut = filtered
+
+		if nil != out {
+			sample := filtered * 32767
+			if sample > 32767 {
+				sample = 32767
+			} else if sample < -32768 {
+				sample = -32768
+			}
+			out[i] = int16(sample)
+		}
+	}
+
+	// Move the deltas for samples that aren't complete yet to the front.
+	remaining := copy(rs.deltas[:], rs.deltas[count:])
+	for i := remaining; i < len(rs.deltas); i++ {
+		rs.deltas[i] = 0
+	}
+	rs.pos -= float64(count)
+	return count
+}
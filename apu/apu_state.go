@@ -0,0 +1,105 @@
+package apu
+
+import "savestate"
+
+// Save the state of every channel and the frame counter.
+func (apu *APU) Save(w *savestate.Writer) {
+	apu.state(w)
+}
+
+// Load what Save saved.
+func (apu *APU) Load(r *savestate.Reader) {
+	apu.state(r)
+}
+
+// Save or load the APU's state.  The resampler isn't included; it only holds audio that's
+// already been produced.
+func (apu *APU) state(s savestate.Stream) {
+	apu.pulse1.state(s)
+	apu.pulse2.state(s)
+	apu.triangle.state(s)
+	apu.noise.state(s)
+	apu.dmc.state(s)
+
+	s.Uint64(&apu.frameCycle)
+	s.Bool(&apu.fiveStep)
+	s.Bool(&apu.frameIRQInhibit)
+	s.Bool(&apu.frameIRQ)
+	s.Int(&apu.frameResetDelay)
+	s.Bool(&apu.oddCycle)
+}
+
+func (lc *lengthCounter) state(s savestate.Stream) {
+	s.Bool(&lc.enabled)
+	s.Bool(&lc.halt)
+	s.Uint8(&lc.count)
+}
+
+func (env *envelope) state(s savestate.Stream) {
+	s.Bool(&env.start)
+	s.Bool(&env.loop)
+	s.Bool(&env.constant)
+	s.Uint8(&env.volume)
+	s.Uint8(&env.divider)
+	s.Uint8(&env.decay)
+}
+
+// onesComplement is fixed per channel, so it isn't saved.
+func (sw *sweep) state(s savestate.Stream) {
+	s.Bool(&sw.enabled)
+	s.Uint8(&sw.period)
+	s.Bool(&sw.negate)
+	s.Uint8(&sw.shift)
+	s.Bool(&sw.reload)
+	s.Uint8(&sw.divider)
+}
+
+func (p *pulse) state(s savestate.Stream) {
+	p.env.state(s)
+	p.length.state(s)
+	p.sweep.state(s)
+	s.Uint8(&p.duty)
+	s.Uint8(&p.dutyPos)
+	s.Check(int(p.duty) < len(dutyTable) && int(p.dutyPos) < len(dutyTable[0]), "pulse duty")
+	s.Uint16(&p.period)
+	s.Uint16(&p.timer)
+}
+
+func (t *triangle) state(s savestate.Stream) {
+	t.length.state(s)
+	s.Bool(&t.control)
+	s.Uint8(&t.linearReload)
+	s.Bool(&t.linearReloadFlag)
+	s.Uint8(&t.linearCounter)
+	s.Uint16(&t.period)
+	s.Uint16(&t.timer)
+	s.Uint8(&t.seqPos)
+	s.Check(int(t.seqPos) < len(triangleTable), "triangle sequence")
+}
+
+func (n *noise) state(s savestate.Stream) {
+	n.env.state(s)
+	n.length.state(s)
+	s.Bool(&n.mode)
+	s.Uint16(&n.period)
+	s.Uint16(&n.timer)
+	s.Uint16(&n.shiftReg)
+}
+
+func (d *dmc) state(s savestate.Stream) {
+	s.Bool(&d.irqEnabled)
+	s.Bool(&d.loop)
+	s.Bool(&d.irqFlag)
+	s.Uint16(&d.period)
+	s.Uint16(&d.timer)
+	s.Uint16(&d.sampleAddr)
+	s.Uint16(&d.sampleLength)
+	s.Uint16(&d.currentAddr)
+	s.Uint16(&d.bytesRemaining)
+	s.Uint8(&d.sampleBuffer)
+	s.Bool(&d.sampleBufferFull)
+	s.Uint8(&d.shiftReg)
+	s.Uint8(&d.bitsRemaining)
+	s.Bool(&d.silence)
+	s.Uint8(&d.level)
+}
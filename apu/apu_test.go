@@ -0,0 +1,194 @@
+package apu
+
+import "testing"
+
+// Step 'apu' until its CPU cycle count into the frame sequence is 'cycle'.
+func stepTo(apu *APU, cycle uint64) {
+	apu.Step(cycle - apu.frameCycle)
+}
+
+// The length counter is clocked on the half frames of the 4-step sequence, and the frame
+// interrupt is raised at the end of it.
+func TestFrameSequencer4(t *testing.T) {
+	apu := NewAPU()
+	apu.WriteRegister(APUSTATUS, 0x01)
+	apu.WriteRegister(0x4003, 0x08) // Length 254
+
+	stepTo(apu, frameStep2 - 1)
+	if 254 != apu.pulse1.length.count {
+		t.Fatalf("Length counter clocked early, count %d", apu.pulse1.length.count)
+	}
+	apu.Step(1)
+	if 253 != apu.pulse1.length.count {
+		t.Fatalf("Expected the length counter clocked at cycle %d, count %d", frameStep2,
+			 apu.pulse1.length.count)
+	}
+
+	stepTo(apu, frameIRQStart4 - 1)
+	if 0 != (apu.ReadStatus() & 0x40) {
+		t.Fatal("Frame interrupt raised early")
+	}
+	apu.Step(1)
+	if 0x40 != (apu.ReadStatus() & 0x40) {
+		t.Fatalf("Expected the frame interrupt at cycle %d", frameIRQStart4)
+	}
+	apu.Step(1)
+	if 252 != apu.pulse1.length.count {
+		t.Errorf("Expected the length counter clocked at cycle %d, count %d", frameStep4,
+			 apu.pulse1.length.count)
+	}
+}
+
+// The 5-step sequence clocks everything as it's selected, never raises the frame interrupt, and
+// has its last half frame at the 5th step.
+func TestFrameSequencer5(t *testing.T) {
+	apu := NewAPU()
+	apu.WriteRegister(APUSTATUS, 0x01)
+	apu.WriteRegister(0x4003, 0x08) // Length 254
+	apu.WriteRegister(APUFRAME, 0x80)
+
+	apu.Step(3)
+	if 253 != apu.pulse1.length.count {
+		t.Fatalf("Expected selecting the 5-step sequence to clock the length counter, count %d",
+			 apu.pulse1.length.count)
+	}
+
+	stepTo(apu, frameStep5 - 1)
+	if 252 != apu.pulse1.length.count {
+		t.Fatalf("Expected one clock at cycle %d, count %d", frameStep2, apu.pulse1.length.count)
+	}
+	apu.Step(1)
+	if 251 != apu.pulse1.length.count {
+		t.Errorf("Expected the length counter clocked at cycle %d, count %d", frameStep5,
+			 apu.pulse1.length.count)
+	}
+	if 0 != (apu.ReadStatus() & 0x40) {
+		t.Error("The 5-step sequence raised the frame interrupt")
+	}
+}
+
+func TestLengthCounter(t *testing.T) {
+	var lc lengthCounter
+
+	// Loads are ignored while the channel is disabled.
+	lc.load(1)
+	if 0 != lc.count {
+		t.Fatal("Loaded a disabled length counter")
+	}
+
+	lc.setEnabled(true)
+	lc.load(3)
+	if 2 != lc.count {
+		t.Fatalf("Expected a length of 2, got %d", lc.count)
+	}
+
+	lc.halt = true
+	lc.clock()
+	if 2 != lc.count {
+		t.Fatal("A halted length counter was clocked")
+	}
+
+	lc.halt = false
+	lc.clock()
+	lc.clock()
+	lc.clock()
+	if 0 != lc.count {
+		t.Fatalf("Expected the length counter to stop at 0, got %d", lc.count)
+	}
+
+	// Reloading works whatever the count is, and disabling clears it.
+	lc.load(0)
+	if 10 != lc.count {
+		t.Fatalf("Expected a length of 10, got %d", lc.count)
+	}
+	lc.setEnabled(false)
+	if 0 != lc.count {
+		t.Error("Disabling didn't clear the length counter")
+	}
+}
+
+// Memory that's all the same byte.
+type fillMemory uint8
+
+func (m fillMemory) Read(addr uint16) uint8 {
+	return uint8(m)
+}
+
+func (m fillMemory) Write(addr uint16, val uint8) uint64 {
+	return 0
+}
+
+func TestStatus(t *testing.T) {
+	apu := NewAPU()
+	apu.ConnectMemory(fillMemory(0))
+	apu.WriteRegister(APUSTATUS, 0x0f)
+	apu.WriteRegister(0x4003, 0x08)
+	apu.WriteRegister(0x400b, 0x08)
+	apu.WriteRegister(0x400f, 0x08)
+	if 0x0d != apu.ReadStatus() {
+		t.Fatalf("Expected pulse 1, triangle and noise playing, got %02X", apu.ReadStatus())
+	}
+
+	// Disabling a channel silences it.
+	apu.WriteRegister(APUSTATUS, 0x05)
+	if 0x05 != apu.ReadStatus() {
+		t.Fatalf("Expected noise silenced, got %02X", apu.ReadStatus())
+	}
+
+	// A one byte sample with the interrupt on.  The DMC is active until it's fetched.
+	apu.WriteRegister(0x4010, 0x80)
+	apu.WriteRegister(0x4013, 0)
+	apu.WriteRegister(APUSTATUS, 0x15)
+	if 0x15 != apu.ReadStatus() {
+		t.Fatalf("Expected the DMC active, got %02X", apu.ReadStatus())
+	}
+	apu.Step(1)
+	if 0x85 != apu.ReadStatus() {
+		t.Fatalf("Expected the DMC interrupt, got %02X", apu.ReadStatus())
+	}
+
+	// Reading clears the frame interrupt but not the DMC's, which writing clears.
+	apu.frameIRQ = true
+	if 0xc5 != apu.ReadStatus() {
+		t.Fatalf("Expected both interrupts, got %02X", apu.ReadStatus())
+	}
+	if 0x85 != apu.ReadStatus() {
+		t.Fatalf("Expected reading to clear the frame interrupt, got %02X", apu.ReadStatus())
+	}
+	apu.WriteRegister(APUSTATUS, 0x05)
+	if 0x05 != apu.ReadStatus() {
+		t.Errorf("Expected writing to clear the DMC interrupt, got %02X", apu.ReadStatus())
+	}
+}
+
+// The noise shift register is clocked once every period from noisePeriodTable.
+func TestNoisePeriod(t *testing.T) {
+	n := noise{period: noisePeriodTable[0], shiftReg: 1}
+	clocks := 0
+	for i := 0; i < 400; i++ {
+		last := n.shiftReg
+		n.clockTimer()
+		if last != n.shiftReg {
+			clocks++
+		}
+	}
+	if 400 / int(noisePeriodTable[0]) != clocks {
+		t.Errorf("Expected %d clocks in 400 cycles, got %d", 400 / noisePeriodTable[0], clocks)
+	}
+}
+
+// The DMC plays one bit every period from dmcRateTable.
+func TestDMCPeriod(t *testing.T) {
+	d := dmc{period: dmcRateTable[0], shiftReg: 0xff, bitsRemaining: 8}
+	cycles := 3 * int(d.period)
+	for i := 0; i < cycles; i++ {
+		d.clockTimer()
+	}
+	if 6 != d.level {
+		t.Fatalf("Expected 3 bits played in %d cycles, level is %d", cycles, d.level)
+	}
+	d.clockTimer()
+	if 8 != d.level {
+		t.Errorf("Expected the 4th bit played after %d cycles, level is %d", cycles + 1, d.level)
+	}
+}
@@ -0,0 +1,94 @@
+package apu
+
+// The 32-step waveform of the triangle channel.
+var triangleTable = [32]uint8 {
+	15, 14, 13, 12, 11, 10,  9,  8,  7,  6,  5,  4,  3,  2,  1,  0,
+	 0,  1,  2,  3,  4,  5,  6,  7,  8,  9, 10, 11, 12, 13, 14, 15,
+}
+
+// The triangle channel at $4008-$400B.  It has no volume control, but in addition to the length
+// counter it has a second "linear" counter with a finer resolution.
+type triangle struct {
+	length lengthCounter
+
+	// Set by the control bit in $4008.  Shares a bit with the length counter halt flag and
+	// prevents the linear counter reload flag from being cleared.
+	control bool
+
+	// The value the linear counter is reloaded with.
+	linearReload uint8
+
+	// Set by a write to $400B.  The linear counter is reloaded on the next quarter frame.
+	linearReloadFlag bool
+
+	// Counts down quarter frames.  The channel is silenced when this reaches 0.
+	linearCounter uint8
+
+	// The 11-bit timer period.  Unlike the other channels this timer is clocked every CPU
+	// cycle.
+	period uint16
+
+	// Counts down from 'period'.
+	timer uint16
+
+	// Where in the 32-step waveform are we?
+	seqPos uint8
+}
+
+// Apply a write to one of the four registers of the channel.  'reg' is 0 to 3.
+func (t *triangle) write(reg uint16, val uint8) {
+	switch reg {
+	case 0:
+		// CRRR RRRR
+		t.control = 0x80 == (val & 0x80)
+		t.length.halt = t.control
+		t.linearReload = val & 0x7f
+	case 1:
+		// Unused.
+	case 2:
+		t.period = (t.period & 0x700) | uint16(val)
+	case 3:
+		t.period = (t.period & 0xff) | (uint16(val & 7) << 8)
+		t.length.load(val >> 3)
+		t.linearReloadFlag = true
+	}
+}
+
+// Called on every quarter frame.
+func (t *triangle) clockLinear() {
+	if t.linearReloadFlag {
+		t.linearCounter = t.linearReload
+	} else if t.linearCounter > 0 {
+		t.linearCounter--
+	}
+
+	if !t.control {
+		t.linearReloadFlag = false
+	}
+}
+
+// Clock the timer.  Called once per CPU cycle.
+func (t *triangle) clockTimer() {
+	if t.timer > 0 {
+		t.timer--
+		return
+	}
+
+	t.timer = t.period
+
+	// The sequencer only advances when both counters are non-zero.  This means the channel
+	// holds its last level instead of dropping to 0 when silenced, as on hardware.
+	if t.length.count > 0 && t.linearCounter > 0 {
+		t.seqPos = (t.seqPos + 1) & 31
+	}
+}
+
+// The current output level, 0 to 15.
+func (t *triangle) output() uint8 {
+	// Very low periods produce ultrasonic frequencies that games use to silence the channel.
+	// Hardware would output a ~7.5 level, so we do the same instead of aliasing.
+	if t.period < 2 {
+		return 7
+	}
+	return triangleTable[t.seqPos]
+}
@@ -0,0 +1,175 @@
+package apu
+
+// The building blocks shared by several of the APU channels: the envelope generator, the length
+// counter and the sweep unit.  See http://wiki.nesdev.com/w/index.php/APU for details.
+
+// Writing the top 5 bits of the 4th register of a channel loads the length counter with one of
+// these values.
+var lengthTable = [32]uint8 {
+	10, 254, 20,  2, 40,  4, 80,  6, 160,  8, 60, 10, 14, 12, 26, 14,
+	12,  16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+// The length counter silences a channel after a programmable amount of time.  It is clocked by
+// the half-frame signal from the frame sequencer.
+type lengthCounter struct {
+	// Is the channel enabled via $4015?  When disabled the counter is forced to 0.
+	enabled bool
+
+	// When set, the counter is not decremented.  This shares a bit with the envelope loop flag.
+	halt bool
+
+	// The current count.  The channel is silenced when this reaches 0.
+	count uint8
+}
+
+// Load the counter from the 5-bit index written to the channel's 4th register.
+func (lc *lengthCounter) load(index uint8) {
+	if lc.enabled {
+		lc.count = lengthTable[index & 0x1f]
+	}
+}
+
+// Enable or disable the counter via $4015.
+func (lc *lengthCounter) setEnabled(on bool) {
+	lc.enabled = on
+	if !on {
+		lc.count = 0
+	}
+}
+
+// Called on every half frame.
+func (lc *lengthCounter) clock() {
+	if !lc.halt && lc.count > 0 {
+		lc.count--
+	}
+}
+
+// The envelope generator produces either a constant volume or a decaying saw envelope.  It is
+// clocked by the quarter-frame signal from the frame sequencer.
+type envelope struct {
+	// Set by a write to the channel's 4th register, restarts the envelope on the next clock.
+	start bool
+
+	// If set, the decay level wraps from 0 back to 15 instead of staying at 0.
+	loop bool
+
+	// If set, 'volume' is output directly instead of the decay level.
+	constant bool
+
+	// Either the constant volume or the reload value for the divider.
+	volume uint8
+
+	// The divider counts down from 'volume' and clocks the decay level when it hits 0.
+	divider uint8
+
+	// The current level of the decaying envelope.
+	decay uint8
+}
+
+// Apply a write to the channel's 1st register.  Layout is --LC VVVV.
+func (env *envelope) write(val uint8) {
+	env.loop = 0x20 == (val & 0x20)
+	env.constant = 0x10 == (val & 0x10)
+	env.volume = val & 0x0f
+}
+
+// Called on every quarter frame.
+func (env *envelope) clock() {
+	if env.start {
+		env.start = false
+		env.decay = 15
+		env.divider = env.volume
+		return
+	}
+
+	if env.divider > 0 {
+		env.divider--
+		return
+	}
+
+	env.divider = env.volume
+	if env.decay > 0 {
+		env.decay--
+	} else if env.loop {
+		env.decay = 15
+	}
+}
+
+// The volume the envelope is currently outputting, 0 to 15.
+func (env *envelope) output() uint8 {
+	if env.constant {
+		return env.volume
+	}
+	return env.decay
+}
+
+// The sweep unit periodically adjusts the period of a pulse channel.  It is clocked by the
+// half-frame signal from the frame sequencer.
+type sweep struct {
+	enabled bool
+
+	// The divider's period is (period + 1) half frames.
+	period uint8
+
+	// If set, the period is decreased (the pitch goes up).
+	negate bool
+
+	// How far to shift the channel period to compute the change amount.
+	shift uint8
+
+	// Set by a write to the sweep register, reloads the divider on the next clock.
+	reload bool
+
+	// Counts down half frames until the next adjustment.
+	divider uint8
+
+	// The two pulse channels negate differently.  Pulse 1 uses one's complement and pulse 2
+	// uses two's complement.
+	onesComplement bool
+}
+
+// Apply a write to the sweep register.  Layout is EPPP NSSS.
+func (sw *sweep) write(val uint8) {
+	sw.enabled = 0x80 == (val & 0x80)
+	sw.period = (val >> 4) & 7
+	sw.negate = 0x08 == (val & 0x08)
+	sw.shift = val & 7
+	sw.reload = true
+}
+
+// The period the sweep unit wants the channel to have, computed continuously.
+func (sw *sweep) targetPeriod(current uint16) uint16 {
+	change := current >> sw.shift
+	if !sw.negate {
+		return current + change
+	}
+
+	if sw.onesComplement {
+		// Pulse 1 subtracts one more than pulse 2 does.
+		change++
+	}
+	if change > current {
+		return 0
+	}
+	return current - change
+}
+
+// Is the channel silenced by the sweep unit?  This happens even if the sweep is disabled.
+func (sw *sweep) mutes(current uint16) bool {
+	return current < 8 || sw.targetPeriod(current) > 0x7ff
+}
+
+// Called on every half frame.  Updates 'period' if an adjustment is due.
+func (sw *sweep) clock(period *uint16) {
+	if 0 == sw.divider && sw.enabled && sw.shift > 0 && !sw.mutes(*period) {
+		*period = sw.targetPeriod(*period)
+	}
+
+	if 0 == sw.divider || sw.reload {
+		sw.divider = sw.period
+		sw.reload = false
+	} else {
+		sw.divider--
+	}
+}
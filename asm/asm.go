@@ -0,0 +1,505 @@
+package asm
+
+// This package is a small 6502 assembler, for writing CPU tests and ROM patches as source rather
+// than bytes.  It knows the instructions in the CPU's op table, by the same names, including the
+// undocumented ones.
+//
+// The syntax is the usual one:
+//
+//         .org $C000         ; Where the following code goes.
+// start:  LDA #$10           ; Labels end with ':'.
+//         STA $0200,X
+//         LDA ($20),Y
+//         ASL A
+//         BNE start
+//         JMP (vector)
+// vector: .word start        ; Little-endian 16-bit values.
+// table:  .byte 1, $02, %11, <start, >start
+//
+// Numbers are decimal, hex ($) or binary (%).  Operands can be labels, and simple sums of numbers
+// and labels.  '<' and '>' take the low and high byte.  An operand below $100 uses zero page
+// addressing if the instruction has it, unless it refers to a label that's defined later.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cpu"
+)
+
+// A contiguous run of assembled bytes.
+type Segment struct {
+	Org uint16
+	Bytes []byte
+}
+
+// The output of the assembler.
+type Program struct {
+	// In the order they appear in the source.  A new one is started by each .org.
+	Segments []Segment
+
+	// The address of each label.
+	Labels map[string]uint16
+}
+
+// Write the program into 'mem'.
+func (prog *Program) Load(mem cpu.MemoryInterface) {
+	for _, seg := range prog.Segments {
+		for i, b := range seg.Bytes {
+			mem.Write(seg.Org + uint16(i), b)
+		}
+	}
+}
+
+// Copy the parts of the program that are in [base, base + len(rom)) into 'rom'.  Used to patch a
+// PRG-ROM bank that's mapped at 'base'.  Returns an error if any of the program falls outside it.
+func (prog *Program) Patch(rom []byte, base uint16) error {
+	for _, seg := range prog.Segments {
+		start := int(seg.Org) - int(base)
+		if start < 0 || start + len(seg.Bytes) > len(rom) {
+			return fmt.Errorf("$%04X-$%04X is outside the ROM at $%04X-$%04X", seg.Org,
+					  int(seg.Org) + len(seg.Bytes) - 1, base, int(base) + len(rom) - 1)
+		}
+		copy(rom[start:], seg.Bytes)
+	}
+	return nil
+}
+
+// An error in the source.
+type Error struct {
+	// Numbered from 1.
+	Line int
+	Msg string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", err.Line, err.Msg)
+}
+
+// The opcode for each instruction name and addressing mode.
+var opcodes = make(map[string]map[int]uint8)
+
+func init() {
+	// Some instructions have more than one opcode for the same addressing mode.  The documented
+	// one is preferred, then the lowest.
+	for i := 0; i < 0x100; i++ {
+		opcode := uint8(i)
+		info, ok := cpu.LookupOpcode(opcode)
+		if !ok {
+			continue
+		}
+
+		modes := opcodes[info.Name]
+		if nil == modes {
+			modes = make(map[int]uint8)
+			opcodes[info.Name] = modes
+		}
+		if existing, ok := modes[info.Addressing]; ok {
+			existingInfo, _ := cpu.LookupOpcode(existing)
+			if !existingInfo.Undocumented || info.Undocumented {
+				continue
+			}
+		}
+		modes[info.Addressing] = opcode
+	}
+}
+
+// One line of source, after parsing.
+type statement struct {
+	line int
+
+	// Set for instructions.
+	name string
+	addressing int
+	operand string
+
+	// Set for directives, including the dot.  'args' are the comma-separated arguments.
+	directive string
+	args []string
+
+	// Where it's assembled to, and how many bytes it takes.
+	addr uint16
+	size int
+}
+
+type assembler struct {
+	statements []*statement
+
+	// During the first pass, this only has the labels defined so far.
+	labels map[string]uint16
+}
+
+// Assemble 'source'.  The first error in it is returned as an *Error.
+func Assemble(source string) (prog *Program, err error) {
+	a := &assembler{labels: make(map[string]uint16)}
+
+	// First pass: parse, and work out where everything goes.
+	var addr uint16
+	for i, text := range strings.Split(source, "\n") {
+		stmt, labels, err := parseLine(text, i + 1)
+		if nil != err {
+			return nil, err
+		}
+
+		for _, label := range labels {
+			if _, ok := a.labels[label]; ok {
+				return nil, &Error{i + 1, fmt.Sprintf("%s is already defined", label)}
+			}
+			if nil != stmt && ".org" == stmt.directive {
+				// A label on an .org is at the new address.
+				continue
+			}
+			a.labels[label] = addr
+		}
+		if nil == stmt {
+			continue
+		}
+
+		if ".org" == stmt.directive {
+			if 1 != len(stmt.args) {
+				return nil, &Error{stmt.line, ".org needs one address"}
+			}
+			org, err := a.eval(stmt.args[0], stmt.line, true)
+			if nil != err {
+				return nil, err
+			}
+			addr = uint16(org)
+			for _, label := range labels {
+				a.labels[label] = addr
+			}
+		}
+
+		stmt.addr = addr
+		if err := a.size(stmt); nil != err {
+			return nil, err
+		}
+		addr += uint16(stmt.size)
+		a.statements = append(a.statements, stmt)
+	}
+
+	// Second pass: generate the bytes now that all the labels are known.
+	prog = &Program{Labels: a.labels}
+	for i, stmt := range a.statements {
+		if ".org" == stmt.directive || 0 == i {
+			prog.Segments = append(prog.Segments, Segment{Org: stmt.addr})
+		}
+
+		out, err := a.generate(stmt)
+		if nil != err {
+			return nil, err
+		}
+		seg := &prog.Segments[len(prog.Segments) - 1]
+		seg.Bytes = append(seg.Bytes, out...)
+	}
+
+	// Drop segments started by .org with nothing after them.
+	segments := prog.Segments[:0]
+	for _, seg := range prog.Segments {
+		if 0 != len(seg.Bytes) {
+			segments = append(segments, seg)
+		}
+	}
+	prog.Segments = segments
+	return prog, nil
+}
+
+// Split a line into its labels and statement.  The statement is nil for lines with only labels
+// and comments.
+func parseLine(text string, line int) (stmt *statement, labels []string, err error) {
+	if comment := strings.IndexByte(text, ';'); comment >= 0 {
+		text = text[:comment]
+	}
+	text = strings.TrimSpace(text)
+
+	// Labels come first, each ending with ':'.
+	for {
+		colon := strings.IndexByte(text, ':')
+		if colon < 0 {
+			break
+		}
+		label := strings.TrimSpace(text[:colon])
+		if !isIdentifier(label) {
+			return nil, nil, &Error{line, fmt.Sprintf("bad label %q", label)}
+		}
+		labels = append(labels, label)
+		text = strings.TrimSpace(text[colon + 1:])
+	}
+
+	if "" == text {
+		return nil, labels, nil
+	}
+
+	stmt = &statement{line: line}
+	word, rest := text, ""
+	if space := strings.IndexAny(text, " \t"); space >= 0 {
+		word, rest = text[:space], strings.TrimSpace(text[space + 1:])
+	}
+
+	if strings.HasPrefix(word, ".") {
+		stmt.directive = strings.ToLower(word)
+		switch stmt.directive {
+		case ".org", ".byte", ".word":
+		default:
+			return nil, nil, &Error{line, fmt.Sprintf("unknown directive %s", word)}
+		}
+		for _, arg := range strings.Split(rest, ",") {
+			if arg = strings.TrimSpace(arg); "" != arg {
+				stmt.args = append(stmt.args, arg)
+			}
+		}
+		return stmt, labels, nil
+	}
+
+	stmt.name = strings.ToUpper(word)
+	if _, ok := opcodes[stmt.name]; !ok {
+		return nil, nil, &Error{line, fmt.Sprintf("unknown instruction %s", word)}
+	}
+	stmt.operand = strings.Join(strings.Fields(rest), "")
+	return stmt, labels, nil
+}
+
+// Is 's' usable as a label?
+func isIdentifier(s string) bool {
+	if "" == s {
+		return false
+	}
+	for i, c := range s {
+		letter := ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || '_' == c
+		digit := '0' <= c && c <= '9'
+		if !letter && !(digit && i > 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Work out the addressing mode and size of 'stmt'.
+func (a *assembler) size(stmt *statement) (err error) {
+	switch stmt.directive {
+	case ".org":
+		stmt.size = 0
+		return nil
+	case ".byte":
+		stmt.size = len(stmt.args)
+		return nil
+	case ".word":
+		stmt.size = 2 * len(stmt.args)
+		return nil
+	}
+
+	stmt.addressing, err = a.chooseAddressing(stmt)
+	if nil != err {
+		return err
+	}
+	stmt.size = cpu.InstructionSize(stmt.addressing)
+	return nil
+}
+
+// Pick the addressing mode from the operand's syntax.  Operands that could be zero page or
+// absolute are zero page if their value is known to fit, and the instruction has that mode.
+func (a *assembler) chooseAddressing(stmt *statement) (int, error) {
+	modes := opcodes[stmt.name]
+	operand := stmt.operand
+	upper := strings.ToUpper(operand)
+
+	// Try each mode in order of preference, and use the first the instruction has.
+	var candidates []int
+	switch {
+	case "" == operand:
+		candidates = []int{cpu.IMP, cpu.ACC}
+	case "A" == upper:
+		candidates = []int{cpu.ACC}
+	case strings.HasPrefix(operand, "#"):
+		candidates = []int{cpu.IMM}
+	case strings.HasPrefix(operand, "(") && strings.HasSuffix(upper, ",X)"):
+		candidates = []int{cpu.INDX}
+	case strings.HasPrefix(operand, "(") && strings.HasSuffix(upper, "),Y"):
+		candidates = []int{cpu.INDY}
+	case strings.HasPrefix(operand, "(") && strings.HasSuffix(operand, ")"):
+		candidates = []int{cpu.IND}
+	case strings.HasSuffix(upper, ",X"):
+		candidates = a.zeroPageOrAbsolute(stmt, operand[:len(operand) - 2], cpu.ZPX, cpu.ABSX)
+	case strings.HasSuffix(upper, ",Y"):
+		candidates = a.zeroPageOrAbsolute(stmt, operand[:len(operand) - 2], cpu.ZPY, cpu.ABSY)
+	default:
+		candidates = a.zeroPageOrAbsolute(stmt, operand, cpu.ZP, cpu.ABS)
+		candidates = append([]int{cpu.REL}, candidates...)
+	}
+
+	for _, mode := range candidates {
+		if _, ok := modes[mode]; ok {
+			return mode, nil
+		}
+	}
+	return 0, &Error{stmt.line, fmt.Sprintf("%s can't take the operand %q", stmt.name, operand)}
+}
+
+// Order the zero page and absolute versions of a mode by preference for 'expr'.
+func (a *assembler) zeroPageOrAbsolute(stmt *statement, expr string, zp int, abs int) []int {
+	// Labels defined later aren't known yet, so they have to be absolute.
+	if val, err := a.eval(expr, stmt.line, true); nil == err && val < 0x100 {
+		return []int{zp, abs}
+	}
+	return []int{abs, zp}
+}
+
+// Generate the bytes for 'stmt'.
+func (a *assembler) generate(stmt *statement) (out []byte, err error) {
+	switch stmt.directive {
+	case ".org":
+		return nil, nil
+	case ".byte":
+		for _, arg := range stmt.args {
+			val, err := a.eval(arg, stmt.line, false)
+			if nil != err {
+				return nil, err
+			}
+			if val < -0x80 || val > 0xff {
+				return nil, &Error{stmt.line, fmt.Sprintf("%s doesn't fit in a byte", arg)}
+			}
+			out = append(out, uint8(val))
+		}
+		return out, nil
+	case ".word":
+		for _, arg := range stmt.args {
+			val, err := a.eval(arg, stmt.line, false)
+			if nil != err {
+				return nil, err
+			}
+			out = append(out, uint8(val), uint8(val >> 8))
+		}
+		return out, nil
+	}
+
+	out = []byte{opcodes[stmt.name][stmt.addressing]}
+
+	// Strip the syntax around the operand's value.
+	expr := stmt.operand
+	switch stmt.addressing {
+	case cpu.IMP, cpu.ACC:
+		return out, nil
+	case cpu.IMM:
+		expr = expr[1:]
+	case cpu.INDX, cpu.INDY:
+		expr = expr[1:len(expr) - 3]
+	case cpu.IND:
+		expr = expr[1:len(expr) - 1]
+	case cpu.ZPX, cpu.ZPY, cpu.ABSX, cpu.ABSY:
+		expr = expr[:len(expr) - 2]
+	}
+
+	val, err := a.eval(expr, stmt.line, false)
+	if nil != err {
+		return nil, err
+	}
+
+	switch stmt.addressing {
+	case cpu.REL:
+		offset := val - (int(stmt.addr) + 2)
+		if offset < -0x80 || offset > 0x7f {
+			return nil, &Error{stmt.line, fmt.Sprintf("branch to %s is out of range", expr)}
+		}
+		out = append(out, uint8(offset))
+	case cpu.ABS, cpu.ABSX, cpu.ABSY, cpu.IND:
+		out = append(out, uint8(val), uint8(val >> 8))
+	default:
+		if val < -0x80 || val > 0xff {
+			return nil, &Error{stmt.line, fmt.Sprintf("%s doesn't fit in a byte", expr)}
+		}
+		out = append(out, uint8(val))
+	}
+	return out, nil
+}
+
+// Evaluate 'expr': terms added and subtracted.  Each term is a number or a label, optionally
+// preceded by '<' or '>' for the low or high byte.  'known' is set during the first pass, when
+// labels that haven't been defined yet can't be used.
+func (a *assembler) eval(expr string, line int, known bool) (total int, err error) {
+	expr = strings.Join(strings.Fields(expr), "")
+	if "" == expr {
+		return 0, &Error{line, "missing value"}
+	}
+
+	sign := 1
+	for "" != expr {
+		// Find the end of this term.
+		end := strings.IndexAny(expr[1:], "+-") + 1
+		if 0 == end {
+			end = len(expr)
+		}
+
+		val, err := a.evalTerm(expr[:end], line, known)
+		if nil != err {
+			return 0, err
+		}
+		total += sign * val
+
+		expr = expr[end:]
+		if "" != expr {
+			if '-' == expr[0] {
+				sign = -1
+			} else {
+				sign = 1
+			}
+			expr = expr[1:]
+			if "" == expr {
+				return 0, &Error{line, "missing value"}
+			}
+		}
+	}
+	return total, nil
+}
+
+func (a *assembler) evalTerm(term string, line int, known bool) (int, error) {
+	switch {
+	case strings.HasPrefix(term, "<"):
+		val, err := a.evalTerm(term[1:], line, known)
+		return val & 0xff, err
+	case strings.HasPrefix(term, ">"):
+		val, err := a.evalTerm(term[1:], line, known)
+		return (val >> 8) & 0xff, err
+	case strings.HasPrefix(term, "$"):
+		return parseNumber(term[1:], 16, line)
+	case strings.HasPrefix(term, "%"):
+		return parseNumber(term[1:], 2, line)
+	case "" != term && '0' <= term[0] && term[0] <= '9':
+		return parseNumber(term, 10, line)
+	}
+
+	if !isIdentifier(term) {
+		return 0, &Error{line, fmt.Sprintf("bad value %q", term)}
+	}
+	val, ok := a.labels[term]
+	if !ok {
+		if known {
+			return 0, &Error{line, fmt.Sprintf("%s isn't defined yet", term)}
+		}
+		return 0, &Error{line, fmt.Sprintf("%s isn't defined%s", term, a.suggest(term))}
+	}
+	return int(val), nil
+}
+
+func parseNumber(s string, base int, line int) (int, error) {
+	val, err := strconv.ParseUint(s, base, 16)
+	if nil != err {
+		return 0, &Error{line, fmt.Sprintf("bad number %q", s)}
+	}
+	return int(val), nil
+}
+
+// Suggest a label that differs from 'name' only by case, for the error message.
+func (a *assembler) suggest(name string) string {
+	var names []string
+	for label := range a.labels {
+		if strings.EqualFold(label, name) {
+			names = append(names, label)
+		}
+	}
+	if 0 == len(names) {
+		return ""
+	}
+	sort.Strings(names)
+	return fmt.Sprintf(" (did you mean %s?)", names[0])
+}
@@ -0,0 +1,74 @@
+package asm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	source := `
+		.org $C000
+	start:	LDA #$10        ; immediate
+		STA $20         ; zero page
+		STA $0200,X
+		LDA ($20),Y
+		ASL A
+		LSR
+		LAX $10         ; undocumented
+		DCP forward     ; a forward reference is absolute
+		BNE start
+		JMP (vector)
+	forward: JSR start
+	vector:	.word start, forward + 1
+		.byte 1, %11, <vector, >vector
+	`
+	prog, err := Assemble(source)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		0xa9, 0x10,
+		0x85, 0x20,
+		0x9d, 0x00, 0x02,
+		0xb1, 0x20,
+		0x0a,
+		0x4a,
+		0xa7, 0x10,
+		0xcf, 0x15, 0xc0,
+		0xd0, 0xee,
+		0x6c, 0x18, 0xc0,
+		0x20, 0x00, 0xc0,
+		0x00, 0xc0, 0x16, 0xc0,
+		0x01, 0x03, 0x18, 0xc0,
+	}
+	if 1 != len(prog.Segments) || 0xc000 != prog.Segments[0].Org {
+		t.Fatal("Expected one segment at $C000, got", prog.Segments)
+	}
+	if got := prog.Segments[0].Bytes; !bytes.Equal(expected, got) {
+		t.Errorf("Expected\n% X\ngot\n% X", expected, got)
+	}
+	if 0xc015 != prog.Labels["forward"] {
+		t.Errorf("forward is at %04X", prog.Labels["forward"])
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	sources := map[string]int{
+		"NOP\nFOO #1": 2,           // Unknown instruction.
+		"LDA ($20)": 1,             // Bad addressing mode.
+		"x: NOP\nx: NOP": 2,        // Duplicate label.
+		"BNE far\n.org $1000\nfar: NOP": 1,  // Branch out of range.
+		"LDA missing": 1,           // Undefined label.
+	}
+
+	for source, line := range sources {
+		_, err := Assemble(source)
+		asmErr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%q: expected an *Error, got %v", source, err)
+		} else if line != asmErr.Line {
+			t.Errorf("%q: expected an error on line %d, got %v", source, line, err)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package console
+
+// This package ties the CPU, PPU, APU and cart together into a NES.  It doesn't depend on SDL or
+// any other way of showing the picture, playing the sound or reading the controllers, so it can
+// be run by tests and tools as well as the emulator frontend.
+
+import (
+	"image"
+	"image/color"
+	"io"
+
+	"apu"
+	"cpu"
+	"mapper"
+	"nesfile"
+	"ppu"
+)
+
+const (
+	// There is a master clock that is divided differently for the PPU and CPU.
+	// The PPU is clocked 3 times for every CPU cycle.
+	PPUCyclesPerCPUCycle = 3
+
+	// The CPU takes this long to reset, before running the first instruction.
+	ResetCycles = 7
+)
+
+// The buttons on a NES controller, as bits in the value passed to SetButtons.  The order is the
+// order the NES reads them in.
+const (
+	ButtonA = 1 << iota
+	ButtonB
+	ButtonSelect
+	ButtonStart
+	ButtonUp
+	ButtonDown
+	ButtonLeft
+	ButtonRight
+)
+
+// Provides the state of the controllers.  It's asked whenever the game reads a controller.
+type Input interface {
+	// Which buttons are pressed on controller 'player' (0 or 1), as Button bits.
+	Buttons(player int) uint8
+}
+
+// Plays the audio the APU generates.
+type Audio interface {
+	// Play 'samples', signed 16-bit mono.
+	Queue(samples []int16)
+
+	// The rate to generate samples at, in Hz.  This may drift a little from the nominal rate so
+	// the audio device doesn't run dry or back up.
+	OutputRate() float64
+}
+
+// Told about memory accesses.  Used by the debugger for watchpoints.
+type Watcher interface {
+	// The CPU read or wrote 'val' at 'addr'.
+	CPUAccess(addr uint16, val uint8, write bool)
+
+	// The PPU read or wrote 'val' at 'addr' in its own address space.
+	PPUAccess(addr uint16, val uint8, write bool)
+}
+
+// The picture is drawn into one of these.  It implements ppu.Video.
+type Framebuffer struct {
+	*image.RGBA
+}
+
+// Implements ppu.Video.
+func (fb *Framebuffer) SetPixel(x, y int, r, g, b byte) {
+	fb.SetRGBA(x, y, color.RGBA{r, g, b, 0xff})
+}
+
+// The controller state set with SetButtons.  The Input used unless SetInput is called.
+type buttonState [2]uint8
+
+func (bs *buttonState) Buttons(player int) uint8 {
+	return bs[player]
+}
+
+// A NES with a cart plugged in.
+type Console struct {
+	nesFile *nesfile.NesFile
+
+	cpu *cpu.CPU
+	ppu *ppu.PPU
+	apu *apu.APU
+	mem *NESMemory
+	mapper mapper.Mapper
+
+	// The mapper, if it wants to know about every CPU cycle.
+	cycleObserver mapper.CPUCycleObserver
+
+	// The PPU draws here.
+	frame *Framebuffer
+
+	// Used as the Input unless SetInput is called.
+	buttons buttonState
+
+	// Where the audio goes, or nil if it's not wanted.
+	audio Audio
+	audioSamples []int16
+
+	// CPU cycles since power on.
+	cycles uint64
+}
+
+// Build a NES around the cart in 'nesFile' and power it on.  Fails if the cart's mapper isn't
+// supported.
+func NewConsole(nesFile *nesfile.NesFile) (*Console, error) {
+	c := new(Console)
+	c.nesFile = nesFile
+
+	// The mapper is the on-cart address mapping logic.
+	var err error
+	c.mapper, err = mapper.GetMapper(nesFile)
+	if nil != err {
+		return nil, err
+	}
+
+	// Processes graphical data and renders it into the framebuffer.
+	c.frame = &Framebuffer{image.NewRGBA(image.Rect(0, 0, ppu.DisplayWidth, ppu.DisplayHeight))}
+	c.ppu = ppu.NewPPU(c.mapper, c.frame)
+
+	// Generates audio.  Clocked by the CPU.
+	c.apu = apu.NewAPU()
+
+	// Implements the bus on the CPU.
+	c.mem = NewNESMemory(c.ppu, c.apu, c.mapper, &c.buttons)
+
+	// The DMC channel reads its samples over the CPU bus.
+	c.apu.ConnectMemory(c.mem)
+
+	// Interprets and executes the opcodes.  The rest of the machine is run a cycle at a time as
+	// the CPU goes, so it sees memory-mapped registers change when they would on a real NES.
+	c.cpu = cpu.NewCPU(c.mem)
+	c.cpu.SetCycleHook(c.cpuCycle)
+	if observer, ok := c.mapper.(mapper.CPUCycleObserver); ok {
+		c.cycleObserver = observer
+	}
+
+	// The APU raises interrupts from the frame counter and DMC, and some mappers raise them too.
+	c.apu.ConnectIRQ(c.cpu)
+	c.mapper.ConnectIRQ(c.cpu)
+
+	// The rest of the machine runs while the CPU resets.
+	c.runFor(ResetCycles)
+	return c, nil
+}
+
+// Read the iNES file at 'romPath' and build a NES around it.
+func LoadConsole(romPath string) (*Console, error) {
+	nesFile, err := nesfile.ReadNesFile(romPath)
+	if nil != err {
+		return nil, err
+	}
+	return NewConsole(nesFile)
+}
+
+// The cart plugged into the console.
+func (c *Console) NesFile() *nesfile.NesFile {
+	return c.nesFile
+}
+
+// The picture.  It's complete after StepFrame returns.
+func (c *Console) Frame() *Framebuffer {
+	return c.frame
+}
+
+// Set the buttons pressed on controller 'player' (0 or 1).  'buttons' is made of Button bits.
+func (c *Console) SetButtons(player int, buttons uint8) {
+	c.buttons[player] = buttons
+}
+
+// Read the controllers from 'input' instead of what's set with SetButtons.
+func (c *Console) SetInput(input Input) {
+	c.mem.input = input
+}
+
+// Send the audio to 'audio'.  If it's nil (the default) no audio is generated.
+func (c *Console) SetAudio(audio Audio) {
+	c.audio = audio
+	if nil == audio {
+		c.apu.SetSampleRate(0)
+		return
+	}
+
+	if nil == c.audioSamples {
+		c.audioSamples = make([]int16, 4096)
+	}
+	c.apu.SetSampleRate(audio.OutputRate())
+}
+
+// Press the reset button.
+func (c *Console) Reset() {
+	c.cpu.Reset()
+	c.runFor(ResetCycles)
+}
+
+// Turn debugging output on or off.
+func (c *Console) SetDebug(on bool) {
+	c.cpu.Debug = on
+	c.ppu.Debug = on
+	c.mapper.Debug(on)
+}
+
+// The NES can only draw 8 sprites on each line.  Turning the limit off draws all of them, which
+// stops the flickering some games use to work around it.
+func (c *Console) SetSpriteLimit(on bool) {
+	c.ppu.NoSpriteLimit = !on
+}
+
+func (c *Console) SpriteLimit() bool {
+	return !c.ppu.NoSpriteLimit
+}
+
+// Write a line to 'w' for every instruction executed, in the format of nestest.log.  A nil 'w' turns
+// tracing off.
+func (c *Console) SetTrace(w io.Writer) {
+	if nil == w {
+		c.cpu.SetTrace(nil, nil)
+		return
+	}
+
+	c.cpu.SetTrace(w, func() (scanline, dot int, cycles uint64) {
+		scanline, dot = c.ppu.Position()
+		return scanline, dot, c.cycles
+	})
+}
+
+// How many CPU cycles have passed since power on.
+func (c *Console) Cycles() uint64 {
+	return c.cycles
+}
+
+// Tell 'watcher' about every memory access by the CPU and PPU.  nil turns watching off.
+func (c *Console) SetWatcher(watcher Watcher) {
+	c.mem.watcher = watcher
+	if nil == watcher {
+		c.ppu.SetWatcher(nil)
+	} else {
+		c.ppu.SetWatcher(watcher.PPUAccess)
+	}
+}
+
+// Has a KIL opcode jammed the CPU?  Nothing more happens until Reset, though the PPU keeps
+// drawing.
+func (c *Console) Jammed() bool {
+	return c.cpu.Jammed()
+}
+
+// The CPU's registers.
+func (c *Console) Registers() cpu.Registers {
+	return c.cpu.Registers()
+}
+
+// The scanline and dot the PPU is about to draw.
+func (c *Console) PPUPosition() (scanline, dot int) {
+	return c.ppu.Position()
+}
+
+// The instruction about to execute and the registers, as a line of a trace.  See SetTrace.
+func (c *Console) TraceLine() string {
+	scanline, dot := c.ppu.Position()
+	return c.cpu.TraceLine(scanline, dot, c.cycles)
+}
+
+// Read 'addr' as the CPU sees it, without side effects.  The PPU, APU and controller registers
+// read as 0xFF.
+func (c *Console) PeekCPU(addr uint16) uint8 {
+	return c.mem.Peek(addr)
+}
+
+// Read 'addr' as the PPU sees it, without side effects.
+func (c *Console) PeekPPU(addr uint16) uint8 {
+	return c.ppu.Peek(addr)
+}
+
+// The cart's RAM at [0x6000 -> 0x7FFF].  If the cart has a battery, this holds saved games.
+func (c *Console) SRAM() []byte {
+	return c.mapper.SRAM()
+}
+
+// Let the PPU catch up with 'cycles' CPU cycles.
+func (c *Console) tickPPU(cycles uint64) {
+	for i := uint64(0); i < PPUCyclesPerCPUCycle * cycles; i++ {
+		c.ppu.Tick()
+	}
+}
+
+// Clock the APU for 'cycles' CPU cycles.  The DMC may stall the CPU while it fetches samples, and
+// time passes for everything but the CPU during the stall.  Returns 'cycles' plus however many
+// cycles were stolen from the CPU.
+func (c *Console) clockAPU(cycles uint64) uint64 {
+	// Stolen cycles can themselves lead to more sample fetches.
+	for stall := c.apu.Step(cycles); stall > 0; stall = c.apu.Step(stall) {
+		cycles += stall
+	}
+	return cycles
+}
+
+// Run everything but the CPU for 'cycles' CPU cycles, as the CPU just did.  Returns 'cycles' plus
+// however many cycles the DMC stole.
+func (c *Console) runFor(cycles uint64) uint64 {
+	cycles = c.clockAPU(cycles)
+	c.tickPPU(cycles)
+	c.cycles += cycles
+	return cycles
+}
+
+// Called by the CPU at the start of each of its cycles.  Runs the rest of the machine for the
+// cycle, so the cycle's memory access happens at the right time.
+func (c *Console) cpuCycle() {
+	if nil != c.cycleObserver {
+		c.cycleObserver.CPUCycle()
+	}
+	c.runFor(1)
+}
+
+// Execute one instruction, with the rest of the machine running alongside.  Returns how many CPU
+// cycles were used, and whether a frame was completed.
+func (c *Console) Step() (cycles uint64, frameComplete bool) {
+	start := c.cycles
+	c.cpu.Interpret()
+
+	// The PPU may have asked for a NMI during the instruction.  It's handled after the
+	// instruction finishes.
+	if c.ppu.PollNMI() {
+		c.cpu.NMI()
+	}
+
+	return c.cycles - start, c.ppu.FrameComplete()
+}
+
+// Run until the PPU has drawn a whole frame, which happens as VBlank starts.  The audio for the
+// frame is sent to the Audio, if there is one.
+func (c *Console) StepFrame() {
+	c.StepFrameUntil(nil)
+}
+
+// Like StepFrame, but 'stop' is called after each instruction, and the frame is left unfinished if
+// it returns true.  Returns whether 'stop' returned true.  A later call carries on with the frame.
+func (c *Console) StepFrameUntil(stop func() bool) (stopped bool) {
+	for {
+		_, frameComplete := c.Step()
+		stopped = nil != stop && stop()
+		if frameComplete {
+			break
+		}
+		if stopped {
+			return
+		}
+	}
+
+	if nil == c.audio {
+		return
+	}
+
+	// Hand this frame's worth of audio over and nudge the sample rate to keep the audio
+	// device's queue from running dry or backing up.
+	count := c.apu.ReadSamples(c.audioSamples)
+	c.audio.Queue(c.audioSamples[:count])
+	c.apu.SetSampleRate(c.audio.OutputRate())
+	return
+}
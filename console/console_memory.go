@@ -1,9 +1,9 @@
-package main
+package console
 
 import (
+	"apu"
 	"mapper"
 	"ppu"
-	"wrapper"
 )
 
 // The NES-specific implementation of the CPU memory interface.  Some addresses are handled by the
@@ -20,30 +20,33 @@ type NESMemory struct {
 
 	// [0x4000 -> 0x4017] are audio or controller mmio registers.
 
+	// [0x4000 -> 0x4013] and 0x4015 are handled by the APU.
+	apu *apu.APU
+
 	// For 0x4016:
-	// Which of keyReadOrder are we currently returning?
+	// Which of the buttons are we currently returning?  See the Button constants.
 	currentKeyRead int
-	// Provides "is this key pressed" functionality.
-	input *wrapper.InputProvider
+	// Provides the controller state.
+	input Input
 
 	// [0x4018 -> 0xFFFF] is mapped by the cart.
 	cartMapper mapper.Mapper
-}
 
-// Key presses are returned via the controller mmio regs in the following order.
-var keyReadOrder = [8]int {
-	wrapper.KEY_A_1,
-	wrapper.KEY_B_1,
-	wrapper.KEY_SELECT_1,
-	wrapper.KEY_START_1,
-	wrapper.KEY_UP_1,
-	wrapper.KEY_DOWN_1,
-	wrapper.KEY_LEFT_1,
-	wrapper.KEY_RIGHT_1,
+	// Told about every access, if set.
+	watcher Watcher
 }
 
-// The CPU is reading from 'addr'.  Dispatch to the correct handler.
+// The CPU is reading from 'addr'.
 func (mem *NESMemory) Read(addr uint16) uint8 {
+	val := mem.read(addr)
+	if nil != mem.watcher {
+		mem.watcher.CPUAccess(addr, val, false)
+	}
+	return val
+}
+
+// Dispatch a read from 'addr' to the correct handler.
+func (mem *NESMemory) read(addr uint16) uint8 {
 	if addr < 0x2000 {
 		// [0x0000 -> 0x1FFF], but mirrored.
 		addr &= 0x7ff
@@ -55,18 +58,24 @@ func (mem *NESMemory) Read(addr uint16) uint8 {
 		// [0x4000 -> 0x4017] is audio/input device registers.  And sprite DMA but that is
 		// write-only.
 
+		// 0x4015 is the only readable APU register.
+		if apu.APUSTATUS == addr {
+			return mem.apu.ReadStatus()
+		}
+
 		// 0x4016 is the 1st controller mmio register and the only one currently
 		// implemented.
 		if 0x4016 != addr {
 			return 0
 		}
 
-		if mem.currentKeyRead >= len(keyReadOrder) {
+		if mem.currentKeyRead >= 8 {
 			return 1
 		}
 		// Keys are returned in the 1st bit of the read.
 		// The order is: A B Select Start Up Down Left Right
-		pressed := mem.input.IsKeyPressed(keyReadOrder[mem.currentKeyRead])
+		buttons := mem.input.Buttons(0)
+		pressed := 0 != (buttons & (1 << uint(mem.currentKeyRead)))
 		mem.currentKeyRead = mem.currentKeyRead + 1
 		if pressed {
 			return 1
@@ -81,9 +90,24 @@ func (mem *NESMemory) Read(addr uint16) uint8 {
 	return 0
 }
 
+// Implements cpu.Peeker.  Reading the PPU, APU and controller registers has side effects, so they
+// read as 0xFF.
+func (mem *NESMemory) Peek(addr uint16) uint8 {
+	if addr < 0x2000 {
+		return mem.ram[addr & 0x7ff]
+	} else if addr < 0x4018 {
+		return 0xff
+	}
+	return mem.cartMapper.ReadCPU(addr)
+}
+
 // The CPU is writing 'val' to 'addr'.  Dispatch to the correct handler.  Returns how many extra
 // cycles the write takes.
 func (mem *NESMemory) Write(addr uint16, val uint8) (cycles uint64) {
+	if nil != mem.watcher {
+		mem.watcher.CPUAccess(addr, val, true)
+	}
+
 	if addr < 0x2000 {
 		// [0x0000 -> 0x1FFF]
 		addr &= 0x7ff
@@ -93,7 +117,8 @@ func (mem *NESMemory) Write(addr uint16, val uint8) (cycles uint64) {
 		mem.ppu.WriteRegister(addr, val)
 	} else if addr < 0x4018 {
 		if addr >= 0x4000 && addr <= 0x4013 {
-			// These are audio registers.  Ignored until we have sound.
+			// These are audio registers.
+			mem.apu.WriteRegister(addr, val)
 		} else if addr == 0x4014 {
 			// Sprite DMA.  Transfer 256 bytes of memory to SPR-RAM from
 			// 0x100 * val.
@@ -101,8 +126,9 @@ func (mem *NESMemory) Write(addr uint16, val uint8) (cycles uint64) {
 			end := start + 256
 			mem.ppu.SpriteDMA(mem.ram[start:end])
 			return 513
-		} else if addr == 0x4015 {
-			// This is also an ignored audio register.
+		} else if addr == apu.APUSTATUS {
+			// Channel enables.
+			mem.apu.WriteRegister(addr, val)
 		} else if addr == 0x4016 {
 			// 0x4016 is a write register that is strobed to reset the game pad(s).
 			// When 1 is written it continually reads the state of the game pad(s).
@@ -110,8 +136,11 @@ func (mem *NESMemory) Write(addr uint16, val uint8) (cycles uint64) {
 			if 0 == (val & 1) {
 				mem.currentKeyRead = 0
 			}
+		} else if addr == apu.APUFRAME {
+			// Reads of 0x4017 go to the 2nd controller, but writes configure the APU
+			// frame counter.
+			mem.apu.WriteRegister(addr, val)
 		}
-		// 0x4017 is a read-only register.
 	} else {
 		// [0x4018 -> 0xFFFF]
 		return mem.cartMapper.WriteCPU(addr, val)
@@ -121,9 +150,10 @@ func (mem *NESMemory) Write(addr uint16, val uint8) (cycles uint64) {
 	return 0
 }
 
-func NewNESMemory(ppu *ppu.PPU, cartMapper mapper.Mapper, input *wrapper.InputProvider) (nesMem *NESMemory) {
+func NewNESMemory(ppu *ppu.PPU, apu *apu.APU, cartMapper mapper.Mapper, input Input) (nesMem *NESMemory) {
 	nesMem = new(NESMemory)
 	nesMem.ppu = ppu
+	nesMem.apu = apu
 	nesMem.cartMapper = cartMapper
 	nesMem.currentKeyRead = 0
 	nesMem.input = input
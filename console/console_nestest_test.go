@@ -0,0 +1,105 @@
+package console
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// nestest tests every instruction, including the undocumented ones, without needing the PPU when
+// it's started at 0xC000.  nestest.log is a trace of it from a known good emulator.  Neither is
+// checked in; put them in testdata, or in the NES_TEST_ROMS directory.
+const (
+	nestestROM = "nestest.nes"
+	nestestLog = "nestest.log"
+	nestestStart = 0xc000
+)
+
+// Find the directory with nestest in it.  Skips the test if there isn't one.
+func findNestest(t *testing.T) string {
+	for _, dir := range []string{os.Getenv("NES_TEST_ROMS"), "testdata"} {
+		if "" == dir {
+			continue
+		}
+		_, romErr := os.Stat(filepath.Join(dir, nestestROM))
+		_, logErr := os.Stat(filepath.Join(dir, nestestLog))
+		if nil == romErr && nil == logErr {
+			return dir
+		}
+	}
+
+	t.Skip("No ", nestestROM, " and ", nestestLog, " found in testdata or NES_TEST_ROMS")
+	return ""
+}
+
+// Read the lines of the log at 'path'.
+func readGoldenLog(t *testing.T, path string) (lines []string) {
+	file, err := os.Open(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); nil != err {
+		t.Fatal(err)
+	}
+	return
+}
+
+// Trace nestest and compare it line by line with the golden log, stopping at the first
+// difference.
+func TestNestest(t *testing.T) {
+	dir := findNestest(t)
+	golden := readGoldenLog(t, filepath.Join(dir, nestestLog))
+
+	nes, err := LoadConsole(filepath.Join(dir, nestestROM))
+	if nil != err {
+		t.Fatal(err)
+	}
+	nes.cpu.SetPC(nestestStart)
+
+	var trace bytes.Buffer
+	nes.SetTrace(&trace)
+
+	for line := 0; line < len(golden); {
+		nes.Step()
+
+		for {
+			text, err := trace.ReadString('\n')
+			if nil != err {
+				// Not a whole line yet.  Put it back.
+				trace.Reset()
+				trace.WriteString(text)
+				break
+			}
+
+			text = strings.TrimSuffix(text, "\n")
+			if text != golden[line] {
+				context := ""
+				if line > 0 {
+					context = golden[line - 1]
+				}
+				t.Fatalf("Trace differs at line %d, after\n  %s\nexpected\n  %s\ngot\n  %s",
+					 line + 1, context, golden[line], text)
+			}
+
+			line++
+			if line == len(golden) {
+				break
+			}
+		}
+	}
+
+	// nestest leaves its results at 0x02 and 0x03.  Both are 0 if everything passed.
+	if result := nes.mem.Peek(0x02) | nes.mem.Peek(0x03); 0 != result {
+		t.Errorf("nestest reported errors: 0x02=%02X 0x03=%02X", nes.mem.Peek(0x02),
+			 nes.mem.Peek(0x03))
+	}
+}
@@ -0,0 +1,105 @@
+package console
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"nesfile"
+	"savestate"
+)
+
+var ErrWrongGame = errors.New("save state is for a different game")
+
+// Identifies the game, so a state isn't loaded into a different one.
+func romID(nesFile *nesfile.NesFile) uint64 {
+	hash := crc32.NewIEEE()
+	for _, bank := range nesFile.PrgRom {
+		hash.Write(bank)
+	}
+	for _, bank := range nesFile.ChrRom {
+		hash.Write(bank)
+	}
+	return uint64(hash.Sum32())
+}
+
+// Save the RAM and controller state.
+func (mem *NESMemory) Save(w *savestate.Writer) {
+	mem.state(w)
+}
+
+// Load what Save saved.
+func (mem *NESMemory) Load(r *savestate.Reader) {
+	mem.state(r)
+}
+
+func (mem *NESMemory) state(s savestate.Stream) {
+	s.Bytes(mem.ram[:])
+	s.Int(&mem.currentKeyRead)
+	s.Check(mem.currentKeyRead >= 0 && mem.currentKeyRead <= 8, "controller read")
+}
+
+// Save the whole machine to 'w'.
+func (c *Console) SaveState(w io.Writer) error {
+	sw := savestate.NewWriter(w)
+
+	id := romID(c.nesFile)
+	sw.Uint64(&id)
+	sw.Uint64(&c.cycles)
+	c.cpu.Save(sw)
+	c.ppu.Save(sw)
+	c.apu.Save(sw)
+	c.mem.Save(sw)
+	c.mapper.Save(sw)
+
+	return sw.Err()
+}
+
+// Load the whole machine from 'r'.  If it can't be loaded, the machine is left as it was.  Besides
+// I/O errors and the errors from savestate.NewReader, returns ErrWrongGame, or a
+// *savestate.CorruptError if a value in the state is out of range.
+func (c *Console) LoadState(r io.Reader) error {
+	// A truncated or corrupt state would only be noticed partway through loading, so keep a copy
+	// of the current state to go back to.
+	backup := new(bytes.Buffer)
+	if err := c.SaveState(backup); nil != err {
+		return err
+	}
+
+	if err := c.loadState(r); nil != err {
+		c.loadState(backup)
+		return err
+	}
+	return nil
+}
+
+func (c *Console) loadState(r io.Reader) error {
+	// Read it all up front so a read error can't happen halfway through.
+	data, err := ioutil.ReadAll(r)
+	if nil != err {
+		return err
+	}
+
+	sr, err := savestate.NewReader(bytes.NewReader(data))
+	if nil != err {
+		return err
+	}
+
+	var id uint64
+	sr.Uint64(&id)
+	if nil != sr.Err() {
+		return sr.Err()
+	} else if romID(c.nesFile) != id {
+		return ErrWrongGame
+	}
+
+	sr.Uint64(&c.cycles)
+	c.cpu.Load(sr)
+	c.ppu.Load(sr)
+	c.apu.Load(sr)
+	c.mem.Load(sr)
+	c.mapper.Load(sr)
+	return sr.Err()
+}
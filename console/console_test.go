@@ -0,0 +1,213 @@
+package console
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"mapper"
+	"nesfile"
+	"savestate"
+)
+
+// Build a NROM cart whose program enables NMIs and then loops forever.
+func makeTestCart() *nesfile.NesFile {
+	prg := make([]byte, 0x4000)
+	program := []byte{
+		0xa9, 0x80,       // LDA #$80
+		0x8d, 0x00, 0x20, // STA $2000
+		0x4c, 0x05, 0xc0, // JMP $C005
+	}
+	copy(prg, program)
+
+	// The NMI handler just returns.
+	prg[0x100] = 0x40 // RTI
+
+	// NMI, reset and IRQ vectors.  The 16K bank is mirrored at 0x8000 and 0xc000.
+	prg[0x3ffa], prg[0x3ffb] = 0x00, 0xc1
+	prg[0x3ffc], prg[0x3ffd] = 0x00, 0xc0
+	prg[0x3ffe], prg[0x3fff] = 0x00, 0xc1
+
+	nesFile := new(nesfile.NesFile)
+	nesFile.Mirroring = nesfile.Horizontal
+	nesFile.PrgRom = [][]byte{prg}
+	nesFile.ChrRom = [][]byte{make([]byte, 0x2000)}
+	return nesFile
+}
+
+// Power on a NES with the test cart in it.
+func newTestConsole(t *testing.T) *Console {
+	nes, err := NewConsole(makeTestCart())
+	if nil != err {
+		t.Fatal(err)
+	}
+	return nes
+}
+
+func TestStepFrame(t *testing.T) {
+	nes := newTestConsole(t)
+	for i := 0; i < 3; i++ {
+		nes.StepFrame()
+	}
+
+	// Rendering is off, so the picture is the backdrop color.
+	frame := nes.Frame()
+	r, g, b, _ := frame.At(100, 100).RGBA()
+	if 0x80 != r >> 8 || 0x80 != g >> 8 || 0x80 != b >> 8 {
+		t.Errorf("Expected the gray backdrop, got (%x, %x, %x)", r >> 8, g >> 8, b >> 8)
+	}
+}
+
+func TestButtons(t *testing.T) {
+	nes := newTestConsole(t)
+	nes.SetButtons(0, ButtonA | ButtonStart)
+
+	// Strobe the controller, then read the 8 buttons.
+	nes.mem.Write(0x4016, 1)
+	nes.mem.Write(0x4016, 0)
+	expected := []uint8{1, 0, 0, 1, 0, 0, 0, 0}
+	for i, val := range expected {
+		if got := nes.mem.Read(0x4016); val != got {
+			t.Errorf("Button %v: expected %v, got %v", i, val, got)
+		}
+	}
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	nes := newTestConsole(t)
+	nes.StepFrame()
+
+	saved := new(bytes.Buffer)
+	if err := nes.SaveState(saved); nil != err {
+		t.Fatal(err)
+	}
+
+	nes.StepFrame()
+	if err := nes.LoadState(bytes.NewReader(saved.Bytes())); nil != err {
+		t.Fatal(err)
+	}
+
+	loaded := new(bytes.Buffer)
+	if err := nes.SaveState(loaded); nil != err {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved.Bytes(), loaded.Bytes()) {
+		t.Error("Loading a state and saving it again produced a different state")
+	}
+
+	// A truncated state is refused.
+	if err := nes.LoadState(bytes.NewReader(saved.Bytes()[:100])); nil == err {
+		t.Error("Expected an error loading a truncated state")
+	}
+}
+
+// The cycle count is part of the state, so it doesn't jump when one is loaded.
+func TestSaveStateCycles(t *testing.T) {
+	nes := newTestConsole(t)
+	nes.StepFrame()
+	cycles := nes.Cycles()
+
+	saved := new(bytes.Buffer)
+	if err := nes.SaveState(saved); nil != err {
+		t.Fatal(err)
+	}
+	nes.StepFrame()
+	if err := nes.LoadState(saved); nil != err {
+		t.Fatal(err)
+	}
+	if cycles != nes.Cycles() {
+		t.Errorf("Expected %d cycles after loading, got %d", cycles, nes.Cycles())
+	}
+}
+
+// A state with an out of range bank number is refused, and the machine is left as it was.
+func TestCorruptSaveState(t *testing.T) {
+	nesFile := makeTestCart()
+	nesFile.Mapper = 2
+	nesFile.ChrRom = nil
+	nes, err := NewConsole(nesFile)
+	if nil != err {
+		t.Fatal(err)
+	}
+	nes.StepFrame()
+
+	saved := new(bytes.Buffer)
+	if err := nes.SaveState(saved); nil != err {
+		t.Fatal(err)
+	}
+
+	// The UxROM bank number is the last thing saved.
+	corrupt := append([]byte{}, saved.Bytes()...)
+	corrupt[len(corrupt) - 8] = 100
+	var corruptErr *savestate.CorruptError
+	if err := nes.LoadState(bytes.NewReader(corrupt)); !errors.As(err, &corruptErr) {
+		t.Fatal("Expected a CorruptError, got", err)
+	}
+
+	after := new(bytes.Buffer)
+	if err := nes.SaveState(after); nil != err {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved.Bytes(), after.Bytes()) {
+		t.Error("Loading a corrupt state changed the machine")
+	}
+}
+
+// Four-screen carts have a separate nametable at each of 0x2000, 0x2400, 0x2800 and 0x2C00.
+func TestFourScreen(t *testing.T) {
+	nesFile := makeTestCart()
+	nesFile.Mirroring = nesfile.FourScreen
+	nes, err := NewConsole(nesFile)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	for i := uint16(0); i < 4; i++ {
+		nes.mapper.WritePPU(0x2000 + i * 0x400, uint8(i + 1))
+	}
+	for i := uint16(0); i < 4; i++ {
+		if val := nes.mapper.ReadPPU(0x2000 + i * 0x400); uint8(i + 1) != val {
+			t.Errorf("Nametable %d holds %d", i, val)
+		}
+	}
+}
+
+// NES 2.0 headers give the size of PRG-RAM.  Smaller RAM is mirrored through 0x6000-0x7FFF.
+func TestPrgRamSize(t *testing.T) {
+	nesFile := makeTestCart()
+	nesFile.Format = nesfile.NES2
+	nesFile.PrgRamSize = 0x800
+	nes, err := NewConsole(nesFile)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 0x800 != len(nes.SRAM()) {
+		t.Fatalf("Expected 2K of PRG-RAM, got %d bytes", len(nes.SRAM()))
+	}
+	nes.mapper.WriteCPU(0x6001, 0x42)
+	if 0x42 != nes.mapper.ReadCPU(0x7801) {
+		t.Error("PRG-RAM isn't mirrored")
+	}
+
+	nesFile.PrgRamSize = 0
+	if nes, err = NewConsole(nesFile); nil != err {
+		t.Fatal(err)
+	}
+	nes.mapper.WriteCPU(0x6001, 0x42)
+	if 0 != len(nes.SRAM()) || 0 != nes.mapper.ReadCPU(0x6001) {
+		t.Error("Expected no PRG-RAM")
+	}
+}
+
+func TestUnsupportedMapper(t *testing.T) {
+	nesFile := makeTestCart()
+	nesFile.Mapper = 0xfff
+	var unsupported *mapper.UnsupportedMapperError
+	err := mapper.Supported(nesFile)
+	if !errors.As(err, &unsupported) || 0xfff != unsupported.Mapper {
+		t.Error("Expected an UnsupportedMapperError, got", err)
+	}
+	if _, err := NewConsole(nesFile); !errors.As(err, &unsupported) {
+		t.Error("Expected NewConsole to fail with an UnsupportedMapperError, got", err)
+	}
+}
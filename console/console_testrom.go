@@ -0,0 +1,101 @@
+package console
+
+// Many of the community accuracy test ROMs (blargg's, and others following the same convention)
+// report their results through the cart RAM at 0x6000:
+//
+// 0x6000       Status.  0x80 while running, 0x81 if the reset button should be pressed, and the
+//              result code when done: 0 means passed, anything else failed.
+// 0x6001-6003  0xDE 0xB0 0x61, written once the status byte is valid.
+// 0x6004-      Zero-terminated text describing what happened.
+//
+// http://wiki.nesdev.com/w/index.php/Emulator_tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+const (
+	testROMRunning = 0x80
+	testROMNeedsReset = 0x81
+
+	// How long to wait before pressing reset when the ROM asks for it.  The ROMs want at least
+	// 100ms.
+	testROMResetFrames = 10
+)
+
+// Written after the status byte once it's valid.
+var testROMSignature = []byte{0xde, 0xb0, 0x61}
+
+var ErrTestROMTimeout = errors.New("test ROM didn't finish in time")
+
+// The cart doesn't have enough PRG-RAM for the status byte and signature.
+var ErrTestROMNoRAM = errors.New("test ROM has no PRG-RAM to report through")
+
+// What a test ROM reported.
+type TestROMResult struct {
+	// The result code.  0 means the test passed.
+	Status uint8
+
+	// The text the ROM wrote.
+	Message string
+}
+
+func (result TestROMResult) Passed() bool {
+	return 0 == result.Status
+}
+
+func (result TestROMResult) String() string {
+	if result.Passed() {
+		return fmt.Sprintf("passed: %s", result.Message)
+	}
+	return fmt.Sprintf("failed with status %d: %s", result.Status, result.Message)
+}
+
+// Run the test ROM in 'nes' until it reports a result, for at most 'maxFrames' frames.  Returns
+// ErrTestROMTimeout if there's no result by then, or ErrTestROMNoRAM if the cart has no PRG-RAM.
+func RunTestROM(nes *Console, maxFrames int) (result TestROMResult, err error) {
+	sram := nes.SRAM()
+	if len(sram) < 1 + len(testROMSignature) {
+		return result, ErrTestROMNoRAM
+	}
+
+	// Counts down to pressing reset, if the ROM asked for it.
+	resetIn := 0
+
+	for frame := 0; frame < maxFrames; frame++ {
+		nes.StepFrame()
+
+		// Until the signature is there, the status byte is garbage.
+		if !bytes.Equal(testROMSignature, sram[1:4]) {
+			continue
+		}
+
+		switch status := sram[0]; status {
+		case testROMRunning:
+			resetIn = 0
+		case testROMNeedsReset:
+			if 0 == resetIn {
+				resetIn = testROMResetFrames
+			} else if resetIn--; 0 == resetIn {
+				nes.Reset()
+			}
+		default:
+			result.Status = status
+			result.Message = testROMMessage(sram)
+			return
+		}
+	}
+
+	return result, ErrTestROMTimeout
+}
+
+// The text written after the signature, with trailing whitespace trimmed.
+func testROMMessage(sram []byte) string {
+	text := sram[4:]
+	if end := bytes.IndexByte(text, 0); end >= 0 {
+		text = text[:end]
+	}
+	return string(bytes.TrimSpace(text))
+}
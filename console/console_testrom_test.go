@@ -0,0 +1,120 @@
+package console
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mapper"
+	"nesfile"
+)
+
+// The test ROMs aren't checked in.  Put them (in any directory structure) under testdata/roms, or
+// point NES_TEST_ROMS at them.
+const defaultTestROMDir = "testdata/roms"
+
+// Give each ROM a minute of emulated time.  The slowest of blargg's take about 30 seconds.
+const testROMMaxFrames = 60 * 60
+
+// Find the test ROMs.  Skips the test if there aren't any.
+func findTestROMs(t *testing.T) (roms []string) {
+	dir := os.Getenv("NES_TEST_ROMS")
+	if "" == dir {
+		dir = defaultTestROMDir
+	}
+
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if nil == err && !info.IsDir() && strings.EqualFold(".nes", filepath.Ext(path)) {
+			roms = append(roms, path)
+		}
+		return nil
+	})
+
+	if 0 == len(roms) {
+		t.Skip("No test ROMs found in", dir)
+	}
+	return
+}
+
+// Boot the ROM at 'path'.  Skips the test if it uses a mapper that isn't implemented.
+func loadTestROM(t *testing.T, path string) *Console {
+	nes, err := LoadConsole(path)
+	var unsupported *mapper.UnsupportedMapperError
+	if errors.As(err, &unsupported) {
+		t.Skip("Can't run ", path, ": ", err)
+	}
+	if nil != err {
+		t.Fatal(err)
+	}
+	return nes
+}
+
+func TestROMs(t *testing.T) {
+	for _, path := range findTestROMs(t) {
+		path := path
+		t.Run(filepath.ToSlash(path), func(t *testing.T) {
+			nes := loadTestROM(t, path)
+
+			result, err := RunTestROM(nes, testROMMaxFrames)
+			if nil != err {
+				t.Fatal(err)
+			}
+			if !result.Passed() {
+				t.Error(result)
+			} else {
+				t.Log(result)
+			}
+		})
+	}
+}
+
+// Check the harness itself with a program that follows the protocol.
+func TestRunTestROM(t *testing.T) {
+	nesFile := makeTestCart()
+	prg := nesFile.PrgRom[0]
+	program := []byte{
+		0xa9, 0x80,       // LDA #$80
+		0x8d, 0x00, 0x60, // STA $6000
+		0xa2, 0x00,       // LDX #0
+		0xbd, 0x00, 0xc2, // loop: LDA $C200,X
+		0x9d, 0x01, 0x60, // STA $6001,X
+		0xe8,             // INX
+		0xe0, 0x08,       // CPX #8
+		0xd0, 0xf5,       // BNE loop
+		0xa9, 0x03,       // LDA #3
+		0x8d, 0x00, 0x60, // STA $6000
+		0x4c, 0x17, 0xc0, // JMP $C017
+	}
+	copy(prg, program)
+
+	// The signature and message.
+	copy(prg[0x200:], []byte{0xde, 0xb0, 0x61, 'o', 'o', 'p', 's', 0})
+
+	nes, err := NewConsole(nesFile)
+	if nil != err {
+		t.Fatal(err)
+	}
+	result, err := RunTestROM(nes, 10)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 3 != result.Status || "oops" != result.Message {
+		t.Errorf("Expected status 3 and \"oops\", got %v and %q", result.Status, result.Message)
+	}
+}
+
+// A cart without PRG-RAM can't report anything.
+func TestRunTestROMNoRAM(t *testing.T) {
+	nesFile := makeTestCart()
+	nesFile.Format = nesfile.NES2
+	nesFile.PrgRamSize = 0
+	nes, err := NewConsole(nesFile)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err := RunTestROM(nes, 10); ErrTestROMNoRAM != err {
+		t.Error("Expected ErrTestROMNoRAM, got", err)
+	}
+}
@@ -5,6 +5,7 @@ package cpu
 
 import (
 	"fmt"
+	"io"
 )
 
 // The 6502 CPU emulation code only requires an implementation of this interface.
@@ -16,6 +17,25 @@ type MemoryInterface interface {
 	Write(addr uint16, val uint8) (cycles uint64)
 }
 
+// Devices that can request a maskable interrupt.  Each source asserts and releases the IRQ line
+// independently, and the line stays asserted as long as any source is asserting it.
+type IRQSource uint8
+
+const (
+	IRQ_APU_FRAME IRQSource = 1 << iota
+	IRQ_APU_DMC
+	IRQ_MAPPER
+)
+
+// Devices that raise interrupts are given one of these to do so.  The CPU implements it.
+type IRQLine interface {
+	// Start asserting the IRQ line on behalf of 'source'.
+	AssertIRQ(source IRQSource)
+
+	// Stop asserting the IRQ line on behalf of 'source'.
+	ReleaseIRQ(source IRQSource)
+}
+
 type CPU struct {
 	// Hardware registers.
 
@@ -52,13 +72,48 @@ type CPU struct {
 
 	// How many clock cycles were used in execution of the opcode we just interpreted?
 	//
-	// Used to track how many CPU clock cycles were used in the execution of an instruction.
-	// We can't execute part of an instruction, but we track how long each instruction takes
-	// in order to synchronize the PPU (which runs at a higher clock) with the CPU.
+	// The 6502 reads or writes memory on every cycle, even when it has nothing to read or write,
+	// so this is counted as memory is accessed.  See cpu.read and cpu.write.
 	clockCycles uint64
 
+	// If set, called at the start of every cycle, before the cycle's memory access.  This lets
+	// the rest of the machine keep in step with the CPU, so that memory-mapped registers are
+	// read and written at the right time.
+	cycleHook func()
+
+	// Which sources are currently asserting the IRQ line.  The line is level-triggered, so an
+	// interrupt keeps happening until the source releases it.
+	irqLine IRQSource
+
+	// The CPU polls for interrupts at the end of each instruction.  If an IRQ was detected, it
+	// is serviced instead of executing the next instruction.
+	irqPending bool
+
+	// CLI, SEI and PLP change the I flag after the interrupt poll happens, so the new value
+	// only takes effect after the following instruction.  Set by those opcodes.
+	delayedIFlag bool
+
+	// Set when a KIL opcode stops the CPU.  It ignores interrupts, and only a reset starts it
+	// again.
+	jammed bool
+
 	// Set to true to log every instruction to the console.
 	Debug bool
+
+	// If set, every instruction is traced here in nestest.log format.  See cpu_trace.go.
+	trace io.Writer
+	traceClock TraceClock
+}
+
+// A copy of the CPU's registers, for debugging.
+type Registers struct {
+	A, X, Y uint8
+
+	// The status register.  See the flag consts in cpu_exec.go.
+	P uint8
+
+	SP uint8
+	PC uint16
 }
 
 // Allocate a new CPU and initialize its internal state.
@@ -87,13 +142,40 @@ func (cpu *CPU) Reset() {
 	// This is the hardware state upon reset according to a test ROM from nesdev.
 	cpu.pc = uint16(cpu.mem.Read(vectorReset))
 	cpu.pc |= (uint16(cpu.mem.Read(vectorReset + 1)) << 8)
-	cpu.sp |= I
+	cpu.st |= I
 	cpu.sp -= 3
 
 	// Reset internal state.
 	cpu.opRawAddr = 0
 	cpu.opAddr = 0
 	cpu.clockCycles = 0
+	cpu.irqPending = false
+	cpu.jammed = false
+}
+
+// Has a KIL opcode stopped the CPU?  If so the PC is on the KIL.
+func (cpu *CPU) Jammed() bool {
+	return cpu.jammed
+}
+
+// Call 'hook' at the start of every CPU cycle, before the cycle's memory access.  nil stops it
+// being called.
+func (cpu *CPU) SetCycleHook(hook func()) {
+	cpu.cycleHook = hook
+}
+
+func (cpu *CPU) Registers() Registers {
+	return Registers{cpu.ac, cpu.xr, cpu.yr, cpu.st, cpu.sp, cpu.pc}
+}
+
+// Implements IRQLine.
+func (cpu *CPU) AssertIRQ(source IRQSource) {
+	cpu.irqLine |= source
+}
+
+// Implements IRQLine.
+func (cpu *CPU) ReleaseIRQ(source IRQSource) {
+	cpu.irqLine &= ^source
 }
 
 // Hardware vectors for interrupts.  The vectors below are the location of an address
@@ -110,6 +192,10 @@ const (
 )
 
 func (cpu *CPU) NMI() uint64 {
+	if cpu.jammed {
+		return 0
+	}
+
 	if cpu.Debug {
 		output := cpu.formatRegisters()
 		output += " [NMI]"
@@ -117,15 +203,71 @@ func (cpu *CPU) NMI() uint64 {
 	}
 
 	cpu.clockCycles = 0
+	cpu.interruptDummyReads()
 	cpu.pushWord(cpu.pc)
 	cpu.push(cpu.st)
 	cpu.set(I, true)
-	cpu.pc = uint16(cpu.mem.Read(vectorNMI))
-	cpu.pc |= (uint16(cpu.mem.Read(vectorNMI + 1)) << 8)
-	return 7
+	// The handler's first instruction always runs before any IRQ is serviced.
+	cpu.irqPending = false
+	cpu.pc = uint16(cpu.read(vectorNMI))
+	cpu.pc |= (uint16(cpu.read(vectorNMI + 1)) << 8)
+	return cpu.clockCycles
+}
+
+// Service a maskable interrupt request.  The caller has already checked the I flag.
+func (cpu *CPU) irq() uint64 {
+	if cpu.Debug {
+		output := cpu.formatRegisters()
+		output += " [IRQ]"
+		fmt.Println(output)
+	}
+
+	cpu.clockCycles = 0
+	cpu.interruptDummyReads()
+	cpu.pushWord(cpu.pc)
+	// The B flag is only pushed by BRK and PHP.
+	cpu.push(ALWAYS_ON | (cpu.st & ^B))
+	cpu.set(I, true)
+	cpu.irqPending = false
+	cpu.pc = uint16(cpu.read(vectorIRQBRK))
+	cpu.pc |= (uint16(cpu.read(vectorIRQBRK + 1)) << 8)
+	return cpu.clockCycles
+}
+
+// An interrupt starts like an instruction: the opcode and the byte after it are read, then thrown
+// away.  The PC isn't incremented.
+func (cpu *CPU) interruptDummyReads() {
+	cpu.read(cpu.pc)
+	cpu.read(cpu.pc)
+}
+
+// Poll the IRQ line.  This happens at the end of every instruction.  'iBefore' is the state of the
+// I flag before the instruction executed.
+func (cpu *CPU) pollIRQ(iBefore bool) {
+	inhibited := cpu.isSet(I)
+	if cpu.delayedIFlag {
+		inhibited = iBefore
+	}
+	cpu.irqPending = (0 != cpu.irqLine) && !inhibited
 }
 
 func (cpu *CPU) Interpret() uint64 {
+	// A jammed CPU does nothing but let time pass.  The address bus is stuck at 0xFFFF.
+	if cpu.jammed {
+		cpu.clockCycles = 0
+		cpu.read(0xffff)
+		return cpu.clockCycles
+	}
+
+	// An interrupt detected at the end of the last instruction hijacks this one.
+	if cpu.irqPending {
+		return cpu.irq()
+	}
+
+	if nil != cpu.trace {
+		cpu.traceOp()
+	}
+
 	cpu.clockCycles = 0
 
 	// Save this for logging.  The PC is incremented as part of execution but we want
@@ -135,25 +277,26 @@ func (cpu *CPU) Interpret() uint64 {
 	opcode := cpu.readPC8()
 	op := opTable[opcode]
 
-	// Some opcodes are bad.  Perhaps they should trigger a reset?
+	// Every opcode is in the table, but jam rather than crash if one goes missing.
 	if nil == op {
-		panic(fmt.Sprintf("no optable entry for opcode 0x%02x, opaddr=0x%4x, regs=%s",
-				  opcode, opAddr, cpu.formatRegisters()))
+		cpu.opKil()
+		return cpu.clockCycles
 	}
 
 	// There are a handful of addressing modes that each instruction can choose from.
 	// The resolution of the final address only depends on the addressing mode, so we
 	// tag each opcode with its addressing mode and resolve the address as its own step.
-	cpu.readAddressOfOperand(op)
+	cpu.readAddressOfOperand(opcode, op)
 
 	if cpu.Debug {
 		cpu.logOp(opAddr, opcode, op)
 	}
 
-	// Execute the op and track how many cycles it took.  We use += instead of = to track
-	// the cycles because the address mode resolution may incur extra clock cycles.
-	cpu.clockCycles += op.cycles
+	// Execute the op.  Its cycles are counted as it accesses memory.
+	iBefore := cpu.isSet(I)
+	cpu.delayedIFlag = false
 	op.exec(cpu)
 
+	cpu.pollIRQ(iBefore)
 	return cpu.clockCycles
 }
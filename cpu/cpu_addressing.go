@@ -21,16 +21,26 @@ const (
 	IND
 	INDY
 	INDX
+
+	// The op table uses IMP for the accumulator and IMM for relative branches, since the
+	// operand is found the same way.  LookupOpcode reports these instead, for the disassembler.
+	ACC
+	REL
 )
 
-// Calculate the full address that 'op' refers to and store it in 'cpu.opAddr'.
+// Calculate the full address that 'op' ('opcode') refers to and store it in 'cpu.opAddr'.
 // Also places the literal address in the opcode into 'cpu.opRawAddr'.
-func (cpu *CPU) readAddressOfOperand(op *OpcodeEntry) {
+//
+// Memory is accessed just as the 6502 does, including the reads it throws away, since reading
+// some registers has side effects.
+func (cpu *CPU) readAddressOfOperand(opcode uint8, op *OpcodeEntry) {
 	switch(op.addressing) {
 	case BAD:
 		// noop
 	case IMP:
-		// noop
+		// The byte after the opcode is read while the opcode is decoded, whether or not it's
+		// needed.
+		cpu.read(cpu.pc)
 	case IMM:
 		cpu.addrIMM()
 	case ZP:
@@ -40,7 +50,12 @@ func (cpu *CPU) readAddressOfOperand(op *OpcodeEntry) {
 	case ZPY:
 		cpu.addrZPY()
 	case ABS:
-		cpu.addrABS()
+		// JSR pushes the return address before it reads the high byte of its operand.
+		if 0x20 == opcode {
+			cpu.addrJSR()
+		} else {
+			cpu.addrABS()
+		}
 	case ABSX:
 		cpu.addrABSX(op.extraCycles)
 	case ABSY:
@@ -54,9 +69,31 @@ func (cpu *CPU) readAddressOfOperand(op *OpcodeEntry) {
 	}
 }
 
+// Start a cycle.  The rest of the machine catches up first.
+func (cpu *CPU) cycle() {
+	cpu.clockCycles++
+	if nil != cpu.cycleHook {
+		cpu.cycleHook()
+	}
+}
+
+// Read the byte at 'addr', taking a cycle.
+func (cpu *CPU) read(addr uint16) uint8 {
+	cpu.cycle()
+	return cpu.mem.Read(addr)
+}
+
+// Write 'val' at 'addr', taking a cycle.  Sprite DMA takes hundreds more.
+func (cpu *CPU) write(addr uint16, val uint8) {
+	cpu.cycle()
+	for extra := cpu.mem.Write(addr, val); extra > 0; extra-- {
+		cpu.cycle()
+	}
+}
+
 // Read one byte from *pc and increment pc.
 func (cpu *CPU) readPC8() (out uint8) {
-	out = cpu.mem.Read(cpu.pc)
+	out = cpu.read(cpu.pc)
 	cpu.pc++
 	return out
 }
@@ -83,8 +120,10 @@ func (cpu *CPU) addrZP() {
 
 // Zero-page indexed X.  The zero-page address given is added to the X register to give the actual
 // address, which is itself zero-page.  As an example, xr=1 and *pc=0xff implies that opAddr=0.
+// The unindexed address is read while the addition happens.
 func (cpu *CPU) addrZPX() {
 	cpu.opRawAddr = uint16(cpu.readPC8())
+	cpu.read(cpu.opRawAddr)
 	cpu.opAddr = cpu.opRawAddr + uint16(cpu.xr)
 	cpu.opAddr &= 0xff
 }
@@ -93,6 +132,7 @@ func (cpu *CPU) addrZPX() {
 // address, which is itself zero-page.
 func (cpu *CPU) addrZPY() {
 	cpu.opRawAddr = uint16(cpu.readPC8())
+	cpu.read(cpu.opRawAddr)
 	cpu.opAddr = cpu.opRawAddr + uint16(cpu.yr)
 	cpu.opAddr &= 0xff
 }
@@ -103,6 +143,12 @@ func (cpu *CPU) addrABS() {
 	cpu.opAddr = cpu.opRawAddr
 }
 
+// JSR's operand is absolute, but only the low byte is read here.  opJsr reads the high byte once
+// it's pushed the return address.
+func (cpu *CPU) addrJSR() {
+	cpu.opRawAddr = uint16(cpu.readPC8())
+}
+
 // Indirect addressing.  This is only used with JMP.  There is a bug in the hardware that we
 // recreate (and unit test).  To quote nestech.txt:
 //
@@ -123,7 +169,7 @@ func (cpu *CPU) addrABS() {
 func (cpu *CPU) addrIND() {
 	tmpAddr := cpu.readPC16()
 	cpu.opRawAddr = tmpAddr
-	lowByte := cpu.mem.Read(tmpAddr)
+	lowByte := cpu.read(tmpAddr)
 
 	// We add 1 to tmpAddr to get the address of the higher-order byte for the address.
 	// However, there isn't a carry from the lower byte to the higher byte, so if we'd carry out
@@ -134,26 +180,32 @@ func (cpu *CPU) addrIND() {
 		tmpAddr++
 	}
 
-	highByte := cpu.mem.Read(tmpAddr)
+	highByte := cpu.read(tmpAddr)
 	cpu.opAddr = uint16(lowByte) | (uint16(highByte) << 8);
 }
 
+// The 6502 adds the index to the low byte of an address, and fixes up the high byte in another
+// cycle if that carried.  During that cycle it reads from the address with the high byte not yet
+// fixed.  Instructions that only read (those with 'extraCycles') skip the cycle if there's no
+// carry; the others always take it.  'base' is the address before indexing.
+func (cpu *CPU) fixHighByte(base uint16, extraCycles uint64) {
+	if (base & 0xff00) != (cpu.opAddr & 0xff00) || 0 == extraCycles {
+		cpu.read((base & 0xff00) | (cpu.opAddr & 0xff))
+	}
+}
+
 // Absolute + X.  A 16-bit address follows the opcode.  We add it to the XR.
 func (cpu *CPU) addrABSX(extraCycles uint64) {
 	cpu.opRawAddr = cpu.readPC16()
 	cpu.opAddr = cpu.opRawAddr + uint16(cpu.xr)
-	if (cpu.opRawAddr & 0xff00) != (cpu.opAddr & 0xff00) {
-		cpu.clockCycles += extraCycles
-	}
+	cpu.fixHighByte(cpu.opRawAddr, extraCycles)
 }
 
 // Absolute + Y.
 func (cpu *CPU) addrABSY(extraCycles uint64) {
 	cpu.opRawAddr = cpu.readPC16()
 	cpu.opAddr = cpu.opRawAddr + uint16(cpu.yr)
-	if (cpu.opRawAddr & 0xff00) != (cpu.opAddr & 0xff00) {
-		cpu.clockCycles += extraCycles
-	}
+	cpu.fixHighByte(cpu.opRawAddr, extraCycles)
 }
 
 // Pre-indexed indirect.  A zero-page address is added to xr to give the zero-page address of the
@@ -162,21 +214,21 @@ func (cpu *CPU) addrINDX() {
 	// The addition of the zp addr and the xr are done as uint8 as the resulting address
 	// is on the zero page.  All reads are done from the zero page.
 	cpu.opRawAddr = uint16(cpu.readPC8())
+	// Like zero-page indexed, the unindexed address is read while X is added.
+	cpu.read(cpu.opRawAddr)
 	zpaddr := 0xff & (cpu.opRawAddr + uint16(cpu.xr))
-	cpu.opAddr = uint16(cpu.mem.Read(zpaddr))
-	cpu.opAddr |= (uint16(cpu.mem.Read(0xff & (zpaddr + 1))) << 8)
+	cpu.opAddr = uint16(cpu.read(zpaddr))
+	cpu.opAddr |= (uint16(cpu.read(0xff & (zpaddr + 1))) << 8)
 }
 
 // Post-indexed indirect.  A zero-page address is provided in the opcode.  A 16-bit address is
 // read from there and the yr is added to it to obtain the target address.
 func (cpu *CPU) addrINDY(extraCycles uint64) {
 	cpu.opRawAddr = uint16(cpu.readPC8())
-	cpu.opAddr = uint16(cpu.mem.Read(cpu.opRawAddr))
+	cpu.opAddr = uint16(cpu.read(cpu.opRawAddr))
 	// The high byte is located on the zero page as well, so we wrap opRawAddr+1.
-	cpu.opAddr |= (uint16(cpu.mem.Read(0xff & (cpu.opRawAddr + 1))) << 8)
+	cpu.opAddr |= (uint16(cpu.read(0xff & (cpu.opRawAddr + 1))) << 8)
 	addrPreYRegister := cpu.opAddr
 	cpu.opAddr += uint16(cpu.yr)
-	if (cpu.opAddr & 0xff00) != (addrPreYRegister & 0xff00) {
-		cpu.clockCycles += extraCycles
-	}
+	cpu.fixHighByte(addrPreYRegister, extraCycles)
 }
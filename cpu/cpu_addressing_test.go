@@ -42,7 +42,7 @@ func TestReadPC8(t *testing.T) {
 
 // Ensure that we're reading LSB-first
 func TestReadPC16(t *testing.T) {
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 
 	var addr uint16 = 0x1010
@@ -61,7 +61,7 @@ func TestReadPC16(t *testing.T) {
 
 // Test immediate addressing aka reading from the PC
 func TestAddressImmediate(t *testing.T) {
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 
 	var addr uint16 = 0x1010
@@ -81,7 +81,7 @@ func TestAddressImmediate(t *testing.T) {
 
 // Test zero page absolute addressing.
 func TestAddressAbsoluteZeroPage(t *testing.T) {
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 
 	var addr uint16 = 0x1010
@@ -98,7 +98,7 @@ func TestAddressAbsoluteZeroPage(t *testing.T) {
 }
 
 func TestZeroPageIndexedX(t *testing.T) {
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 
 	var addr uint16 = 0x1010
@@ -116,7 +116,7 @@ func TestZeroPageIndexedX(t *testing.T) {
 }
 
 func TestZeroPageIndexedY(t *testing.T) {
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 
 	var addr uint16 = 0x1010
@@ -134,7 +134,7 @@ func TestZeroPageIndexedY(t *testing.T) {
 }
 
 func TestAddressAbsolute(t *testing.T) {
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 
 	var addr uint16 = 0x1010
@@ -154,7 +154,7 @@ func TestAddressAbsolute(t *testing.T) {
 }
 
 func TestAddressIndirect(t *testing.T) {
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 
 	// The address that the operand refers to is 0xc1ff.
@@ -174,7 +174,7 @@ func TestAddressIndirect(t *testing.T) {
 }
 
 func TestAddressIndirectNoCarryFromLowByte(t *testing.T) {
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 
 	// The address that the operand refers to is 0xc1ff.
@@ -195,7 +195,7 @@ func TestAddressIndirectNoCarryFromLowByte(t *testing.T) {
 }
 
 func TestAddressAbsoluteX(t *testing.T) {
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 
 	var pcAddr uint16 = 0xc0c0
@@ -215,7 +215,7 @@ func TestAddressAbsoluteX(t *testing.T) {
 }
 
 func TestAddressAbsoluteY(t *testing.T) {
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 
 	var pcAddr uint16 = 0xc0c0
@@ -236,7 +236,7 @@ func TestAddressAbsoluteY(t *testing.T) {
 
 func TestAddressPreIndexedIndirect(t *testing.T) {
 	// Set up base state
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 	var pcAddr uint16 = 0xc0c0
 	mycpu.pc = pcAddr
@@ -259,7 +259,7 @@ func TestAddressPreIndexedIndirect(t *testing.T) {
 // Test that XR + zp addr are summed with 8bit math
 func TestAddressPreIndexedIndirectZeroPage(t *testing.T) {
 	// Set up base state
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 	var pcAddr uint16 = 0xc0c0
 	mycpu.pc = pcAddr
@@ -282,7 +282,7 @@ func TestAddressPreIndexedIndirectZeroPage(t *testing.T) {
 // Test that reading the address across a page boundary is ok (that is, from 0xff and 0x100)
 func TestAddressPreIndexedIndirectZeroPageToFirstPage(t *testing.T) {
 	// Set up base state
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 	var pcAddr uint16 = 0xc0c0
 	mycpu.pc = pcAddr
@@ -305,7 +305,7 @@ func TestAddressPreIndexedIndirectZeroPageToFirstPage(t *testing.T) {
 // Test indirect indexed aka ind_y().
 func TestAddressPreIndexedIndirectIndexedY(t *testing.T) {
 	// Set up base state
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 	var pcAddr uint16 = 0xc0c0
 	mycpu.pc = pcAddr
@@ -328,7 +328,7 @@ func TestAddressPreIndexedIndirectIndexedY(t *testing.T) {
 // Test that indirect indexed aka ind_y() wraps if the zp addr is 0xff
 func TestAddressPreIndexedIndirectIndexedYWraps(t *testing.T) {
 	// Set up base state
-	var mymem = mem.MakeMemoryForTesting()
+	var mymem = NewMemoryForTesting()
 	var mycpu = NewCPU(mymem)
 	var pcAddr uint16 = 0xc0c0
 	mycpu.pc = pcAddr
@@ -0,0 +1,59 @@
+package cpu_test
+
+// Tests written as 6502 source.  They're in their own package because the assembler uses the
+// CPU's op table.
+
+import (
+	"testing"
+
+	"asm"
+	"cpu"
+)
+
+// Assemble 'source', which must start at 0x8000, and run 'count' instructions of it.
+func runSource(t *testing.T, source string, count int) (cpu.Registers, *cpu.MemoryForTesting) {
+	prog, err := asm.Assemble("\t.org $8000\n" + source)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	mem := cpu.NewMemoryForTesting()
+	prog.Load(mem)
+	mem.Write(0xfffc, 0x00)
+	mem.Write(0xfffd, 0x80)
+
+	mycpu := cpu.NewCPU(mem)
+	for i := 0; i < count; i++ {
+		mycpu.Interpret()
+	}
+	return mycpu.Registers(), mem
+}
+
+// DCP decrements memory and compares the result with A.
+func TestDCP(t *testing.T) {
+	regs, mem := runSource(t, `
+		LDA #$05
+		STA $10
+		LDA #$04
+		DCP $10
+	`, 4)
+
+	if 0x04 != mem.Read(0x10) || cpu.Z != (regs.P & cpu.Z) || cpu.C != (regs.P & cpu.C) {
+		t.Errorf("Expected 04 in memory with Z and C set, got %02X and P=%02X", mem.Read(0x10),
+			 regs.P)
+	}
+}
+
+// LAX loads A and X together.
+func TestLAX(t *testing.T) {
+	regs, _ := runSource(t, `
+		LDY #$01
+		LAX value,Y
+		BRK
+	value:	.byte $00, $80
+	`, 2)
+
+	if 0x80 != regs.A || 0x80 != regs.X || cpu.N != (regs.P & cpu.N) {
+		t.Errorf("Expected A=X=80 with N set, got A=%02X X=%02X P=%02X", regs.A, regs.X, regs.P)
+	}
+}
@@ -3,13 +3,26 @@ package cpu
 // Read the argument to the opcode.  Assumes opAddr is already filled according to the
 // addressing mode implied by the opcode.
 func (cpu *CPU) readOpData() uint8 {
-	return cpu.mem.Read(cpu.opAddr)
+	return cpu.read(cpu.opAddr)
+}
+
+// Read the argument to a read-modify-write opcode.  The 6502 writes the value straight back while
+// it's modifying it, before writing the result with writeOpData.
+func (cpu *CPU) readModifyOpData() (data uint8) {
+	data = cpu.readOpData()
+	cpu.writeOpData(data)
+	return
+}
+
+// Write the result of the opcode to opAddr.
+func (cpu *CPU) writeOpData(val uint8) {
+	cpu.write(cpu.opAddr, val)
 }
 
 // Push the provided byte on the stack.
 func (cpu *CPU) push(value uint8) {
 	var addr uint16 = 0x100 + uint16(cpu.sp)
-	cpu.clockCycles += cpu.mem.Write(addr, value)
+	cpu.write(addr, value)
 	cpu.sp--
 }
 
@@ -17,7 +30,13 @@ func (cpu *CPU) push(value uint8) {
 func (cpu *CPU) pop() uint8 {
 	cpu.sp++
 	var addr uint16 = 0x100 + uint16(cpu.sp)
-	return cpu.mem.Read(addr)
+	return cpu.read(addr)
+}
+
+// Read the top of the stack without popping it.  Instructions that pop spend a cycle doing this
+// while the stack pointer is incremented, and JSR does it too.
+func (cpu *CPU) readStack() {
+	cpu.read(0x100 + uint16(cpu.sp))
 }
 
 // Push a two byte word to the stack.
@@ -34,12 +53,12 @@ func (cpu *CPU) popWord() uint16 {
 // Generic branching routine.  The logic for applying the branch offset and incurring
 // extra clock cycles is the same for every branching operation.
 func (cpu *CPU) genericBranch(should bool) {
+	// The offset is read whether or not the branch is taken.
+	offset := uint16(cpu.readOpData())
 	if !should {
 		return
 	}
 
-	offset := uint16(cpu.readOpData())
-
 	// Sign-extend offset from 8 bits to 16 bits so that a negative branch is applied
 	// correctly.
 	if 0x80 == (0x80 & offset) {
@@ -48,11 +67,12 @@ func (cpu *CPU) genericBranch(should bool) {
 
 	newPC := cpu.pc + offset
 
-	// If the new PC is on a different page the branch takes longer.
+	// Taking the branch takes a cycle, in which the next opcode is read and thrown away.  If the
+	// new PC is on a different page, fixing the high byte takes another, and the address with
+	// the old high byte is read.
+	cpu.read(cpu.pc)
 	if (newPC & 0xff00) != (cpu.pc & 0xff00) {
-		cpu.clockCycles += 2
-	} else {
-		cpu.clockCycles += 1
+		cpu.read((cpu.pc & 0xff00) | (newPC & 0xff))
 	}
 
 	cpu.pc = newPC
@@ -129,7 +149,7 @@ func (cpu *CPU) opAac() {
 
 func (cpu *CPU) opAax() {
 	data := cpu.ac & cpu.xr
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, data)
+	cpu.writeOpData(data)
 }
 
 func (cpu *CPU) opAdc() {
@@ -158,10 +178,10 @@ func (cpu *CPU) opAslAcc() {
 }
 
 func (cpu *CPU) opAsl() {
-	data := cpu.readOpData()
+	data := cpu.readModifyOpData()
 	cpu.set(C, 0x80 == (data & 0x80))
 	data <<= 1
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, data)
+	cpu.writeOpData(data)
 	cpu.setZN(data)
 }
 
@@ -193,6 +213,10 @@ func (cpu *CPU) opAtx() {
 	cpu.setZN(cpu.xr)
 }
 
+func (cpu *CPU) opAxa() {
+	cpu.storeHighAnd(cpu.ac & cpu.xr, cpu.yr)
+}
+
 func (cpu *CPU) opAxs() {
 	result := uint16(cpu.xr & cpu.ac) - uint16(cpu.readOpData())
 	cpu.set(C, result < 0x100)
@@ -236,7 +260,7 @@ func (cpu *CPU) opBrk() {
 	cpu.set(B, true)
 	cpu.push(ALWAYS_ON | cpu.st)
 	cpu.set(I, true)
-	cpu.pc = uint16(cpu.mem.Read(vectorIRQBRK)) | (uint16(cpu.mem.Read(vectorIRQBRK + 1)) << 8)
+	cpu.pc = uint16(cpu.read(vectorIRQBRK)) | (uint16(cpu.read(vectorIRQBRK + 1)) << 8)
 }
 
 func (cpu *CPU) opBvc() {
@@ -257,6 +281,7 @@ func (cpu *CPU) opCld() {
 
 func (cpu *CPU) opCli() {
 	cpu.set(I, false)
+	cpu.delayedIFlag = true
 }
 
 func (cpu *CPU) opClv() {
@@ -282,16 +307,16 @@ func (cpu *CPU) opCpy() {
 }
 
 func (cpu *CPU) opDcp() {
-	data := cpu.readOpData() - 1
+	data := cpu.readModifyOpData() - 1
 	cpu.set(C, cpu.ac >= data)
 	cpu.setZN(cpu.ac - data)
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, data)
+	cpu.writeOpData(data)
 }
 
 func (cpu *CPU) opDec() {
-	data := cpu.readOpData() - 1
+	data := cpu.readModifyOpData() - 1
 	cpu.setZN(data)
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, data)
+	cpu.writeOpData(data)
 }
 
 func (cpu *CPU) opDex() {
@@ -310,8 +335,8 @@ func (cpu *CPU) opEor() {
 }
 
 func (cpu *CPU) opInc() {
-	data := cpu.readOpData() + 1
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, data)
+	data := cpu.readModifyOpData() + 1
+	cpu.writeOpData(data)
 	cpu.setZN(data)
 }
 
@@ -326,9 +351,9 @@ func (cpu *CPU) opIny() {
 }
 
 func (cpu *CPU) opIsc() {
-	opData := uint16(cpu.readOpData())
+	opData := uint16(cpu.readModifyOpData())
 	opData = 0xff & (opData + 1)
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, uint8(opData))
+	cpu.writeOpData(uint8(opData))
 
 	// TODO: This is cribbed from opSbc, just factor out?
 	result := uint16(cpu.ac) - opData
@@ -346,11 +371,27 @@ func (cpu *CPU) opJmp() {
 	cpu.pc = cpu.opAddr
 }
 
+// The PC is left on the high byte of the operand by addrJSR, which is what's pushed.
 func (cpu *CPU) opJsr() {
-	cpu.pushWord(cpu.pc - 1)
+	cpu.readStack()
+	cpu.pushWord(cpu.pc)
+	cpu.opAddr = cpu.opRawAddr | (uint16(cpu.read(cpu.pc)) << 8)
 	cpu.pc = cpu.opAddr
 }
 
+// The CPU stops, and only a reset starts it again.  The PC is left on the KIL.
+func (cpu *CPU) opKil() {
+	cpu.pc--
+	cpu.jammed = true
+}
+
+func (cpu *CPU) opLar() {
+	cpu.sp &= cpu.readOpData()
+	cpu.ac = cpu.sp
+	cpu.xr = cpu.sp
+	cpu.setZN(cpu.sp)
+}
+
 func (cpu *CPU) opLax() {
 	cpu.ac = cpu.readOpData()
 	cpu.xr = cpu.ac
@@ -379,15 +420,24 @@ func (cpu *CPU) opLsrAcc() {
 }
 
 func (cpu *CPU) opLsr() {
-	data := cpu.readOpData()
+	data := cpu.readModifyOpData()
 	cpu.set(C, 1 == (data & 1))
 	data >>= 1
 	cpu.setZN(data)
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, data)
+	cpu.writeOpData(data)
 }
 
 func (cpu *CPU) opNop() { }
 
+// DOP and TOP do nothing with their operand, but they do read it.
+func (cpu *CPU) opDop() {
+	cpu.readOpData()
+}
+
+func (cpu *CPU) opTop() {
+	cpu.readOpData()
+}
+
 func (cpu *CPU) opOra() {
 	cpu.ac |= cpu.readOpData()
 	cpu.setZN(cpu.ac)
@@ -402,23 +452,26 @@ func (cpu *CPU) opPhp() {
 }
 
 func (cpu *CPU) opPla() {
+	cpu.readStack()
 	cpu.ac = cpu.pop()
 	cpu.setZN(cpu.ac)
 }
 
 func (cpu *CPU) opPlp() {
+	cpu.readStack()
 	cpu.st = ALWAYS_ON | (cpu.pop() & ^B)
+	cpu.delayedIFlag = true
 }
 
 func (cpu *CPU) opRla() {
-	data := cpu.readOpData()
+	data := cpu.readModifyOpData()
 	carry := cpu.isSet(C)
 	cpu.set(C, 0x80 == (data & 0x80))
 	data <<= 1
 	if carry {
 		data |= 1
 	}
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, data)
+	cpu.writeOpData(data)
 	cpu.ac &= data
 	cpu.setZN(cpu.ac)
 }
@@ -434,14 +487,14 @@ func (cpu *CPU) opRolAcc() {
 }
 
 func (cpu *CPU) opRol() {
-	data := cpu.readOpData()
+	data := cpu.readModifyOpData()
 	carry := cpu.isSet(C)
 	cpu.set(C, 0x80 == (data & 0x80))
 	data <<= 1
 	if carry {
 		data++
 	}
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, data)
+	cpu.writeOpData(data)
 	cpu.setZN(data)
 }
 
@@ -456,26 +509,26 @@ func (cpu *CPU) opRorAcc() {
 }
 
 func (cpu *CPU) opRor() {
-	data := cpu.readOpData()
+	data := cpu.readModifyOpData()
 	carry := cpu.isSet(C)
 	cpu.set(C, 1 == (data & 1))
 	data >>= 1
 	if carry {
 		data += 0x80
 	}
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, data)
+	cpu.writeOpData(data)
 	cpu.setZN(data)
 }
 
 func (cpu *CPU) opRra() {
-	opData := uint16(cpu.readOpData())
+	opData := uint16(cpu.readModifyOpData())
 	carry := cpu.isSet(C)
 	cpu.set(C, 1 == (opData & 1))
 	opData >>= 1
 	if carry {
 		opData |= 0x80
 	}
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, uint8(opData))
+	cpu.writeOpData(uint8(opData))
 
 	// TODO: This is duplicated logic from opAdc, somehow factor out?
 	result := uint16(cpu.ac) + opData
@@ -491,13 +544,17 @@ func (cpu *CPU) opRra() {
 }
 
 func (cpu *CPU) opRti() {
+	cpu.readStack()
 	// There is no actual BRK flag, only exists in the flag when pushed to stack.
 	cpu.st = ALWAYS_ON | (cpu.pop() & ^B)
 	cpu.pc = cpu.popWord()
 }
 
 func (cpu *CPU) opRts() {
+	cpu.readStack()
 	cpu.pc = cpu.popWord()
+	// Incrementing the PC takes a cycle too.
+	cpu.read(cpu.pc)
 	cpu.pc++
 }
 
@@ -525,66 +582,63 @@ func (cpu *CPU) opSed() {
 
 func (cpu *CPU) opSei() {
 	cpu.set(I, true)
+	cpu.delayedIFlag = true
 }
 
 func (cpu *CPU) opSlo() {
-	data := cpu.readOpData()
+	data := cpu.readModifyOpData()
 	cpu.set(C, 0x80 == (data & 0x80))
 	data <<= 1
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, data)
+	cpu.writeOpData(data)
 	cpu.ac |= data
 	cpu.setZN(cpu.ac)
 }
 
 func (cpu *CPU) opSre() {
-	data := cpu.readOpData()
+	data := cpu.readModifyOpData()
 	cpu.set(C, 1 == (data & 1))
 	data >>= 1
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, data)
+	cpu.writeOpData(data)
 	cpu.ac ^= data
 	cpu.setZN(cpu.ac)
 }
 
 func (cpu *CPU) opSta() {
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, cpu.ac)
+	cpu.writeOpData(cpu.ac)
 }
 
 func (cpu *CPU) opStx() {
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, cpu.xr)
+	cpu.writeOpData(cpu.xr)
 }
 
 func (cpu *CPU) opSty() {
-	cpu.clockCycles += cpu.mem.Write(cpu.opAddr, cpu.yr)
+	cpu.writeOpData(cpu.yr)
 }
 
-// The address calculations in SXA and SYA are kind of crazy and based on a discussion from:
+// The address calculations in AXA, SXA, SYA and XAS are kind of crazy and based on a discussion
+// from:
 // http://forums.nesdev.com/viewtopic.php?f=3&t=10698&sid=87e2c7959251b873fbb89b443f4d50db&start=15
-func (cpu *CPU) opSxa() {
-	low := cpu.opRawAddr & 0xff
-	high := cpu.opRawAddr >> 8
-
-	address := low
-	if low + uint16(cpu.yr) > 0xff {
-		address = ((high + 1) & uint16(cpu.xr)) << 8 + ((uint16(cpu.yr) + low) & 0xff)
-	} else {
-		address = (high << 8) + uint16(cpu.yr) + low
+//
+// 'val' is ANDed with the high byte of the unindexed address plus one, and stored.  If adding
+// 'index' crossed a page, the stored value becomes the high byte of the address too.
+func (cpu *CPU) storeHighAnd(val uint8, index uint8) {
+	base := cpu.opAddr - uint16(index)
+	val &= uint8(base >> 8) + 1
+
+	address := cpu.opAddr
+	if (base & 0xff00) != (cpu.opAddr & 0xff00) {
+		address = (uint16(val) << 8) | (cpu.opAddr & 0xff)
 	}
 
-	cpu.clockCycles += cpu.mem.Write(address, cpu.xr & uint8(high + 1))
+	cpu.write(address, val)
 }
 
-func (cpu *CPU) opSya() {
-	low := cpu.opRawAddr & 0xff
-	high := cpu.opRawAddr >> 8
-
-	address := low
-	if low + uint16(cpu.xr) > 0xff {
-		address = ((high + 1) & uint16(cpu.yr)) << 8 + ((uint16(cpu.xr) + low) & 0xff)
-	} else {
-		address = (high << 8) + uint16(cpu.xr) + low
-	}
+func (cpu *CPU) opSxa() {
+	cpu.storeHighAnd(cpu.xr, cpu.yr)
+}
 
-	cpu.clockCycles += cpu.mem.Write(address, cpu.yr & uint8(high + 1))
+func (cpu *CPU) opSya() {
+	cpu.storeHighAnd(cpu.yr, cpu.xr)
 }
 
 func (cpu *CPU) opTax() {
@@ -615,3 +669,15 @@ func (cpu *CPU) opTya() {
 	cpu.ac = cpu.yr
 	cpu.setZN(cpu.ac)
 }
+
+// XAA is unstable on real hardware.  This is how most CPUs behave: some bits of A come through
+// unchanged, depending on a "magic" constant that's usually 0xEE.
+func (cpu *CPU) opXaa() {
+	cpu.ac = (cpu.ac | 0xee) & cpu.xr & cpu.readOpData()
+	cpu.setZN(cpu.ac)
+}
+
+func (cpu *CPU) opXas() {
+	cpu.sp = cpu.ac & cpu.xr
+	cpu.storeHighAnd(cpu.sp, cpu.yr)
+}
@@ -0,0 +1,112 @@
+package cpu
+
+import (
+	"testing"
+)
+
+// Set up a CPU executing from 0x8000 with the IRQ vector pointing at 0x9000.  'program' is
+// written at 0x8000.
+func setUpIRQTest(program []uint8) *CPU {
+	var mymem = NewMemoryForTesting()
+	mymem.Write(vectorReset, 0x00)
+	mymem.Write(vectorReset + 1, 0x80)
+	mymem.Write(vectorIRQBRK, 0x00)
+	mymem.Write(vectorIRQBRK + 1, 0x90)
+
+	for i, b := range program {
+		mymem.Write(0x8000 + uint16(i), b)
+	}
+
+	return NewCPU(mymem)
+}
+
+// An asserted IRQ is ignored while I is set.
+func TestIRQMaskedByIFlag(t *testing.T) {
+	// NOP NOP
+	mycpu := setUpIRQTest([]uint8{0xea, 0xea})
+	mycpu.set(I, true)
+	mycpu.AssertIRQ(IRQ_MAPPER)
+
+	mycpu.Interpret()
+	mycpu.Interpret()
+
+	if mycpu.pc != 0x8002 {
+		t.Fatal("IRQ was serviced with I set, pc is", mycpu.pc)
+	}
+}
+
+// An IRQ is serviced after the instruction during which it was detected, and B is not pushed.
+func TestIRQServiced(t *testing.T) {
+	// NOP NOP
+	mycpu := setUpIRQTest([]uint8{0xea, 0xea})
+	mycpu.set(I, false)
+	mycpu.AssertIRQ(IRQ_APU_FRAME)
+
+	mycpu.Interpret()
+	if cycles := mycpu.Interpret(); cycles != 7 {
+		t.Fatal("IRQ took", cycles, "cycles")
+	}
+
+	if mycpu.pc != 0x9000 {
+		t.Fatal("IRQ wasn't serviced, pc is", mycpu.pc)
+	}
+	if !mycpu.isSet(I) {
+		t.Fatal("I wasn't set by the IRQ")
+	}
+	if 0 != (mycpu.pop() & B) {
+		t.Fatal("B was pushed by an IRQ")
+	}
+	if 0x8001 != mycpu.popWord() {
+		t.Fatal("wrong return address pushed")
+	}
+}
+
+// CLI takes effect one instruction late: the instruction after CLI runs before the IRQ.
+func TestIRQDelayedByCLI(t *testing.T) {
+	// CLI NOP NOP
+	mycpu := setUpIRQTest([]uint8{0x58, 0xea, 0xea})
+	mycpu.set(I, true)
+	mycpu.AssertIRQ(IRQ_APU_DMC)
+
+	mycpu.Interpret()
+	mycpu.Interpret()
+	if mycpu.pc != 0x8002 {
+		t.Fatal("the instruction after CLI didn't run, pc is", mycpu.pc)
+	}
+
+	mycpu.Interpret()
+	if mycpu.pc != 0x9000 {
+		t.Fatal("IRQ wasn't serviced after CLI, pc is", mycpu.pc)
+	}
+}
+
+// SEI still lets an IRQ through if it was asserted before the SEI.
+func TestIRQLetThroughBySEI(t *testing.T) {
+	// SEI NOP
+	mycpu := setUpIRQTest([]uint8{0x78, 0xea})
+	mycpu.set(I, false)
+	mycpu.AssertIRQ(IRQ_MAPPER)
+
+	mycpu.Interpret()
+	mycpu.Interpret()
+	if mycpu.pc != 0x9000 {
+		t.Fatal("IRQ wasn't serviced after SEI, pc is", mycpu.pc)
+	}
+}
+
+// Releasing the only asserting source drops the line.
+func TestIRQReleased(t *testing.T) {
+	// NOP NOP
+	mycpu := setUpIRQTest([]uint8{0xea, 0xea})
+	mycpu.set(I, false)
+	mycpu.AssertIRQ(IRQ_MAPPER)
+	mycpu.AssertIRQ(IRQ_APU_FRAME)
+	mycpu.ReleaseIRQ(IRQ_MAPPER)
+	mycpu.ReleaseIRQ(IRQ_APU_FRAME)
+
+	mycpu.Interpret()
+	mycpu.Interpret()
+	if mycpu.pc != 0x8002 {
+		t.Fatal("IRQ was serviced after being released, pc is", mycpu.pc)
+	}
+}
@@ -0,0 +1,820 @@
+package cpu
+
+// Per-opcode tests.  Each test sets up the registers and memory, executes one instruction and
+// checks the registers, memory and cycle count afterwards, and optionally every memory access the
+// instruction made.
+//
+// The format is that of the community "single step" tests, which have 10,000 randomized tests
+// for each opcode: https://github.com/SingleStepTests/65x02/tree/main/nes6502.  They aren't
+// checked in.  Put the JSON files (00.json to ff.json) in testdata/nes6502, or point
+// NES6502_TESTS at them.  Hand-written tests below check every documented opcode, and the
+// undocumented ones, either way.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const defaultSingleStepDir = "testdata/nes6502"
+
+// With -short, only this many of each opcode's tests are run.
+const singleStepShortCount = 100
+
+// The CPU and the memory it can see.  'ram' is a list of address and value pairs.
+type singleStepState struct {
+	PC uint16 `json:"pc"`
+	S uint8 `json:"s"`
+	A uint8 `json:"a"`
+	X uint8 `json:"x"`
+	Y uint8 `json:"y"`
+	P uint8 `json:"p"`
+	RAM [][]int `json:"ram"`
+}
+
+type singleStepTest struct {
+	Name string `json:"name"`
+	Initial singleStepState `json:"initial"`
+	Final singleStepState `json:"final"`
+
+	// One entry per cycle, describing the bus activity as [address, value, "read" or "write"].
+	// If an entry is nil only the number of cycles is checked.
+	Cycles [][]interface{} `json:"cycles"`
+}
+
+// A memory access made by the CPU.
+type busAccess struct {
+	addr uint16
+	val uint8
+	write bool
+}
+
+func (access busAccess) String() string {
+	if access.write {
+		return fmt.Sprintf("write %02X to %04X", access.val, access.addr)
+	}
+	return fmt.Sprintf("read %02X from %04X", access.val, access.addr)
+}
+
+// Records every access.
+type recordingMemory struct {
+	*MemoryForTesting
+	accesses []busAccess
+}
+
+func (mem *recordingMemory) Read(addr uint16) (val uint8) {
+	val = mem.MemoryForTesting.Read(addr)
+	mem.accesses = append(mem.accesses, busAccess{addr, val, false})
+	return
+}
+
+func (mem *recordingMemory) Write(addr uint16, val uint8) (cycles uint64) {
+	mem.accesses = append(mem.accesses, busAccess{addr, val, true})
+	return mem.MemoryForTesting.Write(addr, val)
+}
+
+// Convert a cycle from the JSON.  Returns false if it's nil.
+func parseBusAccess(cycle []interface{}) (access busAccess, ok bool) {
+	if 3 != len(cycle) {
+		return access, false
+	}
+	addr, _ := cycle[0].(float64)
+	val, _ := cycle[1].(float64)
+	return busAccess{uint16(addr), uint8(val), "write" == cycle[2]}, true
+}
+
+// B only exists on the stack, and bit 5 is always set, so neither is compared.
+func comparableStatus(p uint8) uint8 {
+	return (p | ALWAYS_ON) & ^B
+}
+
+// Run 'test'.  Returns what went wrong, or "" if nothing did.
+func runSingleStep(test *singleStepTest) string {
+	mem := &recordingMemory{MemoryForTesting: NewMemoryForTesting()}
+	for _, pair := range test.Initial.RAM {
+		mem.MemoryForTesting.Write(uint16(pair[0]), uint8(pair[1]))
+	}
+
+	mycpu := NewCPU(mem)
+	mem.accesses = nil
+	mycpu.pc = test.Initial.PC
+	mycpu.sp = test.Initial.S
+	mycpu.ac = test.Initial.A
+	mycpu.xr = test.Initial.X
+	mycpu.yr = test.Initial.Y
+	mycpu.st = test.Initial.P
+
+	cycles := mycpu.Interpret()
+
+	final := test.Final
+	got := fmt.Sprintf("PC:%04X S:%02X A:%02X X:%02X Y:%02X P:%02X", mycpu.pc, mycpu.sp,
+			   mycpu.ac, mycpu.xr, mycpu.yr, comparableStatus(mycpu.st))
+	expected := fmt.Sprintf("PC:%04X S:%02X A:%02X X:%02X Y:%02X P:%02X", final.PC, final.S,
+				final.A, final.X, final.Y, comparableStatus(final.P))
+	if got != expected {
+		return fmt.Sprintf("expected %s, got %s", expected, got)
+	}
+
+	for _, pair := range final.RAM {
+		if val := mem.MemoryForTesting.Read(uint16(pair[0])); uint8(pair[1]) != val {
+			return fmt.Sprintf("expected %02X at %04X, got %02X", pair[1], pair[0], val)
+		}
+	}
+
+	if len(test.Cycles) != int(cycles) {
+		return fmt.Sprintf("expected %d cycles, got %d", len(test.Cycles), cycles)
+	}
+	if len(mem.accesses) != int(cycles) {
+		return fmt.Sprintf("took %d cycles, but accessed memory %d times", cycles, len(mem.accesses))
+	}
+	for i, cycle := range test.Cycles {
+		if access, ok := parseBusAccess(cycle); ok && access != mem.accesses[i] {
+			return fmt.Sprintf("cycle %d: expected %s, got %s", i + 1, access, mem.accesses[i])
+		}
+	}
+	return ""
+}
+
+// Hand-written tests.  Instructions with a memory operand are also run in each of their
+// addressing modes by TestAddressingModes.  Unset registers are 0 before and after.
+var opcodeTests = []singleStepTest {
+	{ "AAC #$F0",
+	  singleStepState{PC: 0x200, A: 0x81, P: 0x20, RAM: [][]int{{0x200, 0x0b}, {0x201, 0xf0}}},
+	  singleStepState{PC: 0x202, A: 0x80, P: 0xa1},
+	  make([][]interface{}, 2) },
+	{ "AAX $10",
+	  singleStepState{PC: 0x200, A: 0xf0, X: 0x3c, P: 0x20, RAM: [][]int{{0x200, 0x87}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x202, A: 0xf0, X: 0x3c, P: 0x20, RAM: [][]int{{0x10, 0x30}}},
+	  make([][]interface{}, 3) },
+	{ "ARR #$FF with C set",
+	  singleStepState{PC: 0x200, A: 0xc0, P: 0x21, RAM: [][]int{{0x200, 0x6b}, {0x201, 0xff}}},
+	  singleStepState{PC: 0x202, A: 0xe0, P: 0xa1},
+	  make([][]interface{}, 2) },
+	{ "ARR #$80 sets V",
+	  singleStepState{PC: 0x200, A: 0xff, P: 0x20, RAM: [][]int{{0x200, 0x6b}, {0x201, 0x80}}},
+	  singleStepState{PC: 0x202, A: 0x40, P: 0x61},
+	  make([][]interface{}, 2) },
+	{ "ASR #$FF",
+	  singleStepState{PC: 0x200, A: 0x03, P: 0x20, RAM: [][]int{{0x200, 0x4b}, {0x201, 0xff}}},
+	  singleStepState{PC: 0x202, A: 0x01, P: 0x21},
+	  make([][]interface{}, 2) },
+	{ "AXA $10F0,Y crossing a page",
+	  singleStepState{PC: 0x200, A: 0xff, X: 0x0f, Y: 0x20, P: 0x20,
+			  RAM: [][]int{{0x200, 0x9f}, {0x201, 0xf0}, {0x202, 0x10}}},
+	  singleStepState{PC: 0x203, A: 0xff, X: 0x0f, Y: 0x20, P: 0x20, RAM: [][]int{{0x0110, 0x01}}},
+	  make([][]interface{}, 5) },
+	{ "AXA ($10),Y",
+	  singleStepState{PC: 0x200, A: 0xff, X: 0xff, Y: 0x02, P: 0x20,
+			  RAM: [][]int{{0x200, 0x93}, {0x201, 0x10}, {0x10, 0x00}, {0x11, 0x20}}},
+	  singleStepState{PC: 0x202, A: 0xff, X: 0xff, Y: 0x02, P: 0x20, RAM: [][]int{{0x2002, 0x21}}},
+	  make([][]interface{}, 6) },
+	{ "ATX #$5A",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0xab}, {0x201, 0x5a}}},
+	  singleStepState{PC: 0x202, A: 0x5a, X: 0x5a, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "AXS #$10",
+	  singleStepState{PC: 0x200, A: 0xf0, X: 0x3c, P: 0x20, RAM: [][]int{{0x200, 0xcb}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x202, A: 0xf0, X: 0x20, P: 0x21},
+	  make([][]interface{}, 2) },
+	{ "AXS #$40 borrows",
+	  singleStepState{PC: 0x200, A: 0xf0, X: 0x3c, P: 0x21, RAM: [][]int{{0x200, 0xcb}, {0x201, 0x40}}},
+	  singleStepState{PC: 0x202, A: 0xf0, X: 0xf0, P: 0xa0},
+	  make([][]interface{}, 2) },
+	{ "DCP $10",
+	  singleStepState{PC: 0x200, A: 0x04, P: 0x20,
+			  RAM: [][]int{{0x200, 0xc7}, {0x201, 0x10}, {0x10, 0x05}}},
+	  singleStepState{PC: 0x202, A: 0x04, P: 0x23, RAM: [][]int{{0x10, 0x04}}},
+	  make([][]interface{}, 5) },
+	{ "ISC $10",
+	  singleStepState{PC: 0x200, A: 0x20, P: 0x21,
+			  RAM: [][]int{{0x200, 0xe7}, {0x201, 0x10}, {0x10, 0x0f}}},
+	  singleStepState{PC: 0x202, A: 0x10, P: 0x21, RAM: [][]int{{0x10, 0x10}}},
+	  make([][]interface{}, 5) },
+	{ "LAR $1000,Y",
+	  singleStepState{PC: 0x200, S: 0xfd, P: 0x20,
+			  RAM: [][]int{{0x200, 0xbb}, {0x201, 0x00}, {0x202, 0x10}, {0x1000, 0xf0}}},
+	  singleStepState{PC: 0x203, S: 0xf0, A: 0xf0, X: 0xf0, P: 0xa0},
+	  make([][]interface{}, 4) },
+	{ "LAX $10",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0xa7}, {0x201, 0x10}, {0x10, 0x80}}},
+	  singleStepState{PC: 0x202, A: 0x80, X: 0x80, P: 0xa0},
+	  make([][]interface{}, 3) },
+	{ "RLA $10",
+	  singleStepState{PC: 0x200, A: 0xff, P: 0x21,
+			  RAM: [][]int{{0x200, 0x27}, {0x201, 0x10}, {0x10, 0x80}}},
+	  singleStepState{PC: 0x202, A: 0x01, P: 0x21, RAM: [][]int{{0x10, 0x01}}},
+	  make([][]interface{}, 5) },
+	{ "RRA $10",
+	  singleStepState{PC: 0x200, A: 0x01, P: 0x21,
+			  RAM: [][]int{{0x200, 0x67}, {0x201, 0x10}, {0x10, 0x02}}},
+	  singleStepState{PC: 0x202, A: 0x82, P: 0xa0, RAM: [][]int{{0x10, 0x81}}},
+	  make([][]interface{}, 5) },
+	{ "SLO $10",
+	  singleStepState{PC: 0x200, A: 0x10, P: 0x20,
+			  RAM: [][]int{{0x200, 0x07}, {0x201, 0x10}, {0x10, 0x81}}},
+	  singleStepState{PC: 0x202, A: 0x12, P: 0x21, RAM: [][]int{{0x10, 0x02}}},
+	  make([][]interface{}, 5) },
+	{ "SRE $10",
+	  singleStepState{PC: 0x200, A: 0x11, P: 0x20,
+			  RAM: [][]int{{0x200, 0x47}, {0x201, 0x10}, {0x10, 0x03}}},
+	  singleStepState{PC: 0x202, A: 0x10, P: 0x21, RAM: [][]int{{0x10, 0x01}}},
+	  make([][]interface{}, 5) },
+	{ "SXA $10F0,Y",
+	  singleStepState{PC: 0x200, X: 0xff, Y: 0x05, P: 0x20,
+			  RAM: [][]int{{0x200, 0x9e}, {0x201, 0xf0}, {0x202, 0x10}}},
+	  singleStepState{PC: 0x203, X: 0xff, Y: 0x05, P: 0x20, RAM: [][]int{{0x10f5, 0x11}}},
+	  make([][]interface{}, 5) },
+	{ "SXA $10F0,Y crossing a page",
+	  singleStepState{PC: 0x200, X: 0x0f, Y: 0x20, P: 0x20,
+			  RAM: [][]int{{0x200, 0x9e}, {0x201, 0xf0}, {0x202, 0x10}}},
+	  singleStepState{PC: 0x203, X: 0x0f, Y: 0x20, P: 0x20, RAM: [][]int{{0x0110, 0x01}}},
+	  make([][]interface{}, 5) },
+	{ "SYA $2000,X",
+	  singleStepState{PC: 0x200, X: 0x01, Y: 0x33, P: 0x20,
+			  RAM: [][]int{{0x200, 0x9c}, {0x201, 0x00}, {0x202, 0x20}}},
+	  singleStepState{PC: 0x203, X: 0x01, Y: 0x33, P: 0x20, RAM: [][]int{{0x2001, 0x21}}},
+	  make([][]interface{}, 5) },
+	{ "XAA #$0F",
+	  singleStepState{PC: 0x200, A: 0x11, X: 0xf3, P: 0x20, RAM: [][]int{{0x200, 0x8b}, {0x201, 0x0f}}},
+	  singleStepState{PC: 0x202, A: 0x03, X: 0xf3, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "XAS $2000,Y",
+	  singleStepState{PC: 0x200, S: 0xfd, A: 0xf3, X: 0x3f, Y: 0x01, P: 0x20,
+			  RAM: [][]int{{0x200, 0x9b}, {0x201, 0x00}, {0x202, 0x20}}},
+	  singleStepState{PC: 0x203, S: 0x33, A: 0xf3, X: 0x3f, Y: 0x01, P: 0x20,
+			  RAM: [][]int{{0x2001, 0x21}}},
+	  make([][]interface{}, 5) },
+	{ "BNE taken across a page",
+	  singleStepState{PC: 0x2f0, P: 0x20, RAM: [][]int{{0x2f0, 0xd0}, {0x2f1, 0x20}}},
+	  singleStepState{PC: 0x312, P: 0x20},
+	  make([][]interface{}, 4) },
+	{ "JMP ($03FF) wraps within the page",
+	  singleStepState{PC: 0x200, P: 0x20,
+			  RAM: [][]int{{0x200, 0x6c}, {0x201, 0xff}, {0x202, 0x03}, {0x3ff, 0x34},
+				       {0x300, 0x12}, {0x400, 0x56}}},
+	  singleStepState{PC: 0x1234, P: 0x20},
+	  make([][]interface{}, 5) },
+	{ "ADC #$FF with C set",
+	  singleStepState{PC: 0x200, A: 0x01, P: 0x21, RAM: [][]int{{0x200, 0x69}, {0x201, 0xff}}},
+	  singleStepState{PC: 0x202, A: 0x01, P: 0x21},
+	  make([][]interface{}, 2) },
+	{ "ADC #$80 overflows to 0",
+	  singleStepState{PC: 0x200, A: 0x80, P: 0x20, RAM: [][]int{{0x200, 0x69}, {0x201, 0x80}}},
+	  singleStepState{PC: 0x202, P: 0x63},
+	  make([][]interface{}, 2) },
+	{ "SBC #$01 with C clear borrows",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0xe9}, {0x201, 0x01}}},
+	  singleStepState{PC: 0x202, A: 0xfe, P: 0xa0},
+	  make([][]interface{}, 2) },
+	{ "SBC #$05 to 0",
+	  singleStepState{PC: 0x200, A: 0x05, P: 0x21, RAM: [][]int{{0x200, 0xe9}, {0x201, 0x05}}},
+	  singleStepState{PC: 0x202, P: 0x23},
+	  make([][]interface{}, 2) },
+	{ "SBC #$01 overflows",
+	  singleStepState{PC: 0x200, A: 0x80, P: 0x21, RAM: [][]int{{0x200, 0xe9}, {0x201, 0x01}}},
+	  singleStepState{PC: 0x202, A: 0x7f, P: 0x61},
+	  make([][]interface{}, 2) },
+	{ "CMP #$80 equal",
+	  singleStepState{PC: 0x200, A: 0x80, P: 0xa0, RAM: [][]int{{0x200, 0xc9}, {0x201, 0x80}}},
+	  singleStepState{PC: 0x202, A: 0x80, P: 0x23},
+	  make([][]interface{}, 2) },
+	{ "CMP #$01 greater",
+	  singleStepState{PC: 0x200, A: 0x80, P: 0xa0, RAM: [][]int{{0x200, 0xc9}, {0x201, 0x01}}},
+	  singleStepState{PC: 0x202, A: 0x80, P: 0x21},
+	  make([][]interface{}, 2) },
+	{ "CPX #$FF less",
+	  singleStepState{PC: 0x200, P: 0x23, RAM: [][]int{{0x200, 0xe0}, {0x201, 0xff}}},
+	  singleStepState{PC: 0x202, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "CPY #$01 greater",
+	  singleStepState{PC: 0x200, Y: 0x80, P: 0xa0, RAM: [][]int{{0x200, 0xc0}, {0x201, 0x01}}},
+	  singleStepState{PC: 0x202, Y: 0x80, P: 0x21},
+	  make([][]interface{}, 2) },
+	{ "BIT $10 clears Z, N and V",
+	  singleStepState{PC: 0x200, A: 0xff, P: 0xe2,
+			  RAM: [][]int{{0x200, 0x24}, {0x201, 0x10}, {0x10, 0x3f}}},
+	  singleStepState{PC: 0x202, A: 0xff, P: 0x20},
+	  make([][]interface{}, 3) },
+	{ "ASL A",
+	  singleStepState{PC: 0x200, A: 0x80, P: 0x20, RAM: [][]int{{0x200, 0x0a}}},
+	  singleStepState{PC: 0x201, P: 0x23},
+	  make([][]interface{}, 2) },
+	{ "LSR A",
+	  singleStepState{PC: 0x200, A: 0x81, P: 0xa0, RAM: [][]int{{0x200, 0x4a}}},
+	  singleStepState{PC: 0x201, A: 0x40, P: 0x21},
+	  make([][]interface{}, 2) },
+	{ "ROL A carries out",
+	  singleStepState{PC: 0x200, A: 0x80, P: 0x20, RAM: [][]int{{0x200, 0x2a}}},
+	  singleStepState{PC: 0x201, P: 0x23},
+	  make([][]interface{}, 2) },
+	{ "ROL A carries in",
+	  singleStepState{PC: 0x200, A: 0x40, P: 0x21, RAM: [][]int{{0x200, 0x2a}}},
+	  singleStepState{PC: 0x201, A: 0x81, P: 0xa0},
+	  make([][]interface{}, 2) },
+	{ "ROR A carries out",
+	  singleStepState{PC: 0x200, A: 0x01, P: 0x20, RAM: [][]int{{0x200, 0x6a}}},
+	  singleStepState{PC: 0x201, P: 0x23},
+	  make([][]interface{}, 2) },
+	{ "ROR A carries in",
+	  singleStepState{PC: 0x200, P: 0x21, RAM: [][]int{{0x200, 0x6a}}},
+	  singleStepState{PC: 0x201, A: 0x80, P: 0xa0},
+	  make([][]interface{}, 2) },
+	{ "ROR $10 carries out",
+	  singleStepState{PC: 0x200, P: 0x20,
+			  RAM: [][]int{{0x200, 0x66}, {0x201, 0x10}, {0x10, 0x01}}},
+	  singleStepState{PC: 0x202, P: 0x23, RAM: [][]int{{0x10, 0x00}}},
+	  make([][]interface{}, 5) },
+	{ "CLC",
+	  singleStepState{PC: 0x200, P: 0x21, RAM: [][]int{{0x200, 0x18}}},
+	  singleStepState{PC: 0x201, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "SEC",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0x38}}},
+	  singleStepState{PC: 0x201, P: 0x21},
+	  make([][]interface{}, 2) },
+	{ "CLD",
+	  singleStepState{PC: 0x200, P: 0x28, RAM: [][]int{{0x200, 0xd8}}},
+	  singleStepState{PC: 0x201, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "SED",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0xf8}}},
+	  singleStepState{PC: 0x201, P: 0x28},
+	  make([][]interface{}, 2) },
+	{ "CLI",
+	  singleStepState{PC: 0x200, P: 0x24, RAM: [][]int{{0x200, 0x58}}},
+	  singleStepState{PC: 0x201, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "SEI",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0x78}}},
+	  singleStepState{PC: 0x201, P: 0x24},
+	  make([][]interface{}, 2) },
+	{ "CLV",
+	  singleStepState{PC: 0x200, P: 0x60, RAM: [][]int{{0x200, 0xb8}}},
+	  singleStepState{PC: 0x201, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "NOP",
+	  singleStepState{PC: 0x200, A: 0x12, P: 0xe3, RAM: [][]int{{0x200, 0xea}}},
+	  singleStepState{PC: 0x201, A: 0x12, P: 0xe3},
+	  make([][]interface{}, 2) },
+	{ "TAX",
+	  singleStepState{PC: 0x200, A: 0x80, P: 0x22, RAM: [][]int{{0x200, 0xaa}}},
+	  singleStepState{PC: 0x201, A: 0x80, X: 0x80, P: 0xa0},
+	  make([][]interface{}, 2) },
+	{ "TAY",
+	  singleStepState{PC: 0x200, Y: 0x12, P: 0xa0, RAM: [][]int{{0x200, 0xa8}}},
+	  singleStepState{PC: 0x201, P: 0x22},
+	  make([][]interface{}, 2) },
+	{ "TXA",
+	  singleStepState{PC: 0x200, A: 0x80, X: 0x7f, P: 0xa0, RAM: [][]int{{0x200, 0x8a}}},
+	  singleStepState{PC: 0x201, A: 0x7f, X: 0x7f, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "TYA",
+	  singleStepState{PC: 0x200, Y: 0xff, P: 0x22, RAM: [][]int{{0x200, 0x98}}},
+	  singleStepState{PC: 0x201, A: 0xff, Y: 0xff, P: 0xa0},
+	  make([][]interface{}, 2) },
+	{ "TSX",
+	  singleStepState{PC: 0x200, S: 0x80, P: 0x20, RAM: [][]int{{0x200, 0xba}}},
+	  singleStepState{PC: 0x201, S: 0x80, X: 0x80, P: 0xa0},
+	  make([][]interface{}, 2) },
+	{ "TXS leaves the flags alone",
+	  singleStepState{PC: 0x200, S: 0xfd, P: 0x20, RAM: [][]int{{0x200, 0x9a}}},
+	  singleStepState{PC: 0x201, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "INX wraps",
+	  singleStepState{PC: 0x200, X: 0xff, P: 0xa0, RAM: [][]int{{0x200, 0xe8}}},
+	  singleStepState{PC: 0x201, P: 0x22},
+	  make([][]interface{}, 2) },
+	{ "INY",
+	  singleStepState{PC: 0x200, Y: 0x7f, P: 0x20, RAM: [][]int{{0x200, 0xc8}}},
+	  singleStepState{PC: 0x201, Y: 0x80, P: 0xa0},
+	  make([][]interface{}, 2) },
+	{ "DEX",
+	  singleStepState{PC: 0x200, X: 0x01, P: 0x20, RAM: [][]int{{0x200, 0xca}}},
+	  singleStepState{PC: 0x201, P: 0x22},
+	  make([][]interface{}, 2) },
+	{ "DEY wraps",
+	  singleStepState{PC: 0x200, P: 0x22, RAM: [][]int{{0x200, 0x88}}},
+	  singleStepState{PC: 0x201, Y: 0xff, P: 0xa0},
+	  make([][]interface{}, 2) },
+	{ "PHA",
+	  singleStepState{PC: 0x200, S: 0xfd, A: 0x42, P: 0x20, RAM: [][]int{{0x200, 0x48}}},
+	  singleStepState{PC: 0x201, S: 0xfc, A: 0x42, P: 0x20, RAM: [][]int{{0x1fd, 0x42}}},
+	  make([][]interface{}, 3) },
+	{ "PLA",
+	  singleStepState{PC: 0x200, S: 0xfc, P: 0x22, RAM: [][]int{{0x200, 0x68}, {0x1fd, 0x80}}},
+	  singleStepState{PC: 0x201, S: 0xfd, A: 0x80, P: 0xa0},
+	  make([][]interface{}, 4) },
+	{ "PHP",
+	  singleStepState{PC: 0x200, S: 0xfd, P: 0xc3, RAM: [][]int{{0x200, 0x08}}},
+	  singleStepState{PC: 0x201, S: 0xfc, P: 0xc3, RAM: [][]int{{0x1fd, 0xf3}}},
+	  make([][]interface{}, 3) },
+	{ "PLP",
+	  singleStepState{PC: 0x200, S: 0xfc, P: 0x20, RAM: [][]int{{0x200, 0x28}, {0x1fd, 0xdf}}},
+	  singleStepState{PC: 0x201, S: 0xfd, P: 0xef},
+	  make([][]interface{}, 4) },
+	{ "JSR $1234",
+	  singleStepState{PC: 0x200, S: 0xfd, P: 0x20,
+			  RAM: [][]int{{0x200, 0x20}, {0x201, 0x34}, {0x202, 0x12}}},
+	  singleStepState{PC: 0x1234, S: 0xfb, P: 0x20, RAM: [][]int{{0x1fc, 0x02}, {0x1fd, 0x02}}},
+	  make([][]interface{}, 6) },
+	{ "RTS",
+	  singleStepState{PC: 0x300, S: 0xfb, P: 0x20,
+			  RAM: [][]int{{0x300, 0x60}, {0x1fc, 0x02}, {0x1fd, 0x02}}},
+	  singleStepState{PC: 0x203, S: 0xfd, P: 0x20},
+	  make([][]interface{}, 6) },
+	{ "RTI",
+	  singleStepState{PC: 0x300, S: 0xfa, P: 0x24,
+			  RAM: [][]int{{0x300, 0x40}, {0x1fb, 0xd3}, {0x1fc, 0x34}, {0x1fd, 0x12}}},
+	  singleStepState{PC: 0x1234, S: 0xfd, P: 0xe3},
+	  make([][]interface{}, 6) },
+	{ "BRK",
+	  singleStepState{PC: 0x200, S: 0xfd, P: 0x20,
+			  RAM: [][]int{{0x200, 0x00}, {0xfffe, 0x00}, {0xffff, 0x80}}},
+	  singleStepState{PC: 0x8000, S: 0xfa, P: 0x24,
+			  RAM: [][]int{{0x1fb, 0x30}, {0x1fc, 0x02}, {0x1fd, 0x02}}},
+	  make([][]interface{}, 7) },
+	{ "JMP $1234",
+	  singleStepState{PC: 0x200, P: 0x20,
+			  RAM: [][]int{{0x200, 0x4c}, {0x201, 0x34}, {0x202, 0x12}}},
+	  singleStepState{PC: 0x1234, P: 0x20},
+	  make([][]interface{}, 3) },
+	{ "JMP ($0300)",
+	  singleStepState{PC: 0x200, P: 0x20,
+			  RAM: [][]int{{0x200, 0x6c}, {0x201, 0x00}, {0x202, 0x03}, {0x300, 0x34}, {0x301, 0x12}}},
+	  singleStepState{PC: 0x1234, P: 0x20},
+	  make([][]interface{}, 5) },
+	{ "BCC taken",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0x90}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x212, P: 0x20},
+	  make([][]interface{}, 3) },
+	{ "BCC not taken",
+	  singleStepState{PC: 0x200, P: 0x21, RAM: [][]int{{0x200, 0x90}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x202, P: 0x21},
+	  make([][]interface{}, 2) },
+	{ "BCS taken",
+	  singleStepState{PC: 0x200, P: 0x21, RAM: [][]int{{0x200, 0xb0}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x212, P: 0x21},
+	  make([][]interface{}, 3) },
+	{ "BCS not taken",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0xb0}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x202, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "BEQ taken",
+	  singleStepState{PC: 0x200, P: 0x22, RAM: [][]int{{0x200, 0xf0}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x212, P: 0x22},
+	  make([][]interface{}, 3) },
+	{ "BEQ not taken",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0xf0}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x202, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "BMI taken",
+	  singleStepState{PC: 0x200, P: 0xa0, RAM: [][]int{{0x200, 0x30}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x212, P: 0xa0},
+	  make([][]interface{}, 3) },
+	{ "BMI not taken",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0x30}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x202, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "BNE taken",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0xd0}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x212, P: 0x20},
+	  make([][]interface{}, 3) },
+	{ "BNE not taken",
+	  singleStepState{PC: 0x200, P: 0x22, RAM: [][]int{{0x200, 0xd0}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x202, P: 0x22},
+	  make([][]interface{}, 2) },
+	{ "BPL taken",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0x10}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x212, P: 0x20},
+	  make([][]interface{}, 3) },
+	{ "BPL not taken",
+	  singleStepState{PC: 0x200, P: 0xa0, RAM: [][]int{{0x200, 0x10}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x202, P: 0xa0},
+	  make([][]interface{}, 2) },
+	{ "BVC taken",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0x50}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x212, P: 0x20},
+	  make([][]interface{}, 3) },
+	{ "BVC not taken",
+	  singleStepState{PC: 0x200, P: 0x60, RAM: [][]int{{0x200, 0x50}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x202, P: 0x60},
+	  make([][]interface{}, 2) },
+	{ "BVS taken",
+	  singleStepState{PC: 0x200, P: 0x60, RAM: [][]int{{0x200, 0x70}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x212, P: 0x60},
+	  make([][]interface{}, 3) },
+	{ "BVS not taken",
+	  singleStepState{PC: 0x200, P: 0x20, RAM: [][]int{{0x200, 0x70}, {0x201, 0x10}}},
+	  singleStepState{PC: 0x202, P: 0x20},
+	  make([][]interface{}, 2) },
+	{ "BCS taken backwards",
+	  singleStepState{PC: 0x200, P: 0x21, RAM: [][]int{{0x200, 0xb0}, {0x201, 0xfe}}},
+	  singleStepState{PC: 0x200, P: 0x21},
+	  make([][]interface{}, 3) },
+}
+
+// What a documented instruction with a memory operand does, whatever its addressing mode.
+// 'operand' is at the effective address, or follows the opcode for immediate addressing, and
+// 'result' is what the effective address holds afterwards.  X and Y are kept small so indexing
+// doesn't cross a page.
+type operandTest struct {
+	initial singleStepState
+	final singleStepState
+	operand uint8
+	result uint8
+}
+
+var operandTests = map[string]operandTest {
+	"ADC": {singleStepState{A: 0x50, X: 0x03, Y: 0x05, P: 0x20},
+		singleStepState{A: 0xa0, X: 0x03, Y: 0x05, P: 0xe0}, 0x50, 0x50},
+	"AND": {singleStepState{A: 0xf0, X: 0x03, Y: 0x05, P: 0x22},
+		singleStepState{A: 0x80, X: 0x03, Y: 0x05, P: 0xa0}, 0x8f, 0x8f},
+	"ASL": {singleStepState{X: 0x03, Y: 0x05, P: 0x20},
+		singleStepState{X: 0x03, Y: 0x05, P: 0x21}, 0x81, 0x02},
+	"BIT": {singleStepState{A: 0x01, X: 0x03, Y: 0x05, P: 0x20},
+		singleStepState{A: 0x01, X: 0x03, Y: 0x05, P: 0xe2}, 0xc0, 0xc0},
+	"CMP": {singleStepState{A: 0x40, X: 0x03, Y: 0x05, P: 0x21},
+		singleStepState{A: 0x40, X: 0x03, Y: 0x05, P: 0xa0}, 0x41, 0x41},
+	"CPX": {singleStepState{X: 0x41, Y: 0x05, P: 0x20},
+		singleStepState{X: 0x41, Y: 0x05, P: 0x21}, 0x40, 0x40},
+	"CPY": {singleStepState{X: 0x03, Y: 0x40, P: 0x20},
+		singleStepState{X: 0x03, Y: 0x40, P: 0x23}, 0x40, 0x40},
+	"DEC": {singleStepState{X: 0x03, Y: 0x05, P: 0x21},
+		singleStepState{X: 0x03, Y: 0x05, P: 0xa1}, 0x00, 0xff},
+	"EOR": {singleStepState{A: 0xff, X: 0x03, Y: 0x05, P: 0x20},
+		singleStepState{A: 0xf0, X: 0x03, Y: 0x05, P: 0xa0}, 0x0f, 0x0f},
+	"INC": {singleStepState{X: 0x03, Y: 0x05, P: 0xa0},
+		singleStepState{X: 0x03, Y: 0x05, P: 0x22}, 0xff, 0x00},
+	"LDA": {singleStepState{X: 0x03, Y: 0x05, P: 0x22},
+		singleStepState{A: 0x80, X: 0x03, Y: 0x05, P: 0xa0}, 0x80, 0x80},
+	"LDX": {singleStepState{X: 0x55, Y: 0x05, P: 0xa0},
+		singleStepState{Y: 0x05, P: 0x22}, 0x00, 0x00},
+	"LDY": {singleStepState{X: 0x03, P: 0xa2},
+		singleStepState{X: 0x03, Y: 0x7f, P: 0x20}, 0x7f, 0x7f},
+	"LSR": {singleStepState{X: 0x03, Y: 0x05, P: 0xa0},
+		singleStepState{X: 0x03, Y: 0x05, P: 0x23}, 0x01, 0x00},
+	"ORA": {singleStepState{A: 0x01, X: 0x03, Y: 0x05, P: 0x22},
+		singleStepState{A: 0x81, X: 0x03, Y: 0x05, P: 0xa0}, 0x80, 0x80},
+	"ROL": {singleStepState{X: 0x03, Y: 0x05, P: 0x21},
+		singleStepState{X: 0x03, Y: 0x05, P: 0x21}, 0x80, 0x01},
+	"ROR": {singleStepState{X: 0x03, Y: 0x05, P: 0x21},
+		singleStepState{X: 0x03, Y: 0x05, P: 0xa1}, 0x01, 0x80},
+	"SBC": {singleStepState{A: 0x50, X: 0x03, Y: 0x05, P: 0x21},
+		singleStepState{A: 0xa0, X: 0x03, Y: 0x05, P: 0xe0}, 0xb0, 0xb0},
+	"STA": {singleStepState{A: 0x42, X: 0x03, Y: 0x05, P: 0xa2},
+		singleStepState{A: 0x42, X: 0x03, Y: 0x05, P: 0xa2}, 0x00, 0x42},
+	"STX": {singleStepState{X: 0x43, Y: 0x05, P: 0x20},
+		singleStepState{X: 0x43, Y: 0x05, P: 0x20}, 0x00, 0x43},
+	"STY": {singleStepState{X: 0x03, Y: 0x44, P: 0x20},
+		singleStepState{X: 0x03, Y: 0x44, P: 0x20}, 0x00, 0x44},
+}
+
+// Where operand tests put the operand, and the pointer for indirect addressing.
+const (
+	operandZeroPage = 0x45
+	operandAbsolute = 0x0345
+	operandPointer = 0x20
+)
+
+// Build the test of 'opcode', whose op table entry is 'op', from 'ot'.  The program is at 0x200.
+func makeOperandTest(opcode uint8, op *OpcodeEntry, ot operandTest) singleStepTest {
+	test := singleStepTest{Name: fmt.Sprintf("%02X %s", opcode, op.name), Initial: ot.initial,
+			       Final: ot.final, Cycles: make([][]interface{}, op.cycles)}
+	test.Initial.PC = 0x200
+	test.Final.PC = uint16(0x200 + InstructionSize(op.addressing))
+	x, y := int(ot.initial.X), int(ot.initial.Y)
+
+	ram := [][]int{{0x200, int(opcode)}}
+	addr := operandAbsolute
+	absolute := func(base int) {
+		ram = append(ram, []int{0x201, base & 0xff}, []int{0x202, base >> 8})
+	}
+	switch op.addressing {
+	case IMM:
+		test.Initial.RAM = append(ram, []int{0x201, int(ot.operand)})
+		return test
+	case ZP:
+		addr = operandZeroPage
+		ram = append(ram, []int{0x201, addr})
+	case ZPX:
+		addr = operandZeroPage
+		ram = append(ram, []int{0x201, addr - x})
+	case ZPY:
+		addr = operandZeroPage
+		ram = append(ram, []int{0x201, addr - y})
+	case ABS:
+		absolute(addr)
+	case ABSX:
+		absolute(addr - x)
+	case ABSY:
+		absolute(addr - y)
+	case INDX:
+		ram = append(ram, []int{0x201, operandPointer}, []int{operandPointer + x, addr & 0xff},
+			     []int{operandPointer + x + 1, addr >> 8})
+	case INDY:
+		ram = append(ram, []int{0x201, operandPointer}, []int{operandPointer, (addr - y) & 0xff},
+			     []int{operandPointer + 1, (addr - y) >> 8})
+	}
+	test.Initial.RAM = append(ram, []int{addr, int(ot.operand)})
+	test.Final.RAM = [][]int{{addr, int(ot.result)}}
+	return test
+}
+
+// Run the operand tests for every documented opcode in each of its addressing modes.
+func TestAddressingModes(t *testing.T) {
+	for opcode := 0; opcode < 0x100; opcode++ {
+		op := opTable[uint8(opcode)]
+		if nil == op || isUndocumented(uint8(opcode), op) || isAccumulatorOp(uint8(opcode)) {
+			continue
+		}
+		ot, ok := operandTests[op.name]
+		if !ok {
+			continue
+		}
+
+		test := makeOperandTest(uint8(opcode), op, ot)
+		if problem := runSingleStep(&test); "" != problem {
+			t.Errorf("%s: %s", test.Name, problem)
+		}
+	}
+}
+
+// Every documented opcode has its behavior checked, by TestAddressingModes or a hand-written test.
+func TestDocumentedOpcodesTested(t *testing.T) {
+	tested := make(map[uint8]bool)
+	for _, test := range opcodeTests {
+		for _, pair := range test.Initial.RAM {
+			if int(test.Initial.PC) == pair[0] {
+				tested[uint8(pair[1])] = true
+			}
+		}
+	}
+
+	for opcode := 0; opcode < 0x100; opcode++ {
+		op := opTable[uint8(opcode)]
+		if nil == op || isUndocumented(uint8(opcode), op) || tested[uint8(opcode)] {
+			continue
+		}
+		if _, ok := operandTests[op.name]; ok && !isAccumulatorOp(uint8(opcode)) {
+			continue
+		}
+		t.Errorf("%02X %s isn't tested", opcode, op.name)
+	}
+}
+
+// B and bit 5 only exist on the stack.  PLP and RTI ignore them in what they pull.
+func TestStatusFromStack(t *testing.T) {
+	for _, opcode := range []uint8{0x28, 0x40} { // PLP, RTI
+		for _, pulled := range []uint8{0x00, 0xff} {
+			mem := NewMemoryForTesting()
+			mem.Write(0x200, opcode)
+			mem.Write(0x1fe, pulled)
+			mycpu := NewCPU(mem)
+			mycpu.pc = 0x200
+			mycpu.sp = 0xfd
+
+			mycpu.Interpret()
+			if expected := (pulled | ALWAYS_ON) & ^B; expected != mycpu.st {
+				t.Errorf("%02X pulling %02X: expected P to be %02X, got %02X", opcode, pulled,
+					 expected, mycpu.st)
+			}
+		}
+	}
+}
+
+// Every cycle of an instruction reads or writes memory.  Without a page crossing or a branch,
+// every opcode takes as many cycles as the op table says.
+func TestOpcodeCycles(t *testing.T) {
+	for i := 0; i < 0x100; i++ {
+		opcode := uint8(i)
+		op := opTable[opcode]
+		if nil == op {
+			t.Errorf("%02X isn't in the op table", opcode)
+			continue
+		}
+		if isBranch(opcode) {
+			continue
+		}
+
+		mem := &recordingMemory{MemoryForTesting: NewMemoryForTesting()}
+		mycpu := NewCPU(mem)
+		mem.accesses = nil
+		mycpu.pc = 0x200
+		mycpu.sp = 0xfd
+		mem.MemoryForTesting.Write(0x200, opcode)
+
+		if cycles := mycpu.Interpret(); op.cycles != cycles || len(mem.accesses) != int(cycles) {
+			t.Errorf("%02X %s: expected %d cycles, took %d and accessed memory %d times",
+				 opcode, op.name, op.cycles, cycles, len(mem.accesses))
+		}
+	}
+}
+
+// A read-modify-write instruction writes the value back unchanged before writing the result.
+// Mappers and PPU registers see both.
+func TestReadModifyWrite(t *testing.T) {
+	test := singleStepTest{"INC $2000,X",
+		singleStepState{PC: 0x200, X: 0x07, P: 0x20,
+				RAM: [][]int{{0x200, 0xfe}, {0x201, 0x00}, {0x202, 0x20}, {0x2007, 0x41}}},
+		singleStepState{PC: 0x203, X: 0x07, P: 0x20, RAM: [][]int{{0x2007, 0x42}}},
+		[][]interface{}{
+			{float64(0x200), float64(0xfe), "read"},
+			{float64(0x201), float64(0x00), "read"},
+			{float64(0x202), float64(0x20), "read"},
+			// The high byte might need fixing, so the unfixed address is read.
+			{float64(0x2007), float64(0x41), "read"},
+			{float64(0x2007), float64(0x41), "read"},
+			{float64(0x2007), float64(0x41), "write"},
+			{float64(0x2007), float64(0x42), "write"},
+		}}
+	if problem := runSingleStep(&test); "" != problem {
+		t.Error(problem)
+	}
+}
+
+// KIL stops the CPU until it's reset.
+func TestKil(t *testing.T) {
+	mem := NewMemoryForTesting()
+	mem.Write(0xfffc, 0x00)
+	mem.Write(0xfffd, 0x02)
+	mem.Write(0x200, 0x02)
+	mycpu := NewCPU(mem)
+	mycpu.AssertIRQ(IRQ_MAPPER)
+	mycpu.st = 0
+
+	mycpu.Interpret()
+	if !mycpu.Jammed() || 0x200 != mycpu.pc {
+		t.Fatalf("Expected to be jammed at 0200, jammed %v at %04X", mycpu.Jammed(), mycpu.pc)
+	}
+
+	// Neither interrupts nor time get it going again.
+	if cycles := mycpu.NMI(); 0 != cycles {
+		t.Error("NMI took", cycles, "cycles")
+	}
+	for i := 0; i < 10; i++ {
+		if cycles := mycpu.Interpret(); 1 != cycles {
+			t.Error("Jammed CPU took", cycles, "cycles")
+		}
+	}
+	if !mycpu.Jammed() || 0x200 != mycpu.pc {
+		t.Fatalf("Expected to still be jammed at 0200, jammed %v at %04X", mycpu.Jammed(),
+			 mycpu.pc)
+	}
+
+	mycpu.Reset()
+	if mycpu.Jammed() || !mycpu.isSet(I) {
+		t.Error("Expected reset to unjam the CPU and set I")
+	}
+}
+
+func TestOpcodes(t *testing.T) {
+	for i := range opcodeTests {
+		if problem := runSingleStep(&opcodeTests[i]); "" != problem {
+			t.Errorf("%s: %s", opcodeTests[i].Name, problem)
+		}
+	}
+}
+
+// Run the single step tests for every opcode in the op table.
+func TestSingleStep(t *testing.T) {
+	dir := os.Getenv("NES6502_TESTS")
+	if "" == dir {
+		dir = defaultSingleStepDir
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a9.json")); nil != err {
+		t.Skip("No single step tests found in", dir)
+	}
+
+	for opcode := 0; opcode < 0x100; opcode++ {
+		op := opTable[uint8(opcode)]
+		if nil == op {
+			continue
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("%02x.json", opcode))
+		t.Run(fmt.Sprintf("%02X_%s", opcode, op.name), func(t *testing.T) {
+			file, err := os.Open(path)
+			if nil != err {
+				t.Skip(err)
+			}
+			defer file.Close()
+
+			var tests []singleStepTest
+			if err := json.NewDecoder(file).Decode(&tests); nil != err {
+				t.Fatal(err)
+			}
+			if testing.Short() && len(tests) > singleStepShortCount {
+				tests = tests[:singleStepShortCount]
+			}
+
+			// One failure is usually enough to see what's wrong with an opcode.
+			for i := range tests {
+				if problem := runSingleStep(&tests[i]); "" != problem {
+					t.Fatalf("%s: %s", tests[i].Name, problem)
+				}
+			}
+		})
+	}
+}
@@ -20,6 +20,78 @@ type OpcodeEntry struct {
 	addressing int
 }
 
+// What tools like the disassembler need to know about an opcode.
+type OpcodeInfo struct {
+	Name string
+
+	// One of the addressing modes in cpu_addressing.go.  Branches are REL and shifts of the
+	// accumulator are ACC, unlike in the op table.
+	Addressing int
+
+	// The base number of cycles, not counting page crossings and taken branches.
+	Cycles uint64
+
+	// Undocumented opcodes aren't part of the official instruction set.
+	Undocumented bool
+}
+
+// Look up 'opcode'.  Returns false if it isn't implemented.
+func LookupOpcode(opcode uint8) (info OpcodeInfo, ok bool) {
+	op := opTable[opcode]
+	if nil == op {
+		return info, false
+	}
+
+	info = OpcodeInfo{op.name, op.addressing, op.cycles, isUndocumented(opcode, op)}
+	if isBranch(opcode) {
+		info.Addressing = REL
+	} else if isAccumulatorOp(opcode) {
+		info.Addressing = ACC
+	}
+	return info, true
+}
+
+// How many bytes an instruction using 'addressing' takes, including the opcode.
+func InstructionSize(addressing int) int {
+	switch(addressing) {
+	case IMM, ZP, ZPX, ZPY, INDX, INDY, REL:
+		return 2
+	case ABS, ABSX, ABSY, IND:
+		return 3
+	}
+	return 1
+}
+
+// Opcodes that aren't part of the documented 6502 instruction set.
+var undocumentedOpcodes = map[uint8]bool {
+	0x1A: true, 0x3A: true, 0x5A: true, 0x7A: true, 0xDA: true, 0xFA: true,  // NOP
+	0xEB: true,  // SBC
+}
+
+// Names of instructions that are undocumented with every opcode.
+var undocumentedNames = map[string]bool {
+	"AAC": true, "AAX": true, "ARR": true, "ASR": true, "ATX": true, "AXA": true, "AXS": true,
+	"DCP": true, "DOP": true, "ISC": true, "KIL": true, "LAR": true, "LAX": true, "RLA": true,
+	"RRA": true, "SLO": true, "SRE": true, "SXA": true, "SYA": true, "TOP": true, "XAA": true,
+	"XAS": true,
+}
+
+// Is 'opcode' (fully described in 'op') undocumented?
+func isUndocumented(opcode uint8, op *OpcodeEntry) bool {
+	return undocumentedOpcodes[opcode] || undocumentedNames[op.name]
+}
+
+// Is 'opcode' one of the branches?  They use immediate addressing in the op table, but their
+// operand is relative to the next instruction.
+func isBranch(opcode uint8) bool {
+	return 0x10 == (opcode & 0x1f)
+}
+
+// Does 'opcode' shift or rotate the accumulator?
+func isAccumulatorOp(opcode uint8) bool {
+	return 0x0A == opcode || 0x2A == opcode || 0x4A == opcode || 0x6A == opcode
+}
+
 var opTable = map[uint8]*OpcodeEntry {
 	// AAC is undocumented.
 	0x0B: { "AAC", (*CPU).opAac, 2, 0, IMM },
@@ -61,6 +133,10 @@ var opTable = map[uint8]*OpcodeEntry {
 
 	0xAB: { "ATX", (*CPU).opAtx, 2, 0, IMM },  // undocumented
 
+	// AXA is undocumented.
+	0x9F: { "AXA", (*CPU).opAxa, 5, 0, ABSY },
+	0x93: { "AXA", (*CPU).opAxa, 6, 0, INDY },
+
 	0xCB: { "AXS", (*CPU).opAxs, 2, 0, IMM },  // undocumented
 
 	0x90: { "BCC", (*CPU).opBcc, 2, 0, IMM },
@@ -120,20 +196,20 @@ var opTable = map[uint8]*OpcodeEntry {
 	0x88: { "DEY", (*CPU).opDey, 2, 0, IMP },
 
 	// All DOP ops are undocumented.
-	0x04: { "DOP", (*CPU).opNop, 3, 0, ZP },
-	0x14: { "DOP", (*CPU).opNop, 4, 0, ZPX },
-	0x34: { "DOP", (*CPU).opNop, 4, 0, ZPX },
-	0x44: { "DOP", (*CPU).opNop, 3, 0, ZP },
-	0x54: { "DOP", (*CPU).opNop, 4, 0, ZPX },
-	0x64: { "DOP", (*CPU).opNop, 3, 0, ZP },
-	0x74: { "DOP", (*CPU).opNop, 4, 0, ZPX },
-	0x80: { "DOP", (*CPU).opNop, 2, 0, IMM },
-	0x82: { "DOP", (*CPU).opNop, 2, 0, IMM },
-	0x89: { "DOP", (*CPU).opNop, 2, 0, IMM },
-	0xC2: { "DOP", (*CPU).opNop, 2, 0, IMM },
-	0xD4: { "DOP", (*CPU).opNop, 4, 0, ZPX },
-	0xE2: { "DOP", (*CPU).opNop, 2, 0, IMM },
-	0xF4: { "DOP", (*CPU).opNop, 4, 0, ZPX },
+	0x04: { "DOP", (*CPU).opDop, 3, 0, ZP },
+	0x14: { "DOP", (*CPU).opDop, 4, 0, ZPX },
+	0x34: { "DOP", (*CPU).opDop, 4, 0, ZPX },
+	0x44: { "DOP", (*CPU).opDop, 3, 0, ZP },
+	0x54: { "DOP", (*CPU).opDop, 4, 0, ZPX },
+	0x64: { "DOP", (*CPU).opDop, 3, 0, ZP },
+	0x74: { "DOP", (*CPU).opDop, 4, 0, ZPX },
+	0x80: { "DOP", (*CPU).opDop, 2, 0, IMM },
+	0x82: { "DOP", (*CPU).opDop, 2, 0, IMM },
+	0x89: { "DOP", (*CPU).opDop, 2, 0, IMM },
+	0xC2: { "DOP", (*CPU).opDop, 2, 0, IMM },
+	0xD4: { "DOP", (*CPU).opDop, 4, 0, ZPX },
+	0xE2: { "DOP", (*CPU).opDop, 2, 0, IMM },
+	0xF4: { "DOP", (*CPU).opDop, 4, 0, ZPX },
 
 	0x49: { "EOR", (*CPU).opEor, 2, 0, IMM },
 	0x45: { "EOR", (*CPU).opEor, 3, 0, ZP },
@@ -163,6 +239,23 @@ var opTable = map[uint8]*OpcodeEntry {
 
 	0x4C: { "JMP", (*CPU).opJmp, 3, 0, ABS },
 	0x6C: { "JMP", (*CPU).opJmp, 5, 0, IND },
+
+	// All KIL opcodes are undocumented.  They jam the CPU, so the cycles are only those before it
+	// stops.
+	0x02: { "KIL", (*CPU).opKil, 2, 0, IMP },
+	0x12: { "KIL", (*CPU).opKil, 2, 0, IMP },
+	0x22: { "KIL", (*CPU).opKil, 2, 0, IMP },
+	0x32: { "KIL", (*CPU).opKil, 2, 0, IMP },
+	0x42: { "KIL", (*CPU).opKil, 2, 0, IMP },
+	0x52: { "KIL", (*CPU).opKil, 2, 0, IMP },
+	0x62: { "KIL", (*CPU).opKil, 2, 0, IMP },
+	0x72: { "KIL", (*CPU).opKil, 2, 0, IMP },
+	0x92: { "KIL", (*CPU).opKil, 2, 0, IMP },
+	0xB2: { "KIL", (*CPU).opKil, 2, 0, IMP },
+	0xD2: { "KIL", (*CPU).opKil, 2, 0, IMP },
+	0xF2: { "KIL", (*CPU).opKil, 2, 0, IMP },
+
+	0xBB: { "LAR", (*CPU).opLar, 4, 1, ABSY },  // undocumented
 	0x20: { "JSR", (*CPU).opJsr, 6, 0, ABS },
 
 	// All LAX opcodes are undefined.
@@ -310,16 +403,19 @@ var opTable = map[uint8]*OpcodeEntry {
 	0xA8: { "TAY", (*CPU).opTay, 2, 0, IMP },
 
 	// All TOP opcodes are undocumented.
-	0x0C: { "TOP", (*CPU).opNop, 4, 0, ABS },
-	0x1C: { "TOP", (*CPU).opNop, 4, 1, ABSX },
-	0x3C: { "TOP", (*CPU).opNop, 4, 1, ABSX },
-	0x5C: { "TOP", (*CPU).opNop, 4, 1, ABSX },
-	0x7C: { "TOP", (*CPU).opNop, 4, 1, ABSX },
-	0xDC: { "TOP", (*CPU).opNop, 4, 1, ABSX },
-	0xFC: { "TOP", (*CPU).opNop, 4, 1, ABSX },
+	0x0C: { "TOP", (*CPU).opTop, 4, 0, ABS },
+	0x1C: { "TOP", (*CPU).opTop, 4, 1, ABSX },
+	0x3C: { "TOP", (*CPU).opTop, 4, 1, ABSX },
+	0x5C: { "TOP", (*CPU).opTop, 4, 1, ABSX },
+	0x7C: { "TOP", (*CPU).opTop, 4, 1, ABSX },
+	0xDC: { "TOP", (*CPU).opTop, 4, 1, ABSX },
+	0xFC: { "TOP", (*CPU).opTop, 4, 1, ABSX },
 
 	0xBA: { "TSX", (*CPU).opTsx, 2, 0, IMP },
 	0x8A: { "TXA", (*CPU).opTxa, 2, 0, IMP },
 	0x9A: { "TXS", (*CPU).opTxs, 2, 0, IMP },
 	0x98: { "TYA", (*CPU).opTya, 2, 0, IMP },
+
+	0x8B: { "XAA", (*CPU).opXaa, 2, 0, IMM },  // undocumented
+	0x9B: { "XAS", (*CPU).opXas, 5, 0, ABSY },  // undocumented
 }
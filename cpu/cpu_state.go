@@ -0,0 +1,31 @@
+package cpu
+
+import "savestate"
+
+// Save the CPU's registers and interrupt state.
+func (cpu *CPU) Save(w *savestate.Writer) {
+	cpu.state(w)
+}
+
+// Load what Save saved.
+func (cpu *CPU) Load(r *savestate.Reader) {
+	cpu.state(r)
+}
+
+// Save or load everything that persists between instructions.  The opcode address fields and
+// clockCycles only matter while an instruction is executing.
+func (cpu *CPU) state(s savestate.Stream) {
+	s.Uint8(&cpu.ac)
+	s.Uint8(&cpu.xr)
+	s.Uint8(&cpu.yr)
+	s.Uint8(&cpu.st)
+	s.Uint8(&cpu.sp)
+	s.Uint16(&cpu.pc)
+
+	irqLine := uint8(cpu.irqLine)
+	s.Uint8(&irqLine)
+	cpu.irqLine = IRQSource(irqLine)
+	s.Bool(&cpu.irqPending)
+	s.Bool(&cpu.delayedIFlag)
+	s.Bool(&cpu.jammed)
+}
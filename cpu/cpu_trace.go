@@ -0,0 +1,159 @@
+package cpu
+
+// Traces in the format of nestest.log, the reference log for the nestest ROM.  Each instruction
+// is written as one line before it executes, for example:
+//
+// C72A  D0 E0     BNE $C70C                       A:00 X:00 Y:00 P:26 SP:FB PPU:  2,  0 CYC:235
+// C6BD  04 A9    *NOP $A9 = 00                    A:AA X:97 Y:4E P:EF SP:F5 PPU: 76,113 CYC:8633
+//
+// That's the address, the instruction's bytes, a '*' for undocumented instructions, the
+// disassembly with the memory it refers to, the registers, the PPU's scanline and dot, and the
+// CPU cycles since power on.  Traces can be diffed against logs from other emulators that use the
+// same format.
+
+import (
+	"fmt"
+	"io"
+)
+
+// Tells the trace where the rest of the machine is: the PPU's scanline and dot, and how many CPU
+// cycles have passed since power on.
+type TraceClock func() (scanline, dot int, cycles uint64)
+
+// Memory that can be read without side effects, like clearing flags or advancing the controller
+// shift register.  If the memory given to the CPU implements this, it's used by the trace.
+type Peeker interface {
+	Peek(addr uint16) (val uint8)
+}
+
+// The names nestest.log uses, where they differ from ours.
+var traceNames = map[string]string {
+	"AAX": "SAX",
+	"DOP": "NOP",
+	"ISC": "ISB",
+	"TOP": "NOP",
+}
+
+// Write a trace line to 'w' before every instruction.  'clock' fills in the PPU position and the
+// cycle count.  A nil 'w' turns tracing off.
+func (cpu *CPU) SetTrace(w io.Writer, clock TraceClock) {
+	cpu.trace = w
+	cpu.traceClock = clock
+}
+
+// Set the program counter.  nestest is run from 0xC000 to test the CPU without the PPU.
+func (cpu *CPU) SetPC(pc uint16) {
+	cpu.pc = pc
+}
+
+// Read 'addr' for the trace.
+func (cpu *CPU) peek(addr uint16) uint8 {
+	if peeker, ok := cpu.mem.(Peeker); ok {
+		return peeker.Peek(addr)
+	}
+	return cpu.mem.Read(addr)
+}
+
+// Read a 16-bit word at 'addr' for the trace.  'wrap' keeps the high byte on the same page, like
+// zero-page pointers and the JMP indirect bug.
+func (cpu *CPU) peek16(addr uint16, wrap bool) uint16 {
+	highAddr := addr + 1
+	if wrap {
+		highAddr = (addr & 0xff00) | (highAddr & 0xff)
+	}
+	return uint16(cpu.peek(addr)) | (uint16(cpu.peek(highAddr)) << 8)
+}
+
+// Write the trace line for the instruction at the PC.
+func (cpu *CPU) traceOp() {
+	io.WriteString(cpu.trace, cpu.TraceLine(cpu.traceClock()) + "\n")
+}
+
+// Format the instruction at the PC and the registers as a trace line, given where the PPU is and
+// how many cycles have passed.
+func (cpu *CPU) TraceLine(scanline, dot int, cycles uint64) string {
+	opcode := cpu.peek(cpu.pc)
+	op := opTable[opcode]
+
+	size := uint16(1)
+	disasm := "???"
+	marker := " "
+	if nil != op {
+		size, disasm = cpu.traceDisassemble(opcode, op)
+		if isUndocumented(opcode, op) {
+			marker = "*"
+		}
+	}
+
+	bytes := ""
+	for i := uint16(0); i < size; i++ {
+		bytes += fmt.Sprintf("%02X ", cpu.peek(cpu.pc + i))
+	}
+
+	// nestest.log doesn't show B, since it only exists on the stack.
+	return fmt.Sprintf("%04X  %-9s%s%-32sA:%02X X:%02X Y:%02X P:%02X SP:%02X PPU:%3d,%3d CYC:%d",
+			   cpu.pc, bytes, marker, disasm, cpu.ac, cpu.xr, cpu.yr,
+			   ALWAYS_ON | (cpu.st & ^B), cpu.sp, scanline, dot, cycles)
+}
+
+// Disassemble the instruction at the PC, showing the memory it refers to as it is before the
+// instruction executes.  Returns the size of the instruction in bytes and the text.
+func (cpu *CPU) traceDisassemble(opcode uint8, op *OpcodeEntry) (size uint16, out string) {
+	name := op.name
+	if traceName, ok := traceNames[name]; ok {
+		name = traceName
+	}
+
+	operand8 := cpu.peek(cpu.pc + 1)
+	operand16 := cpu.peek16(cpu.pc + 1, false)
+
+	switch(op.addressing) {
+	case IMP:
+		if isAccumulatorOp(opcode) {
+			return 1, name + " A"
+		}
+		return 1, name
+	case IMM:
+		if isBranch(opcode) {
+			target := cpu.pc + 2 + uint16(int8(operand8))
+			return 2, fmt.Sprintf("%s $%04X", name, target)
+		}
+		return 2, fmt.Sprintf("%s #$%02X", name, operand8)
+	case ZP:
+		return 2, fmt.Sprintf("%s $%02X = %02X", name, operand8, cpu.peek(uint16(operand8)))
+	case ZPX:
+		addr := operand8 + cpu.xr
+		return 2, fmt.Sprintf("%s $%02X,X @ %02X = %02X", name, operand8, addr,
+				      cpu.peek(uint16(addr)))
+	case ZPY:
+		addr := operand8 + cpu.yr
+		return 2, fmt.Sprintf("%s $%02X,Y @ %02X = %02X", name, operand8, addr,
+				      cpu.peek(uint16(addr)))
+	case ABS:
+		// Jumps don't touch the memory at their operand.
+		if 0x4C == opcode || 0x20 == opcode {
+			return 3, fmt.Sprintf("%s $%04X", name, operand16)
+		}
+		return 3, fmt.Sprintf("%s $%04X = %02X", name, operand16, cpu.peek(operand16))
+	case ABSX:
+		addr := operand16 + uint16(cpu.xr)
+		return 3, fmt.Sprintf("%s $%04X,X @ %04X = %02X", name, operand16, addr, cpu.peek(addr))
+	case ABSY:
+		addr := operand16 + uint16(cpu.yr)
+		return 3, fmt.Sprintf("%s $%04X,Y @ %04X = %02X", name, operand16, addr, cpu.peek(addr))
+	case IND:
+		return 3, fmt.Sprintf("%s ($%04X) = %04X", name, operand16, cpu.peek16(operand16, true))
+	case INDX:
+		pointer := operand8 + cpu.xr
+		addr := cpu.peek16(uint16(pointer), true)
+		return 2, fmt.Sprintf("%s ($%02X,X) @ %02X = %04X = %02X", name, operand8, pointer, addr,
+				      cpu.peek(addr))
+	case INDY:
+		base := cpu.peek16(uint16(operand8), true)
+		addr := base + uint16(cpu.yr)
+		return 2, fmt.Sprintf("%s ($%02X),Y = %04X @ %04X = %02X", name, operand8, base, addr,
+				      cpu.peek(addr))
+	}
+
+	return 1, name
+}
@@ -0,0 +1,49 @@
+package cpu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTraceFormat(t *testing.T) {
+	var mymem = NewMemoryForTesting()
+	mymem.Write(vectorReset, 0x00)
+	mymem.Write(vectorReset + 1, 0xc0)
+
+	// JMP $C005; (gap); LDA ($80),Y; DOP $A9; LSR A
+	program := []uint8{0x4c, 0x05, 0xc0, 0xea, 0xea, 0xb1, 0x80, 0x04, 0xa9, 0x4a}
+	for i, b := range program {
+		mymem.Write(0xc000 + uint16(i), b)
+	}
+	mymem.Write(0x80, 0x00)
+	mymem.Write(0x81, 0x03)
+	mymem.Write(0x0300, 0x89)
+
+	mycpu := NewCPU(mymem)
+	var trace bytes.Buffer
+	cycles := uint64(7)
+	mycpu.SetTrace(&trace, func() (int, int, uint64) {
+		return 0, 21, cycles
+	})
+
+	for i := 0; i < 4; i++ {
+		cycles += mycpu.Interpret()
+	}
+
+	expected := []string{
+		"C000  4C 05 C0  JMP $C005                       A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7",
+		"C005  B1 80     LDA ($80),Y = 0300 @ 0300 = 89  A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:10",
+		"C007  04 A9    *NOP $A9 = 00                    A:89 X:00 Y:00 P:A4 SP:FD PPU:  0, 21 CYC:15",
+		"C009  4A        LSR A                           A:89 X:00 Y:00 P:A4 SP:FD PPU:  0, 21 CYC:18",
+	}
+	lines := strings.Split(strings.TrimSuffix(trace.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatal("Expected", len(expected), "lines, got", len(lines), ":", trace.String())
+	}
+	for i := range expected {
+		if expected[i] != lines[i] {
+			t.Errorf("Line %d:\nexpected %q\ngot      %q", i, expected[i], lines[i])
+		}
+	}
+}
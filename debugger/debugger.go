@@ -0,0 +1,231 @@
+package debugger
+
+// This package is a debugger for the console.  The emulator runs a frame at a time through
+// StepFrame, which stops at breakpoints and watchpoints.  While it's stopped, REPL reads commands
+// from a terminal to inspect the machine, set breakpoints and step through the program.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"console"
+)
+
+// Commands that run the emulator until something happens give up after this many frames, about 10
+// seconds, so a condition that's never met doesn't hang the REPL.
+const maxRunFrames = 600
+
+// Which address space a watchpoint watches.
+const (
+	spaceCPU = iota
+	spacePPU
+)
+
+// A breakpoint or a watchpoint.  Breakpoints stop the emulator before the instruction at 'addr'
+// executes.  Watchpoints stop it after an instruction reads or writes 'addr'; the PPU's accesses
+// stop it after the instruction that was executing at the time.
+type point struct {
+	watch bool
+
+	space int
+	addr uint16
+
+	// Which accesses a watchpoint stops at.
+	read bool
+	write bool
+}
+
+type Debugger struct {
+	nes *console.Console
+
+	// Commands are read from 'in', and everything is written to 'out'.
+	in *bufio.Scanner
+	out io.Writer
+
+	// The breakpoints and watchpoints.  They're numbered from 1, like in gdb.  A deleted one
+	// leaves a nil gap so the numbers don't change.
+	points []*point
+
+	// Why the emulator stopped, if a watchpoint was hit during the last instruction.
+	watchHit string
+
+	// The last command entered.  An empty line repeats it.
+	lastCommand string
+
+	// Whether the CPU was jammed when last checked, so a jam is only reported once.
+	jammed bool
+}
+
+func NewDebugger(nes *console.Console, in io.Reader, out io.Writer) (d *Debugger) {
+	d = new(Debugger)
+	d.nes = nes
+	d.in = bufio.NewScanner(in)
+	d.out = out
+	return
+}
+
+// Run the emulator until the end of the frame, like Console.StepFrame, but stop at breakpoints and
+// watchpoints, and if the CPU jams.  Returns true if something was hit, and the REPL should be run.
+func (d *Debugger) StepFrame() (stopped bool) {
+	if !d.hasPoints(false) && !d.hasPoints(true) {
+		// A jam isn't noticed until the end of the frame, but nothing happens after one
+		// anyway.
+		d.nes.StepFrame()
+		return d.checkJam()
+	}
+	return d.nes.StepFrameUntil(d.shouldStop)
+}
+
+// If the CPU has jammed since the last check, show the registers and the stack, and return true.
+func (d *Debugger) checkJam() bool {
+	jammed := d.nes.Jammed()
+	newJam := jammed && !d.jammed
+	d.jammed = jammed
+	if !newJam {
+		return false
+	}
+
+	pc := d.nes.Registers().PC
+	fmt.Fprintf(d.out, "CPU jammed by opcode $%02X at $%04X.  Reset to start it again.\n",
+		    d.nes.PeekCPU(pc), pc)
+	d.cmdRegs(nil)
+
+	// The stack grows down from 0x1FF.  What's on it might show how we got here.
+	sp := d.nes.Registers().SP
+	if 0xff != sp {
+		fmt.Fprintln(d.out, "Stack:")
+		d.dumpMemory(d.nes.PeekCPU, 0x100 + uint16(sp) + 1, 0xff - int(sp))
+	}
+	return true
+}
+
+// Are there any watchpoints (if 'watch'), or breakpoints?
+func (d *Debugger) hasPoints(watch bool) bool {
+	for _, p := range d.points {
+		if nil != p && watch == p.watch {
+			return true
+		}
+	}
+	return false
+}
+
+// Called after each instruction.  Returns true, and says why, if the emulator should stop.
+func (d *Debugger) shouldStop() bool {
+	if d.checkJam() {
+		return true
+	}
+
+	if "" != d.watchHit {
+		fmt.Fprintln(d.out, d.watchHit)
+		d.watchHit = ""
+		return true
+	}
+
+	pc := d.nes.Registers().PC
+	for i, p := range d.points {
+		if nil != p && !p.watch && pc == p.addr {
+			fmt.Fprintf(d.out, "Breakpoint %d at $%04X\n", i + 1, pc)
+			return true
+		}
+	}
+	return false
+}
+
+// Run the emulator until 'done' returns true or something is hit.  'done' is called after each
+// instruction.  Gives up after maxRunFrames.
+func (d *Debugger) runUntil(done func() bool) {
+	for frames := 0; frames < maxRunFrames; {
+		_, frameComplete := d.nes.Step()
+		if d.shouldStop() || done() {
+			return
+		}
+		if frameComplete {
+			frames++
+		}
+	}
+	fmt.Fprintln(d.out, "Gave up after", maxRunFrames, "frames")
+}
+
+// Execute 'count' instructions.
+func (d *Debugger) stepInto(count int) {
+	for i := 0; i < count; i++ {
+		d.nes.Step()
+		if d.shouldStop() {
+			return
+		}
+	}
+}
+
+// Execute one instruction, but run subroutines it calls to completion.
+func (d *Debugger) stepOver() {
+	regs := d.nes.Registers()
+
+	// Only JSR needs special treatment.
+	if 0x20 != d.nes.PeekCPU(regs.PC) {
+		d.stepInto(1)
+		return
+	}
+
+	// Run until the subroutine returns, which is when the stack is back where it was.  Checking
+	// the stack too stops recursive calls from stopping early.
+	d.runUntil(func() bool {
+		now := d.nes.Registers()
+		return regs.PC + 3 == now.PC && regs.SP == now.SP
+	})
+}
+
+// Run until the current subroutine (or interrupt handler) returns.  That's when the stack pointer
+// rises above where it is now, as RTS or RTI pops the return address.
+func (d *Debugger) stepOut() {
+	sp := d.nes.Registers().SP
+	d.runUntil(func() bool {
+		return d.nes.Registers().SP > sp
+	})
+}
+
+// Run until the PPU starts drawing 'scanline'.
+func (d *Debugger) runToScanline(scanline int) {
+	last, _ := d.nes.PPUPosition()
+	d.runUntil(func() bool {
+		now, _ := d.nes.PPUPosition()
+		arrived := scanline == now && scanline != last
+		last = now
+		return arrived
+	})
+}
+
+// Implements console.Watcher.
+func (d *Debugger) CPUAccess(addr uint16, val uint8, write bool) {
+	d.checkWatchpoints(spaceCPU, addr, val, write)
+}
+
+// Implements console.Watcher.
+func (d *Debugger) PPUAccess(addr uint16, val uint8, write bool) {
+	d.checkWatchpoints(spacePPU, addr, val, write)
+}
+
+func (d *Debugger) checkWatchpoints(space int, addr uint16, val uint8, write bool) {
+	for i, p := range d.points {
+		if nil == p || !p.watch || space != p.space || addr != p.addr {
+			continue
+		}
+		if (write && p.write) || (!write && p.read) {
+			action := "read"
+			if write {
+				action = "write"
+			}
+			d.watchHit = fmt.Sprintf("Watchpoint %d: %s $%02X at %s $%04X", i + 1, action, val,
+						 spaceNames[space], addr)
+		}
+	}
+}
+
+// The console only has to report memory accesses while there are watchpoints.
+func (d *Debugger) updateWatcher() {
+	if d.hasPoints(true) {
+		d.nes.SetWatcher(d)
+	} else {
+		d.nes.SetWatcher(nil)
+	}
+}
@@ -0,0 +1,401 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"disasm"
+)
+
+// The names of the address spaces, as used by commands.
+var spaceNames = map[int]string {
+	spaceCPU: "cpu",
+	spacePPU: "ppu",
+}
+
+// A REPL command.  'args' are the words after the command's name.  Returns true if the emulator
+// should carry on running.
+type command struct {
+	names []string
+	usage string
+	help string
+	run func(d *Debugger, args []string) (resume bool, err error)
+}
+
+var commands []command
+
+func init() {
+	// Set up here rather than in the declaration, as "help" refers to the list.
+	commands = []command {
+		{ []string{"help", "h", "?"}, "", "Show this list",
+		  (*Debugger).cmdHelp },
+		{ []string{"continue", "c"}, "", "Carry on running the emulator",
+		  (*Debugger).cmdContinue },
+		{ []string{"step", "s"}, "[count]", "Execute one instruction, or 'count' of them",
+		  (*Debugger).cmdStep },
+		{ []string{"next", "n"}, "", "Execute one instruction, running any subroutine it calls",
+		  (*Debugger).cmdNext },
+		{ []string{"finish", "out"}, "", "Run until the current subroutine returns",
+		  (*Debugger).cmdFinish },
+		{ []string{"scanline", "line"}, "<line>", "Run until the PPU starts drawing scanline 'line'",
+		  (*Debugger).cmdScanline },
+		{ []string{"break", "b"}, "<addr>", "Stop before the instruction at 'addr' executes",
+		  (*Debugger).cmdBreak },
+		{ []string{"watch", "w"}, "[cpu|ppu] <addr> [r|w|rw]",
+		  "Stop after 'addr' is read and/or written (default: cpu, rw)",
+		  (*Debugger).cmdWatch },
+		{ []string{"delete", "d"}, "[number]", "Delete a breakpoint or watchpoint, or all of them",
+		  (*Debugger).cmdDelete },
+		{ []string{"list", "l"}, "", "List the breakpoints and watchpoints",
+		  (*Debugger).cmdList },
+		{ []string{"regs", "r"}, "", "Show the registers and the next instruction",
+		  (*Debugger).cmdRegs },
+		{ []string{"mem", "m"}, "[cpu|ppu] <addr> [length]", "Dump memory (default: cpu, 64 bytes)",
+		  (*Debugger).cmdMem },
+		{ []string{"disasm", "u"}, "[addr] [count]",
+		  "Disassemble 'count' instructions (default: from the PC, 10)",
+		  (*Debugger).cmdDisasm },
+		{ []string{"trace"}, "on|off", "Print every instruction as it executes",
+		  (*Debugger).cmdTrace },
+		{ []string{"reset"}, "", "Press the reset button",
+		  (*Debugger).cmdReset },
+		{ []string{"quit", "q"}, "", "Quit the emulator",
+		  nil },
+	}
+}
+
+// Find the command called 'name'.
+func findCommand(name string) *command {
+	for i := range commands {
+		for _, n := range commands[i].names {
+			if n == name {
+				return &commands[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Read and run commands until one resumes the emulator.  Returns true if the user wants to quit,
+// or there is no more input.
+func (d *Debugger) REPL() (quit bool) {
+	d.showLocation()
+
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			return true
+		}
+
+		line := strings.TrimSpace(d.in.Text())
+		if "" == line {
+			line = d.lastCommand
+		}
+		d.lastCommand = line
+
+		words := strings.Fields(line)
+		if 0 == len(words) {
+			continue
+		}
+
+		cmd := findCommand(words[0])
+		if nil == cmd {
+			fmt.Fprintf(d.out, "Unknown command %q.  Try \"help\".\n", words[0])
+			continue
+		}
+		if nil == cmd.run {
+			return true
+		}
+
+		resume, err := cmd.run(d, words[1:])
+		if nil != err {
+			fmt.Fprintln(d.out, err)
+			fmt.Fprintln(d.out, "Usage:", cmd.names[0], cmd.usage)
+			continue
+		}
+		if resume {
+			return false
+		}
+	}
+}
+
+// Show where the emulator is stopped.
+func (d *Debugger) showLocation() {
+	fmt.Fprintln(d.out, d.nes.TraceLine())
+}
+
+// Parse a hex address, which may start with '$' or "0x".
+func parseAddr(s string) (uint16, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	addr, err := strconv.ParseUint(s, 16, 16)
+	if nil != err {
+		return 0, fmt.Errorf("Bad address %q", s)
+	}
+	return uint16(addr), nil
+}
+
+// Take an optional "cpu" or "ppu" off the front of 'args'.  Defaults to the CPU.
+func parseSpace(args []string) (space int, rest []string) {
+	if len(args) > 0 {
+		for space, name := range spaceNames {
+			if name == args[0] {
+				return space, args[1:]
+			}
+		}
+	}
+	return spaceCPU, args
+}
+
+func (d *Debugger) cmdHelp(args []string) (bool, error) {
+	for _, cmd := range commands {
+		usage := strings.Join(cmd.names, ", ")
+		if "" != cmd.usage {
+			usage += " " + cmd.usage
+		}
+		fmt.Fprintf(d.out, "  %-36s %s\n", usage, cmd.help)
+	}
+	fmt.Fprintln(d.out, "  An empty line repeats the last command.  Addresses are in hex.")
+	return false, nil
+}
+
+func (d *Debugger) cmdContinue(args []string) (bool, error) {
+	return true, nil
+}
+
+func (d *Debugger) cmdStep(args []string) (bool, error) {
+	count := 1
+	if len(args) > 0 {
+		var err error
+		if count, err = strconv.Atoi(args[0]); nil != err || count < 1 {
+			return false, fmt.Errorf("Bad count %q", args[0])
+		}
+	}
+
+	d.stepInto(count)
+	d.showLocation()
+	return false, nil
+}
+
+func (d *Debugger) cmdNext(args []string) (bool, error) {
+	d.stepOver()
+	d.showLocation()
+	return false, nil
+}
+
+func (d *Debugger) cmdFinish(args []string) (bool, error) {
+	d.stepOut()
+	d.showLocation()
+	return false, nil
+}
+
+func (d *Debugger) cmdScanline(args []string) (bool, error) {
+	if 1 != len(args) {
+		return false, fmt.Errorf("Which scanline?")
+	}
+	scanline, err := strconv.Atoi(args[0])
+	if nil != err || scanline < 0 || scanline > 261 {
+		return false, fmt.Errorf("Bad scanline %q", args[0])
+	}
+
+	d.runToScanline(scanline)
+	d.showLocation()
+	return false, nil
+}
+
+func (d *Debugger) cmdBreak(args []string) (bool, error) {
+	if 1 != len(args) {
+		return false, fmt.Errorf("Where?")
+	}
+	addr, err := parseAddr(args[0])
+	if nil != err {
+		return false, err
+	}
+
+	d.points = append(d.points, &point{addr: addr})
+	fmt.Fprintf(d.out, "Breakpoint %d at $%04X\n", len(d.points), addr)
+	return false, nil
+}
+
+func (d *Debugger) cmdWatch(args []string) (bool, error) {
+	space, args := parseSpace(args)
+	if len(args) < 1 || len(args) > 2 {
+		return false, fmt.Errorf("Where?")
+	}
+	addr, err := parseAddr(args[0])
+	if nil != err {
+		return false, err
+	}
+
+	p := &point{watch: true, space: space, addr: addr, read: true, write: true}
+	if 2 == len(args) {
+		switch args[1] {
+		case "r":
+			p.write = false
+		case "w":
+			p.read = false
+		case "rw":
+		default:
+			return false, fmt.Errorf("Bad access %q", args[1])
+		}
+	}
+
+	d.points = append(d.points, p)
+	d.updateWatcher()
+	fmt.Fprintf(d.out, "Watchpoint %d at %s $%04X\n", len(d.points), spaceNames[space], addr)
+	return false, nil
+}
+
+func (d *Debugger) cmdDelete(args []string) (bool, error) {
+	if 0 == len(args) {
+		d.points = nil
+		d.updateWatcher()
+		return false, nil
+	}
+
+	number, err := strconv.Atoi(args[0])
+	if nil != err || number < 1 || number > len(d.points) || nil == d.points[number - 1] {
+		return false, fmt.Errorf("No breakpoint or watchpoint %q", args[0])
+	}
+
+	d.points[number - 1] = nil
+	d.updateWatcher()
+	return false, nil
+}
+
+func (d *Debugger) cmdList(args []string) (bool, error) {
+	for i, p := range d.points {
+		if nil == p {
+			continue
+		}
+		if !p.watch {
+			fmt.Fprintf(d.out, "Breakpoint %d at $%04X\n", i + 1, p.addr)
+			continue
+		}
+
+		access := ""
+		if p.read {
+			access += "r"
+		}
+		if p.write {
+			access += "w"
+		}
+		fmt.Fprintf(d.out, "Watchpoint %d at %s $%04X (%s)\n", i + 1, spaceNames[p.space],
+			    p.addr, access)
+	}
+	return false, nil
+}
+
+func (d *Debugger) cmdRegs(args []string) (bool, error) {
+	regs := d.nes.Registers()
+	flags := ""
+	for i, name := range "NV-BDIZC" {
+		if 0 != (regs.P & (0x80 >> uint(i))) {
+			flags += string(name)
+		} else {
+			flags += "."
+		}
+	}
+
+	scanline, dot := d.nes.PPUPosition()
+	fmt.Fprintf(d.out, "PC:%04X A:%02X X:%02X Y:%02X SP:%02X P:%02X [%s]\n", regs.PC, regs.A,
+		    regs.X, regs.Y, regs.SP, regs.P, flags)
+	fmt.Fprintf(d.out, "Scanline %d, dot %d, cycle %d\n", scanline, dot, d.nes.Cycles())
+	d.showLocation()
+	return false, nil
+}
+
+func (d *Debugger) cmdMem(args []string) (bool, error) {
+	space, args := parseSpace(args)
+	if len(args) < 1 || len(args) > 2 {
+		return false, fmt.Errorf("Where?")
+	}
+	addr, err := parseAddr(args[0])
+	if nil != err {
+		return false, err
+	}
+
+	length := 64
+	if 2 == len(args) {
+		if length, err = strconv.Atoi(args[1]); nil != err || length < 1 {
+			return false, fmt.Errorf("Bad length %q", args[1])
+		}
+	}
+
+	peek := d.nes.PeekCPU
+	if spacePPU == space {
+		peek = d.nes.PeekPPU
+	}
+	d.dumpMemory(peek, addr, length)
+	return false, nil
+}
+
+// Show 'length' bytes from 'addr' in hex, reading them with 'peek'.
+func (d *Debugger) dumpMemory(peek func(addr uint16) uint8, addr uint16, length int) {
+	// 16 bytes per line.
+	for i := 0; i < length; i += 16 {
+		line := fmt.Sprintf("%04X:", addr + uint16(i))
+		for j := i; j < i + 16 && j < length; j++ {
+			line += fmt.Sprintf(" %02X", peek(addr + uint16(j)))
+		}
+		fmt.Fprintln(d.out, line)
+	}
+}
+
+// Lets the disassembler read the console's memory without side effects.
+type peekMemory struct {
+	d *Debugger
+}
+
+func (mem peekMemory) Read(addr uint16) uint8 {
+	return mem.d.nes.PeekCPU(addr)
+}
+
+func (mem peekMemory) Write(addr uint16, val uint8) uint64 {
+	return 0
+}
+
+func (d *Debugger) cmdDisasm(args []string) (bool, error) {
+	if len(args) > 2 {
+		return false, fmt.Errorf("Too many arguments")
+	}
+
+	addr := d.nes.Registers().PC
+	count := 10
+	var err error
+	if len(args) > 0 {
+		if addr, err = parseAddr(args[0]); nil != err {
+			return false, err
+		}
+	}
+	if len(args) > 1 {
+		if count, err = strconv.Atoi(args[1]); nil != err || count < 1 {
+			return false, fmt.Errorf("Bad count %q", args[1])
+		}
+	}
+
+	for _, inst := range disasm.DisassembleMemory(peekMemory{d}, addr, count) {
+		fmt.Fprintf(d.out, "%04X  %-9s %s\n", inst.Addr, inst.HexBytes(), inst)
+	}
+	return false, nil
+}
+
+func (d *Debugger) cmdTrace(args []string) (bool, error) {
+	if 1 != len(args) || ("on" != args[0] && "off" != args[0]) {
+		return false, fmt.Errorf("On or off?")
+	}
+
+	if "on" == args[0] {
+		d.nes.SetTrace(d.out)
+	} else {
+		d.nes.SetTrace(nil)
+	}
+	return false, nil
+}
+
+func (d *Debugger) cmdReset(args []string) (bool, error) {
+	d.nes.Reset()
+	d.showLocation()
+	return false, nil
+}
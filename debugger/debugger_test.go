@@ -0,0 +1,107 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"console"
+	"nesfile"
+)
+
+// A cart that calls a subroutine in a loop.
+func makeTestCart() *nesfile.NesFile {
+	prg := make([]byte, 0x4000)
+	copy(prg, []byte{
+		0x20, 0x10, 0xc0, // C000: JSR $C010
+		0xe6, 0x10,       // C003: INC $10
+		0x4c, 0x00, 0xc0, // C005: JMP $C000
+	})
+	copy(prg[0x10:], []byte{
+		0xa9, 0x42,       // C010: LDA #$42
+		0x8d, 0x00, 0x03, // C012: STA $0300
+		0x60,             // C015: RTS
+	})
+	prg[0x3ffc], prg[0x3ffd] = 0x00, 0xc0
+
+	nesFile := new(nesfile.NesFile)
+	nesFile.Mirroring = nesfile.Horizontal
+	nesFile.PrgRom = [][]byte{prg}
+	nesFile.ChrRom = [][]byte{make([]byte, 0x2000)}
+	return nesFile
+}
+
+func TestDebugger(t *testing.T) {
+	nes, err := console.NewConsole(makeTestCart())
+	if nil != err {
+		t.Fatal(err)
+	}
+	script := "break c012\ncontinue\nfinish\nwatch 300 w\ndelete 1\ncontinue\nnext\nquit\n"
+	var out bytes.Buffer
+	d := NewDebugger(nes, strings.NewReader(script), &out)
+
+	expectPC := func(pc uint16) {
+		if regs := nes.Registers(); pc != regs.PC {
+			t.Fatalf("Expected PC %04X, got %04X.  Output:\n%s", pc, regs.PC, out.String())
+		}
+	}
+
+	if d.REPL() {
+		t.Fatal("Quit instead of continuing")
+	}
+	if !d.StepFrame() {
+		t.Fatal("Didn't stop at the breakpoint")
+	}
+	expectPC(0xc012)
+
+	// finish runs to the instruction after the JSR, and the watchpoint stops the next time
+	// around the loop, after the STA.
+	if d.REPL() {
+		t.Fatal("Quit instead of continuing")
+	}
+	expectPC(0xc003)
+	if !d.StepFrame() {
+		t.Fatal("Didn't stop at the watchpoint")
+	}
+	expectPC(0xc015)
+	if !strings.Contains(out.String(), "Watchpoint 2: write $42 at cpu $0300") {
+		t.Error("Watchpoint not reported:", out.String())
+	}
+
+	// next steps over the RTS like any other instruction.
+	if !d.REPL() {
+		t.Fatal("Didn't quit")
+	}
+	expectPC(0xc003)
+}
+
+// A jam stops the emulator once, and shows where it happened.
+func TestJam(t *testing.T) {
+	cart := makeTestCart()
+	cart.PrgRom[0][0x10] = 0x02 // C010: KIL
+	nes, err := console.NewConsole(cart)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	d := NewDebugger(nes, strings.NewReader(""), &out)
+
+	if !d.StepFrame() {
+		t.Fatal("Didn't stop at the jam")
+	}
+	// The return address pushed by the JSR is on the stack.
+	for _, expected := range []string{"CPU jammed by opcode $02 at $C010", "01FC: 02 C0"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in the output:\n%s", expected, out.String())
+		}
+	}
+
+	if d.StepFrame() {
+		t.Error("Stopped at the same jam twice")
+	}
+
+	nes.Reset()
+	if nes.Jammed() {
+		t.Error("Still jammed after reset")
+	}
+}
@@ -0,0 +1,175 @@
+package disasm
+
+// This package disassembles 6502 machine code using the CPU's op table.  Disassembly is a linear
+// sweep: every byte is assumed to start an instruction, so data mixed in with code comes out as
+// nonsense instructions.
+
+import (
+	"fmt"
+	"strings"
+
+	"cpu"
+)
+
+// One disassembled instruction.
+type Instruction struct {
+	// Where the instruction is.
+	Addr uint16
+
+	// The opcode and operand bytes.
+	Bytes []byte
+
+	// The instruction's name, like "LDA".  Bytes that aren't a known opcode, or are cut off by the
+	// end of the code, are ".byte".
+	Mnemonic string
+
+	// The addressing mode, one of the constants in the cpu package.
+	Addressing int
+
+	// The operand as written in assembly, like "#$10" or "($20),Y".  Empty if there isn't one.
+	Operand string
+
+	// Set for undocumented opcodes.
+	Undocumented bool
+
+	// Where branches, JMP and JSR go.  HasTarget is false for other instructions, and for
+	// indirect JMP, whose target isn't known until it runs.
+	Target uint16
+	HasTarget bool
+}
+
+// Like "LDA #$10".
+func (inst Instruction) String() string {
+	return inst.Format(nil)
+}
+
+// Like String, but the target is shown as its label if it has one in 'labels'.
+func (inst Instruction) Format(labels map[uint16]string) string {
+	operand := inst.Operand
+	if label, ok := labels[inst.Target]; ok && inst.HasTarget {
+		operand = label
+	}
+
+	if "" == operand {
+		return inst.Mnemonic
+	}
+	return inst.Mnemonic + " " + operand
+}
+
+// The address of the instruction after this one.
+func (inst Instruction) Next() uint16 {
+	return inst.Addr + uint16(len(inst.Bytes))
+}
+
+// The bytes as hex, like "A9 10".
+func (inst Instruction) HexBytes() string {
+	hex := make([]string, len(inst.Bytes))
+	for i, b := range inst.Bytes {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, " ")
+}
+
+// Disassemble the instruction at 'addr'.  'read' gets the bytes of memory.  'limit' is how many
+// bytes there are from 'addr' on; if the instruction doesn't fit, it's a ".byte".
+func decode(read func(addr uint16) uint8, addr uint16, limit int) (inst Instruction) {
+	inst.Addr = addr
+	opcode := read(addr)
+
+	info, ok := cpu.LookupOpcode(opcode)
+	size := cpu.InstructionSize(info.Addressing)
+	if !ok || size > limit {
+		inst.Bytes = []byte{opcode}
+		inst.Mnemonic = ".byte"
+		inst.Operand = fmt.Sprintf("$%02X", opcode)
+		return
+	}
+
+	for i := 0; i < size; i++ {
+		inst.Bytes = append(inst.Bytes, read(addr + uint16(i)))
+	}
+	inst.Mnemonic = info.Name
+	inst.Addressing = info.Addressing
+	inst.Undocumented = info.Undocumented
+
+	var operand8 uint8
+	var operand16 uint16
+	if size > 1 {
+		operand8 = inst.Bytes[1]
+		operand16 = uint16(operand8)
+	}
+	if size > 2 {
+		operand16 |= uint16(inst.Bytes[2]) << 8
+	}
+
+	switch(info.Addressing) {
+	case cpu.ACC:
+		inst.Operand = "A"
+	case cpu.IMM:
+		inst.Operand = fmt.Sprintf("#$%02X", operand8)
+	case cpu.ZP:
+		inst.Operand = fmt.Sprintf("$%02X", operand8)
+	case cpu.ZPX:
+		inst.Operand = fmt.Sprintf("$%02X,X", operand8)
+	case cpu.ZPY:
+		inst.Operand = fmt.Sprintf("$%02X,Y", operand8)
+	case cpu.ABS:
+		inst.Operand = fmt.Sprintf("$%04X", operand16)
+		// JMP and JSR go to their operand.
+		if 0x4c == opcode || 0x20 == opcode {
+			inst.Target = operand16
+			inst.HasTarget = true
+		}
+	case cpu.ABSX:
+		inst.Operand = fmt.Sprintf("$%04X,X", operand16)
+	case cpu.ABSY:
+		inst.Operand = fmt.Sprintf("$%04X,Y", operand16)
+	case cpu.IND:
+		inst.Operand = fmt.Sprintf("($%04X)", operand16)
+	case cpu.INDX:
+		inst.Operand = fmt.Sprintf("($%02X,X)", operand8)
+	case cpu.INDY:
+		inst.Operand = fmt.Sprintf("($%02X),Y", operand8)
+	case cpu.REL:
+		// Relative to the next instruction.
+		inst.Target = addr + 2 + uint16(int8(operand8))
+		inst.HasTarget = true
+		inst.Operand = fmt.Sprintf("$%04X", inst.Target)
+	}
+	return
+}
+
+// Disassemble 'code', which is at 'start' in memory.
+func Disassemble(code []byte, start uint16) (insts []Instruction) {
+	read := func(addr uint16) uint8 {
+		return code[addr - start]
+	}
+
+	for offset := 0; offset < len(code); {
+		inst := decode(read, start + uint16(offset), len(code) - offset)
+		insts = append(insts, inst)
+		offset += len(inst.Bytes)
+	}
+	return
+}
+
+// Disassemble 'count' instructions from 'mem', starting at 'start'.  Reading some memory has side
+// effects; if 'mem' implements cpu.Peeker, that's used instead.
+func DisassembleMemory(mem cpu.MemoryInterface, start uint16, count int) (insts []Instruction) {
+	read := mem.Read
+	if peeker, ok := mem.(cpu.Peeker); ok {
+		read = peeker.Peek
+	}
+
+	addr := start
+	for i := 0; i < count; i++ {
+		// Don't wrap around the end of memory.
+		inst := decode(read, addr, 0x10000 - int(addr))
+		insts = append(insts, inst)
+		if inst.Next() <= addr {
+			break
+		}
+		addr = inst.Next()
+	}
+	return
+}
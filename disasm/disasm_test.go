@@ -0,0 +1,56 @@
+package disasm
+
+import "testing"
+
+func TestDisassemble(t *testing.T) {
+	code := []byte{
+		0xa9, 0x10,       // LDA #$10
+		0x0a,             // ASL A
+		0xb1, 0x20,       // LDA ($20),Y
+		0xd0, 0xf9,       // BNE $C000
+		0x20, 0x00, 0xc0, // JSR $C000
+		0x6c, 0x34, 0x12, // JMP ($1234)
+		0xa7, 0x10,       // LAX $10
+		0xad, 0x00,       // LDA, cut off
+	}
+
+	expected := []struct {
+		addr uint16
+		text string
+		hasTarget bool
+		target uint16
+	}{
+		{0xc000, "LDA #$10", false, 0},
+		{0xc002, "ASL A", false, 0},
+		{0xc003, "LDA ($20),Y", false, 0},
+		{0xc005, "BNE $C000", true, 0xc000},
+		{0xc007, "JSR $C000", true, 0xc000},
+		{0xc00a, "JMP ($1234)", false, 0},
+		{0xc00d, "LAX $10", false, 0},
+		{0xc00f, ".byte $AD", false, 0},
+		{0xc010, "BRK", false, 0},
+	}
+
+	insts := Disassemble(code, 0xc000)
+	if len(expected) != len(insts) {
+		t.Fatal("Expected", len(expected), "instructions, got", insts)
+	}
+	for i, inst := range insts {
+		e := expected[i]
+		if e.addr != inst.Addr || e.text != inst.String() || e.hasTarget != inst.HasTarget ||
+				e.target != inst.Target {
+			t.Errorf("Expected %04X %q (target %v %04X), got %04X %q (target %v %04X)",
+				 e.addr, e.text, e.hasTarget, e.target, inst.Addr, inst.String(),
+				 inst.HasTarget, inst.Target)
+		}
+	}
+
+	if !insts[6].Undocumented || insts[0].Undocumented {
+		t.Error("LAX should be undocumented, and LDA not")
+	}
+
+	labels := map[uint16]string{0xc000: "RESET"}
+	if text := insts[3].Format(labels); "BNE RESET" != text {
+		t.Error("Expected the label, got", text)
+	}
+}
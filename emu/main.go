@@ -2,119 +2,222 @@ package main
 
 import (
 	// Things from Go.
+	"flag"
 	"fmt"
 	"os"
-	"time"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 
 	// Things from Me.
-	"cpu"
-	"mapper"
+	"console"
+	"debugger"
 	"nesfile"
 	"ppu"
 	"wrapper"
 )
 
 const (
-	// There is a master clock that is divided differently for the PPU and CPU.
-	// The PPU is clocked faster than the CPU so we measure time in PPU clocks.
-	PPUCyclesPerScanLine = 341
-	PPUCyclesPerCPUCycle = 3
+	// How often battery-backed SRAM is written out while running, in frames.  About 5 seconds.
+	SRAMFlushFrames = 300
 )
 
+// Each of the console's controller buttons, in Button bit order, and the key it's mapped to.
+var keyReadOrder = [8]int {
+	wrapper.KEY_A_1,
+	wrapper.KEY_B_1,
+	wrapper.KEY_SELECT_1,
+	wrapper.KEY_START_1,
+	wrapper.KEY_UP_1,
+	wrapper.KEY_DOWN_1,
+	wrapper.KEY_LEFT_1,
+	wrapper.KEY_RIGHT_1,
+}
+
+// Implements console.Input with the keyboard or gamepad.
+type keyboardInput struct {
+	input *wrapper.InputProvider
+}
+
+func (ki keyboardInput) Buttons(player int) (buttons uint8) {
+	// Only player 1 is mapped.
+	if 0 != player {
+		return 0
+	}
+
+	for i, key := range keyReadOrder {
+		if ki.input.IsKeyPressed(key) {
+			buttons |= 1 << uint(i)
+		}
+	}
+	return
+}
+
+// Save states go next to the ROM, one file per slot.  Slot 3 of zelda.nes is zelda.ss3.
+func stateSlotPath(romPath string, slot int) string {
+	return fmt.Sprintf("%s.ss%d", strings.TrimSuffix(romPath, filepath.Ext(romPath)), slot)
+}
+
+// Save the console to the file at 'path'.
+func saveStateFile(nes *console.Console, path string) error {
+	// Write to a temporary file first so a crash mid-write can't destroy the old state.
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if nil != err {
+		return err
+	}
+
+	err = nes.SaveState(file)
+	if closeErr := file.Close(); nil == err {
+		err = closeErr
+	}
+	if nil != err {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Load the console from the file at 'path'.
+func loadStateFile(nes *console.Console, path string) error {
+	file, err := os.Open(path)
+	if nil != err {
+		return err
+	}
+	defer file.Close()
+	return nes.LoadState(file)
+}
+
 func main() {
-	if len(os.Args)  < 2 {
-		fmt.Println("Usage: ", os.Args[0], " somefile.nes <debug>")
+	patchPath := flag.String("patch", "",
+				 "IPS, UPS or BPS patch to apply, instead of one next to the ROM")
+	dbPath := flag.String("db", "",
+			      "nes20db.xml to correct headers from, as well as the built-in database")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Println("Usage: ", os.Args[0],
+			    " [-patch file] [-db nes20db.xml] somefile.nes|.zip|.gz <debug>")
+		fmt.Println("With a trailing argument, the emulator starts in the debugger.  F12 enters it")
+		fmt.Println("while running.  A patch with the same name as the ROM and a .ips, .ups or .bps")
+		fmt.Println("extension is applied when it's loaded.")
 		return
 	}
 
 	wrapper.Init()
 
-	// Read the iNES formatted file.  Dies if errors encountered.
-	nesFile := nesfile.ReadNesFile(os.Args[1])
-
-	// The mapper is the on-cart address mapping logic.
-	nesMapper := mapper.GetMapper(nesFile)
+	// Read the iNES formatted file, which may be in a zip or gzip file, and build a NES around
+	// it.
+	if "" != *dbPath {
+		if err := nesfile.DefaultDatabase.LoadXMLFile(*dbPath); nil != err {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	nesFile, romPath, err := loadRom(flag.Arg(0), *patchPath, os.Stdin, os.Stdout)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, mismatch := range nesFile.HeaderMismatches {
+		fmt.Println("Corrected the header from the database:", mismatch)
+	}
+	nes, err := console.NewConsole(nesFile)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	// Open the window.  The PPU will draw into this.
+	// Open the window.  Each frame the console draws is shown in it.
 	mainWindow := wrapper.NewWindow(ppu.DisplayHeight, ppu.DisplayWidth, "hello world")
 
-	// Processes graphical data and renders it into the window opened above.
-	nesPpu := ppu.NewPPU(nesMapper, mainWindow)
-
 	// Polls keyboard events and provides key press data.
 	input := wrapper.NewInputProvider()
-
-	// Implements the bus on the CPU.
-	nesMemory := NewNESMemory(nesPpu, nesMapper, input)
-
-	// Interprets and executes the opcodes.
-	nesCpu := cpu.NewCPU(nesMemory)
-
-	// If there are any trailing arguments turn on debugging.
-	if len(os.Args) > 2 {
-		nesCpu.Debug = true
-		nesPpu.Debug = true
-		nesMapper.Debug(true)
+	nes.SetInput(keyboardInput{input})
+
+	// Plays the audio the APU generates.
+	nes.SetAudio(wrapper.NewAudioSink(48000))
+
+	// The debugger is driven from the terminal.  If there are any trailing arguments, start in
+	// it.
+	dbg := debugger.NewDebugger(nes, os.Stdin, os.Stdout)
+	paused := flag.NArg() > 1
+
+	// Battery-backed SRAM is saved when we exit, including by crashing or being interrupted, and
+	// every so often while running in case we die in a way that can't be caught.
+	var sram *SRAMFile
+	if nes.NesFile().SramEnabled {
+		sram = LoadSRAM(romPath, nes.SRAM())
+		defer sram.Flush()
 	}
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	frames := 0
+
+	// Save states are saved to and loaded from the selected slot.
+	stateSlot := 0
 
 	// Main render loop
 	for {
+		select {
+		case <-interrupted:
+			return
+		default:
+		}
+
+		if input.WasKeyPressed(wrapper.KEY_DEBUG) {
+			paused = true
+		}
+		if paused {
+			// The emulator doesn't run while the debugger has the terminal.
+			if dbg.REPL() {
+				break
+			}
+			paused = false
+		}
+
 		if input.IsKeyPressed(wrapper.KEY_QUIT) {
 			break
 		} else if input.IsKeyPressed(wrapper.KEY_RESET) {
-			nesCpu.Reset()
+			nes.Reset()
+		}
+
+		if input.WasKeyPressed(wrapper.KEY_SPRITE_LIMIT) {
+			nes.SetSpriteLimit(!nes.SpriteLimit())
+		}
+
+		for slot := 0; slot < 10; slot++ {
+			if input.WasKeyPressed(wrapper.KEY_SLOT_0 + slot) {
+				stateSlot = slot
+				fmt.Println("Save state slot", stateSlot)
+			}
 		}
 
-		var cycles uint64 = 0
-
-		for scanline := 0; scanline < 262; scanline++ {
-			// Lines 0-239 are visible.
-			if scanline < 240 {
-				// We might execute too many cycles here...
-				for cycles < PPUCyclesPerScanLine {
-					cycles += PPUCyclesPerCPUCycle * nesCpu.Interpret()
-				}
-				nesPpu.RenderScanLine()
-				time.Sleep(10000)
-				// But we'll execute them later by doing a subtraction here instead
-				// of resetting cycles to 0.
-				cycles -= PPUCyclesPerScanLine
-			} else if scanline == 240 {
-				// Line 240 is the "post-render line"
-				for cycles < PPUCyclesPerScanLine {
-					cycles += PPUCyclesPerCPUCycle * nesCpu.Interpret()
-				}
-				cycles -= PPUCyclesPerScanLine
-			} else if scanline == 241 {
-				// VBlank is entered on line 241 (or at least the flag is set)
-				// Blit what we've rendered to the window.
-				mainWindow.Blit()
-
-				// Notify the PPU that we're in VBlank, and see if we should tell
-				// the CPU to execute an NMI.
-				if nesPpu.EnterVBlankShouldNMI() {
-					cycles += PPUCyclesPerCPUCycle * nesCpu.NMI()
-				}
-				for cycles < PPUCyclesPerScanLine {
-					cycles += PPUCyclesPerCPUCycle * nesCpu.Interpret()
-				}
-				cycles -= PPUCyclesPerScanLine
-			} else if scanline < 261 {
-				// Lines 242 -> 260 don't render or set flags.
-				for cycles < PPUCyclesPerScanLine {
-					// Turn on debugging during vblank for now.
-					cycles += PPUCyclesPerCPUCycle * nesCpu.Interpret()
-				}
-				cycles -= PPUCyclesPerScanLine
+		if input.WasKeyPressed(wrapper.KEY_SAVE_STATE) {
+			path := stateSlotPath(romPath, stateSlot)
+			if err := saveStateFile(nes, path); nil != err {
+				fmt.Println("Couldn't save state:", err)
+			} else {
+				fmt.Println("Saved state to", path)
+			}
+		} else if input.WasKeyPressed(wrapper.KEY_LOAD_STATE) {
+			path := stateSlotPath(romPath, stateSlot)
+			if err := loadStateFile(nes, path); nil != err {
+				fmt.Println("Couldn't load state:", err)
 			} else {
-				// The "pre-render" line clears the VBlank flag early.
-				nesPpu.ExitVBlank()
-				for cycles < PPUCyclesPerScanLine {
-					// Turn on debugging during vblank for now.
-					cycles += PPUCyclesPerCPUCycle * nesCpu.Interpret()
-				}
-				cycles -= PPUCyclesPerScanLine
+				fmt.Println("Loaded state from", path)
 			}
 		}
+
+		// Run until the PPU has drawn a whole frame, and show it.  The audio for the frame is
+		// queued too, and waiting for it to play keeps us running at the right speed.
+		// Breakpoints can stop the frame part way through.  What's been drawn so far is still
+		// shown.
+		paused = dbg.StepFrame()
+		mainWindow.Show(nes.Frame().RGBA)
+
+		frames++
+		if nil != sram && 0 == frames % SRAMFlushFrames {
+			sram.Flush()
+		}
 	}
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"nesfile"
+)
+
+// Read the ROM at 'romPath', patched with the patch at 'patchPath', or with the patch next to it
+// if that's "".  If it's an archive with several ROMs in it, ask which one on 'in'.  Also returns
+// the path saves are named after (see nesfile.RomPath), so each game in an archive has its own.
+func loadRom(romPath string, patchPath string, in io.Reader,
+	     out io.Writer) (*nesfile.NesFile, string, error) {
+	read := func(member string) (*nesfile.NesFile, error) {
+		if "" == patchPath {
+			return nesfile.ReadNesFileMember(romPath, member)
+		}
+		return nesfile.ReadPatchedNesFile(romPath, member, patchPath)
+	}
+
+	nesFile, err := read("")
+	var multiple *nesfile.MultipleRomsError
+	if !errors.As(err, &multiple) {
+		return nesFile, romPath, err
+	}
+
+	member, err := chooseRom(multiple.Names, in, out)
+	if nil != err {
+		return nil, "", err
+	}
+	nesFile, err = read(member)
+	return nesFile, nesfile.RomPath(romPath, member), err
+}
+
+// List 'names' on 'out' and read the number of one of them from 'in'.
+func chooseRom(names []string, in io.Reader, out io.Writer) (string, error) {
+	for i, name := range names {
+		fmt.Fprintf(out, "%d: %s\n", i + 1, name)
+	}
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "Which ROM? ")
+		if !scanner.Scan() {
+			if nil != scanner.Err() {
+				return "", scanner.Err()
+			}
+			return "", io.ErrUnexpectedEOF
+		}
+
+		choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if nil == err && choice >= 1 && choice <= len(names) {
+			return names[choice - 1], nil
+		}
+		fmt.Fprintln(out, "Pick a number from 1 to", len(names))
+	}
+}
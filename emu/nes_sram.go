@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Carts with battery-backed SRAM keep saved games in it.  We keep it in a .sav file next to the ROM,
+// loading it at startup and writing it back out whenever it has changed.
+type SRAMFile struct {
+	// Where the .sav file is.
+	path string
+
+	// The mapper's SRAM.  The game changes this as it runs.
+	sram []byte
+
+	// What's in the .sav file, so we only write it when the game has changed something.
+	saved []byte
+}
+
+// The .sav file for the ROM at 'romPath': the same name with a .sav extension.
+func sramPath(romPath string) string {
+	return strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sav"
+}
+
+// Fill in 'sram' from the .sav file for the ROM at 'romPath', if there is one.
+func LoadSRAM(romPath string, sram []byte) (out *SRAMFile) {
+	out = new(SRAMFile)
+	out.path = sramPath(romPath)
+	out.sram = sram
+	out.saved = make([]byte, len(sram))
+
+	data, err := ioutil.ReadFile(out.path)
+	if os.IsNotExist(err) {
+		// No game has been saved yet.  Don't write out the empty SRAM until the game
+		// changes it.
+		copy(out.saved, sram)
+		return
+	} else if nil != err {
+		log.Fatal(err)
+	}
+
+	if len(sram) != len(data) {
+		log.Println("Expected ", len(sram), " bytes of SRAM in ", out.path, ", got ", len(data))
+	}
+	copy(sram, data)
+	copy(out.saved, sram)
+	return
+}
+
+// Write the SRAM out to the .sav file if it's changed since the last time.  Errors are logged
+// rather than fatal, since this is also called when we're already going down.
+func (sf *SRAMFile) Flush() {
+	if bytes.Equal(sf.saved, sf.sram) {
+		return
+	}
+
+	// Write to a temporary file first so a crash mid-write can't destroy the old save.
+	tmpPath := sf.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, sf.sram, 0644); nil != err {
+		log.Println("Couldn't save SRAM: ", err)
+		return
+	}
+	if err := os.Rename(tmpPath, sf.path); nil != err {
+		log.Println("Couldn't save SRAM: ", err)
+		return
+	}
+
+	copy(sf.saved, sf.sram)
+}
@@ -1,7 +1,11 @@
 package mapper
 
 import (
+	"fmt"
+
+	"cpu"
 	"nesfile"
+	"savestate"
 )
 
 // Mappers handle reads and writes to the remappable addressable space of both the CPU and PPU.
@@ -17,6 +21,32 @@ type Mapper interface {
 
 	// Enable/disable debugging output.
 	Debug(on bool)
+
+	// Some mappers raise interrupts.  They do so on 'line'.
+	ConnectIRQ(line cpu.IRQLine)
+
+	// Save and load the mapper's registers and RAM for save states.  ROM isn't saved.  Bank
+	// mappings are saved as bank numbers and the mapped slices are rebuilt on load.
+	Save(w *savestate.Writer)
+	Load(r *savestate.Reader)
+
+	// The RAM at [0x6000 -> 0x7FFF].  On carts with a battery it holds saved games, and the
+	// caller persists it by reading and filling in the returned slice.
+	SRAM() []byte
+}
+
+// Mappers that need to know what the PPU is fetching, and when, implement this in addition to
+// Mapper.  Scanline counters and latch-based CHR switching are built on it.
+type PPUFetchObserver interface {
+	// Called for each fetch the PPU makes while rendering, just before it reads 'addr'.
+	// 'scanline' is 0 to 261, where 261 is the pre-render line, and 'dot' is 0 to 340.
+	PPUFetch(addr uint16, scanline int, dot int)
+}
+
+// Mappers that need to know when the CPU's cycles start implement this in addition to Mapper.
+type CPUCycleObserver interface {
+	// Called at the start of every CPU cycle, before the cycle's memory access.
+	CPUCycle()
 }
 
 // Every mapper should embed this.
@@ -28,9 +58,9 @@ type MapperAddressSpace struct {
 	// [0x4018 -> 0x5FFF] can be used by carts for various stuff,
 	// called "expansion ROM" in some places, and ignored by me for now.
 
-	// [0x6000 -> 0x7FFF] is SRAM, allocated but ignored for now, could be written out
-	// and read in at some point.
-	cpuSram [0x2000]byte
+	// [0x6000 -> 0x7FFF] is SRAM.  If it's battery-backed, the emulator saves it to disk.  It's
+	// 8K unless a NES 2.0 header says there's less, and mirrored through the window if so.
+	cpuSram []byte
 
 	// 0x8000 -> 0xFFFF is ROM.
 	// Most mappers map this address space in 16K blocks to on-cart ROM, so that's what
@@ -63,6 +93,13 @@ type MapperAddressSpace struct {
 	ppuNtBank0 [0x400]byte
 	ppuNtBank1 [0x400]byte
 
+	// Four-screen carts have another 2K of RAM for the other two nametables.
+	ppuNtBank2 [0x400]byte
+	ppuNtBank3 [0x400]byte
+
+	// Mappers that raise interrupts do so on this.
+	irq cpu.IRQLine
+
 	// Debug flag
 	debug bool
 }
@@ -71,6 +108,45 @@ func (mapper *MapperAddressSpace) Debug(val bool) {
 	mapper.debug = val
 }
 
+func (mapper *MapperAddressSpace) ConnectIRQ(line cpu.IRQLine) {
+	mapper.irq = line
+}
+
+func (mapper *MapperAddressSpace) SRAM() []byte {
+	return mapper.cpuSram
+}
+
+// Allocate the PRG-RAM at 0x6000 and, if there's no CHR-ROM, the CHR-RAM for the pattern tables.
+// Mapper implementations should use this function as part of their initialization.  Mappers that
+// bank CHR-RAM allocate both themselves.
+func (mas *MapperAddressSpace) setupRam(nesFile *nesfile.NesFile) {
+	mas.cpuSram = make([]byte, prgRamSize(nesFile))
+	if 0 == len(nesFile.ChrRom) {
+		chr := make([]byte, chrRamSize(nesFile))
+		mas.ppuPt0 = chr[0:0x1000]
+		mas.ppuPt1 = chr[0x1000:0x2000]
+		mas.ppuPtIsROM = false
+	}
+}
+
+// The PRG-RAM at 'addr', in [0x6000 -> 0x7FFF].  Carts without any read open bus, which we read
+// as 0.
+func (mapper *MapperAddressSpace) readPrgRam(addr uint16) uint8 {
+	if 0 == len(mapper.cpuSram) {
+		return 0
+	}
+	return mapper.cpuSram[int(addr & 0x1fff) % len(mapper.cpuSram)]
+}
+
+// Write the PRG-RAM at 'addr'.  Writes outside [0x6000 -> 0x7FFF], or to carts without PRG-RAM,
+// are ignored.
+func (mapper *MapperAddressSpace) writePrgRam(addr uint16, val uint8) {
+	if addr < 0x6000 || addr >= 0x8000 || 0 == len(mapper.cpuSram) {
+		return
+	}
+	mapper.cpuSram[int(addr & 0x1fff) % len(mapper.cpuSram)] = val
+}
+
 func (mapper *MapperAddressSpace) ReadCPU(addr uint16) (val uint8) {
 	if addr < 0x4018 {
 		panic("too-low address passed to ReadCPU")
@@ -79,7 +155,7 @@ func (mapper *MapperAddressSpace) ReadCPU(addr uint16) (val uint8) {
 		return 0
 	} else if addr < 0x8000 {
 		// 0x6000 -> 0x7fff is SRAM
-		return mapper.cpuSram[addr & 0x1fff]
+		return mapper.readPrgRam(addr)
 	} else if addr < 0xc000 {
 		// 0x8000 -> 0xbfff is the first 16k page of rom
 		return mapper.cpuPages[0][addr & 0x3fff]
@@ -120,21 +196,58 @@ func (mapper *MapperAddressSpace) WritePPU(addr uint16, val uint8) (cycles uint6
 // The nametable mirroring is specified in the iNES file header.  Mapper implementations
 // should use this function as part of their initialization.
 func (mas *MapperAddressSpace) setupNametables(nesFile *nesfile.NesFile) {
-	if nesfile.Horizontal == nesFile.Mirroring {
+	mas.setMirroring(nesFile.Mirroring)
+}
+
+// Map the nametables according to 'mirroring', one of the nesfile mirroring constants.  Mappers
+// that control mirroring themselves use this when it changes.
+func (mas *MapperAddressSpace) setMirroring(mirroring int) {
+	if nesfile.Horizontal == mirroring {
 		mas.ppuNts[0] = mas.ppuNtBank0[0:0x400]
 		mas.ppuNts[1] = mas.ppuNts[0]
 		mas.ppuNts[2] = mas.ppuNtBank1[0:0x400]
 		mas.ppuNts[3] = mas.ppuNts[2]
-	} else if nesfile.Vertical == nesFile.Mirroring {
+	} else if nesfile.Vertical == mirroring {
 		mas.ppuNts[0] = mas.ppuNtBank0[0:0x400]
 		mas.ppuNts[2] = mas.ppuNts[0]
 		mas.ppuNts[1] = mas.ppuNtBank1[0:0x400]
 		mas.ppuNts[3] = mas.ppuNts[1]
 	} else {
-		panic("I can't deal with the non-horizontal non-vertical mirroring yet")
+		// Four-screen: each nametable has its own RAM.
+		for i, bank := range mas.nametableBanks() {
+			mas.ppuNts[i] = bank
+		}
 	}
 }
 
+// The physical nametable pages, two in the PPU and two more on four-screen carts.
+func (mas *MapperAddressSpace) nametableBanks() [4][]byte {
+	return [4][]byte{mas.ppuNtBank0[:], mas.ppuNtBank1[:], mas.ppuNtBank2[:], mas.ppuNtBank3[:]}
+}
+
+// How much PRG-RAM, battery-backed or not, a cart has at 0x6000.  iNES carts all get 8K.  None of
+// our mappers bank PRG-RAM, so anything over 8K isn't reachable and isn't allocated.
+func prgRamSize(nesFile *nesfile.NesFile) int {
+	if nesfile.NES2 != nesFile.Format {
+		return 0x2000
+	}
+	size := nesFile.PrgRamSize + nesFile.PrgNvramSize
+	if size > 0x2000 {
+		size = 0x2000
+	}
+	return size
+}
+
+// How much CHR-RAM a cart without CHR-ROM has.  It's 8K unless a NES 2.0 header says there's more,
+// which only matters to mappers that can bank it.  The others only see the first 8K.
+func chrRamSize(nesFile *nesfile.NesFile) int {
+	size := nesFile.ChrRamSize + nesFile.ChrNvramSize
+	if size < 0x2000 {
+		size = 0x2000
+	}
+	return size
+}
+
 // An entry in the table of mappers.
 type MapperEntry struct {
 	ctor func(nesfile *nesfile.NesFile) (Mapper)
@@ -146,14 +259,33 @@ var mapperTable = map[int]*MapperEntry {
 	1: { NewMapper1 },
 	2: { NewMapper2 },
 	3: { NewMapper3 },
+	4: { NewMapper4 },
 }
 
-// Allocate the correct Mapper and return it.  Die if we can't provide the mapper.
-func GetMapper(nesFile *nesfile.NesFile) (out Mapper) {
-	if mapper, ok := mapperTable[nesFile.Mapper]; ok {
-		out = mapper.ctor(nesFile)
-	} else {
-		panic("TODO: Implement more mappers")
+// The cart uses a mapper that isn't implemented.
+type UnsupportedMapperError struct {
+	Mapper int
+	Submapper int
+}
+
+func (err *UnsupportedMapperError) Error() string {
+	return fmt.Sprintf("unsupported mapper %d (submapper %d)", err.Mapper, err.Submapper)
+}
+
+// Returns an *UnsupportedMapperError if we can't provide the cart's mapper, or nil if we can.
+// Cheap enough to check every ROM in a collection with.
+func Supported(nesFile *nesfile.NesFile) error {
+	if _, ok := mapperTable[nesFile.Mapper]; !ok {
+		return &UnsupportedMapperError{nesFile.Mapper, nesFile.Submapper}
+	}
+	return nil
+}
+
+// Allocate the correct Mapper and return it.  Returns an *UnsupportedMapperError if we can't
+// provide the mapper.
+func GetMapper(nesFile *nesfile.NesFile) (Mapper, error) {
+	if err := Supported(nesFile); nil != err {
+		return nil, err
 	}
-	return
+	return mapperTable[nesFile.Mapper].ctor(nesFile), nil
 }
@@ -15,13 +15,9 @@ func NewMapper0(nesFile *nesfile.NesFile) (Mapper) {
 	// If there is more than one page, map the last.  Should only be two.
 	out.cpuPages[1] = nesFile.PrgRom[len(nesFile.PrgRom) - 1]
 
-	// PPU mappings.
-	if 0 == len(nesFile.ChrRom) {
-		// No CHR-ROM so we assume it's CHR-RAM for pattern tables.
-		out.ppuPt0 = make([]byte, 0x1000)
-		out.ppuPt1 = make([]byte, 0x1000)
-		out.ppuPtIsROM = false
-	} else {
+	// PPU mappings.  With no CHR-ROM, setupRam makes CHR-RAM for the pattern tables.
+	out.MapperAddressSpace.setupRam(nesFile)
+	if 0 != len(nesFile.ChrRom) {
 		out.ppuPtIsROM = true
 		// There may be multiple CHR-ROM banks but there are no provisions for switching
 		// between them in this mapper, so we just point into the first bank.
@@ -35,6 +31,9 @@ func NewMapper0(nesFile *nesfile.NesFile) (Mapper) {
 }
 
 func (mapper *Mapper0) WriteCPU(addr uint16, val uint8) (cycles uint64) {
-	// No remapping with mapper 0.
+	// No remapping with mapper 0, but some carts have RAM at 0x6000.
+	if addr >= 0x6000 && addr < 0x8000 {
+		mapper.writePrgRam(addr, val)
+	}
 	return 0
 }
@@ -1,6 +1,9 @@
 package mapper
 
-import "nesfile"
+import (
+	"nesfile"
+	"savestate"
+)
 
 // Mapper1 works by writing a 5-bit value one bit at a time to any address in the PRG-ROM space.
 // On the 5th write, the value is interpreted depending on the address written to.
@@ -36,6 +39,17 @@ type Mapper1 struct {
 	// |                         3: fix last bank at $C000 and switch 16 KB bank at $8000)
 	// +----- CHR ROM bank mode (0: switch 8 KB at a time; 1: switch two separate 4 KB banks)
 	controlReg byte
+
+	// The banks currently mapped.  prgPages are the 16K PRG-ROM banks at 0x8000 and 0xc000,
+	// and chrPages the 4K halves of CHR-ROM banks at 0x0000 and 0x1000.  The mapped slices are
+	// built from these.
+	prgPages [2]int
+	chrPages [2]int
+
+	// The MMC1 ignores a write to its registers on the cycle after another one.  Read-modify-
+	// write instructions write twice in a row, and only the first write counts.
+	wroteThisCycle bool
+	wroteLastCycle bool
 }
 
 func NewMapper1(nesFile *nesfile.NesFile) (Mapper) {
@@ -47,28 +61,31 @@ func NewMapper1(nesFile *nesfile.NesFile) (Mapper) {
 
 	// Initial mappings:
 	// First PRG-ROM bank is loaded at 0x8000, last into 0xc000
-	out.cpuPages[0] = nesFile.PrgRom[0]
-	out.cpuPages[1] = nesFile.PrgRom[len(nesFile.PrgRom) - 1]
-
-	if 0 == len(nesFile.ChrRom) {
-		// There is no ROM so we'll make some RAM.
-		out.ppuPt0 = make([]byte, 0x1000)
-		out.ppuPt1 = make([]byte, 0x1000)
-		out.ppuPtIsROM = false
-	} else {
+	out.prgPages[0] = 0
+	out.prgPages[1] = len(nesFile.PrgRom) - 1
+
+	// If there is no CHR-ROM this makes some RAM.
+	out.MapperAddressSpace.setupRam(nesFile)
+	if 0 != len(nesFile.ChrRom) {
 		out.ppuPtIsROM = true
-		out.ppuPt0 = nesFile.ChrRom[0][0:0x1000]
-		out.ppuPt1 = nesFile.ChrRom[0][0x1000:0x2000]
+		out.chrPages[0] = 0
+		out.chrPages[1] = 1
 	}
+	out.applyBanks()
 
 	out.MapperAddressSpace.setupNametables(nesFile)
 	return out
 }
 
 func (mapper *Mapper1) WriteCPU(addr uint16, val uint8) (cycles uint64) {
-	// TODO: Actually write SRAM to disk occasionally.
 	if addr < 0x8000 {
-		mapper.cpuSram[addr & 0x1fff] = val
+		mapper.writePrgRam(addr, val)
+		return 0
+	}
+
+	consecutive := mapper.wroteLastCycle
+	mapper.wroteThisCycle = true
+	if consecutive {
 		return 0
 	}
 
@@ -115,6 +132,12 @@ func (mapper *Mapper1) WriteCPU(addr uint16, val uint8) (cycles uint64) {
 	return 0
 }
 
+// Implements CPUCycleObserver.
+func (mapper *Mapper1) CPUCycle() {
+	mapper.wroteLastCycle = mapper.wroteThisCycle
+	mapper.wroteThisCycle = false
+}
+
 func (mapper *Mapper1) controlRegChanged() {
 	switch (mapper.controlReg & 3) {
 	case 0:
@@ -154,30 +177,24 @@ func (mapper *Mapper1) remapChr0() {
 		// Remapping pages 8K at a time.  The low bit is dropped in this case.
 		romIndex := int(mapper.shiftReg >> 1) % len(mapper.chrRom)
 		// We're (re)mapping an 8k stretch to 0x0000 so both pt0 and pt1 are remapped.
-		mapper.ppuPt0 = mapper.chrRom[romIndex][0:0x1000]
-		mapper.ppuPt1 = mapper.chrRom[romIndex][0x1000:0x2000]
+		mapper.chrPages[0] = romIndex * 2
+		mapper.chrPages[1] = romIndex * 2 + 1
 	} else {
 		// Map 4k of data into 0x0000.  Only pt0 is remapped.
 		// ChrRom is 8k pages and the shift register refers to a 4k page selection,
 		// so we take the first or second half of the bank depending on shiftreg & 1
 		romIndex := int(mapper.shiftReg >> 1) % len(mapper.chrRom)
-		if 0 == (mapper.shiftReg & 1) {
-			mapper.ppuPt0 = mapper.chrRom[romIndex][0:0x1000]
-		} else {
-			mapper.ppuPt0 = mapper.chrRom[romIndex][0x1000:0x2000]
-		}
+		mapper.chrPages[0] = romIndex * 2 + int(mapper.shiftReg & 1)
 	}
+	mapper.applyBanks()
 }
 
 func (mapper *Mapper1) remapChr1() {
 	// This register is only used if we switch 4K CHR-ROM banks.
 	if 0x10 == (0x10 & mapper.controlReg) {
 		romIndex := int(mapper.shiftReg >> 1) % len(mapper.chrRom)
-		if 0 == (mapper.shiftReg & 1) {
-			mapper.ppuPt1 = mapper.chrRom[romIndex][0:0x1000]
-		} else {
-			mapper.ppuPt1 = mapper.chrRom[romIndex][0x1000:0x2000]
-		}
+		mapper.chrPages[1] = romIndex * 2 + int(mapper.shiftReg & 1)
+		mapper.applyBanks()
 	}
 }
 
@@ -190,15 +207,73 @@ func (mapper *Mapper1) remapPrg() {
 
 	if 0 == prgRomBankMode || 1 == prgRomBankMode {
 		// Ignore the lowest bit of shiftReg, map 32kb to 0xc000
-		bankIndex := mapper.shiftReg >> 1
+		bankIndex := int(mapper.shiftReg >> 1)
 		// mapper.prgRom pages are 16k so we map two adjacent pages.
-		mapper.cpuPages[0] = mapper.prgRom[bankIndex * 2]
-		mapper.cpuPages[1] = mapper.prgRom[bankIndex * 2 + 1]
+		mapper.prgPages[0] = bankIndex * 2
+		mapper.prgPages[1] = bankIndex * 2 + 1
 	} else if 2 == prgRomBankMode {
 		// Leave bank at 0x8000 alone and switch 0xc000
-		mapper.cpuPages[1] = mapper.prgRom[int(mapper.shiftReg) % len(mapper.prgRom)]
+		mapper.prgPages[1] = int(mapper.shiftReg) % len(mapper.prgRom)
 	} else {
 		// Leave bank at 0xc000 alone and switch 0x8000
-		mapper.cpuPages[0] = mapper.prgRom[int(mapper.shiftReg) % len(mapper.prgRom)]
+		mapper.prgPages[0] = int(mapper.shiftReg) % len(mapper.prgRom)
+	}
+	mapper.applyBanks()
+}
+
+// Map the banks in prgPages and chrPages.
+func (mapper *Mapper1) applyBanks() {
+	mapper.cpuPages[0] = mapper.prgRom[mapper.prgPages[0]]
+	mapper.cpuPages[1] = mapper.prgRom[mapper.prgPages[1]]
+
+	// Without CHR-ROM, the pattern tables are RAM and never remapped.
+	if 0 == len(mapper.chrRom) {
+		return
+	}
+
+	for i, page := range mapper.chrPages {
+		// Each 8K bank of CHR-ROM has two 4K pages.
+		bank := mapper.chrRom[page / 2]
+		half := bank[(page & 1) * 0x1000 : (page & 1) * 0x1000 + 0x1000]
+		if 0 == i {
+			mapper.ppuPt0 = half
+		} else {
+			mapper.ppuPt1 = half
+		}
+	}
+}
+
+func (mapper *Mapper1) Save(w *savestate.Writer) {
+	mapper.state(w)
+}
+
+func (mapper *Mapper1) Load(r *savestate.Reader) {
+	mapper.state(r)
+}
+
+func (mapper *Mapper1) state(s savestate.Stream) {
+	mapper.MapperAddressSpace.state(s)
+	s.Uint8(&mapper.shiftReg)
+	s.Uint8(&mapper.whichBit)
+	s.Uint8(&mapper.controlReg)
+
+	// A state can be saved between the two writes of a read-modify-write instruction, so which
+	// cycles wrote is saved too.
+	s.Bool(&mapper.wroteThisCycle)
+	s.Bool(&mapper.wroteLastCycle)
+	for i := range mapper.prgPages {
+		s.Int(&mapper.prgPages[i])
+		s.Int(&mapper.chrPages[i])
+		s.Check(mapper.prgPages[i] >= 0 && mapper.prgPages[i] < len(mapper.prgRom),
+			"PRG-ROM bank")
+
+		// CHR-ROM pages are 4K, half a bank.  Without CHR-ROM they aren't used.
+		s.Check(0 == len(mapper.chrRom) ||
+			(mapper.chrPages[i] >= 0 && mapper.chrPages[i] < 2 * len(mapper.chrRom)),
+			"CHR-ROM page")
+	}
+
+	if s.Loading() && nil == s.Err() {
+		mapper.applyBanks()
 	}
 }
@@ -1,12 +1,18 @@
 package mapper
 
-import "nesfile"
+import (
+	"nesfile"
+	"savestate"
+)
 
 type Mapper2 struct {
 	MapperAddressSpace
 
 	// The PRG-ROM banks in the cart.
 	prgRom [][]byte
+
+	// Which bank is mapped at 0x8000.
+	prgPage int
 }
 
 func NewMapper2(nesFile *nesfile.NesFile) (Mapper) {
@@ -21,16 +27,38 @@ func NewMapper2(nesFile *nesfile.NesFile) (Mapper) {
 	out.cpuPages[1] = nesFile.PrgRom[len(nesFile.PrgRom) - 1]
 
 	// THere shouldn't be any CHR-ROM, so pattern tables will be RAM.
-	out.ppuPt0 = make([]byte, 0x1000)
-	out.ppuPt1 = make([]byte, 0x1000)
-	out.ppuPtIsROM = false
+	out.MapperAddressSpace.setupRam(nesFile)
 
 	out.MapperAddressSpace.setupNametables(nesFile)
 	return out
 }
 
 func (mapper *Mapper2) WriteCPU(addr uint16, val uint8) (cycles uint64) {
-	// Any write swaps in a 16k ROM bank at 0x8000
-	mapper.cpuPages[0] = mapper.prgRom[val]
+	if addr < 0x8000 {
+		mapper.writePrgRam(addr, val)
+		return 0
+	}
+
+	// Any write to ROM swaps in a 16k ROM bank at 0x8000
+	mapper.prgPage = int(val)
+	mapper.cpuPages[0] = mapper.prgRom[mapper.prgPage]
 	return 0
 }
+
+func (mapper *Mapper2) Save(w *savestate.Writer) {
+	mapper.state(w)
+}
+
+func (mapper *Mapper2) Load(r *savestate.Reader) {
+	mapper.state(r)
+}
+
+func (mapper *Mapper2) state(s savestate.Stream) {
+	mapper.MapperAddressSpace.state(s)
+	s.Int(&mapper.prgPage)
+	s.Check(mapper.prgPage >= 0 && mapper.prgPage < len(mapper.prgRom), "PRG-ROM bank")
+
+	if s.Loading() && nil == s.Err() {
+		mapper.cpuPages[0] = mapper.prgRom[mapper.prgPage]
+	}
+}
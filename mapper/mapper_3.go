@@ -1,12 +1,18 @@
 package mapper
 
-import "nesfile"
+import (
+	"nesfile"
+	"savestate"
+)
 
 type Mapper3 struct {
 	MapperAddressSpace
 
 	// The CHR-ROM banks in the cart.
 	chrRom [][]byte
+
+	// Which bank is mapped at 0x0000.
+	chrPage int
 }
 
 func NewMapper3(nesFile *nesfile.NesFile) (Mapper) {
@@ -25,13 +31,44 @@ func NewMapper3(nesFile *nesfile.NesFile) (Mapper) {
 	// CHR-ROM can be remapped.
 	out.chrRom = nesFile.ChrRom
 
+	out.MapperAddressSpace.setupRam(nesFile)
+
 	out.MapperAddressSpace.setupNametables(nesFile)
 	return out
 }
 
 func (mapper *Mapper3) WriteCPU(addr uint16, val uint8) (cycles uint64) {
-	// Any write swaps in an 8K VROM bank at 0x0000.  Only the lower 2 bits are used.
-	mapper.ppuPt0 = mapper.chrRom[val & 3][0:0x1000]
-	mapper.ppuPt1 = mapper.chrRom[val & 3][0x1000:0x2000]
+	if addr < 0x8000 {
+		mapper.writePrgRam(addr, val)
+		return 0
+	}
+
+	// Any write to ROM swaps in an 8K VROM bank at 0x0000.  Only the lower 2 bits are used.
+	mapper.chrPage = int(val & 3)
+	mapper.applyBanks()
 	return 0
 }
+
+// Map the bank in chrPage.
+func (mapper *Mapper3) applyBanks() {
+	mapper.ppuPt0 = mapper.chrRom[mapper.chrPage][0:0x1000]
+	mapper.ppuPt1 = mapper.chrRom[mapper.chrPage][0x1000:0x2000]
+}
+
+func (mapper *Mapper3) Save(w *savestate.Writer) {
+	mapper.state(w)
+}
+
+func (mapper *Mapper3) Load(r *savestate.Reader) {
+	mapper.state(r)
+}
+
+func (mapper *Mapper3) state(s savestate.Stream) {
+	mapper.MapperAddressSpace.state(s)
+	s.Int(&mapper.chrPage)
+	s.Check(mapper.chrPage >= 0 && mapper.chrPage < len(mapper.chrRom), "CHR-ROM bank")
+
+	if s.Loading() && nil == s.Err() {
+		mapper.applyBanks()
+	}
+}
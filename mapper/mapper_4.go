@@ -0,0 +1,312 @@
+package mapper
+
+import (
+	"cpu"
+	"nesfile"
+	"savestate"
+)
+
+// Mapper4 is the MMC3.  It maps PRG-ROM in 8K banks and CHR in 1K and 2K banks, controls
+// mirroring, and has a counter that raises an IRQ after a programmable number of scanlines.
+//
+// For details see http://wiki.nesdev.com/w/index.php/MMC3
+type Mapper4 struct {
+	MapperAddressSpace
+
+	// All of the PRG-ROM, as one slice.  The MMC3 banks at a finer granularity than the 16K
+	// banks in the iNES file.
+	prg []byte
+
+	// All of the CHR-ROM (or CHR-RAM if there's no ROM), as one slice.
+	chr []byte
+
+	// The 8K banks mapped at 0x8000, 0xa000, 0xc000 and 0xe000.
+	prgBanks [4][]byte
+
+	// The 1K banks mapped at 0x0000, 0x0400, ..., 0x1c00.
+	chrBanks [8][]byte
+
+	// Written at 0x8000 (even addresses).
+	//
+	// 7  bit  0
+	// ---- ----
+	// CPMx xRRR
+	// |||   |||
+	// |||   +++- Which bank register the next write to 0x8001 updates
+	// ||+------- Nothing on the MMC3
+	// |+-------- PRG ROM bank mode (0: 0x8000 swappable, 0xc000 fixed to second-last bank;
+	// |                             1: 0xc000 swappable, 0x8000 fixed to second-last bank)
+	// +--------- CHR A12 inversion (0: two 2K banks at 0x0000 and four 1K banks at 0x1000;
+	//                               1: two 2K banks at 0x1000 and four 1K banks at 0x0000)
+	bankSelect byte
+
+	// The bank registers, written at 0x8001 (odd addresses).  R0 and R1 select 2K CHR banks,
+	// R2 to R5 select 1K CHR banks and R6 and R7 select 8K PRG banks.
+	bankRegs [8]byte
+
+	// Written at 0xa001.  PRG-RAM at 0x6000 can be disabled or write protected.
+	prgRamEnabled bool
+	prgRamWriteProtected bool
+
+	// The scanline counter is reloaded with this value, written at 0xc000.
+	irqLatch byte
+
+	// Counts down scanlines.  An IRQ is raised when it hits 0.
+	irqCounter byte
+
+	// Set by a write to 0xc001.  The counter is reloaded on the next clock.
+	irqReload bool
+
+	// Written at 0xe000 (disable) and 0xe001 (enable).
+	irqEnabled bool
+
+	// The scanline counter is clocked when PPU address line A12 rises.  The MMC3 filters out
+	// rises that happen soon after A12 fell, which would otherwise happen when background and
+	// sprite fetches interleave.  This is the scanline and dot of the last fetch with A12 high.
+	a12HighScanline int
+	a12HighDot int
+
+	// Mirroring can't be changed by the game if the cart has four-screen VRAM.
+	fourScreen bool
+}
+
+// How many dots A12 has to be low before a rise clocks the scanline counter.  The nametable and
+// attribute fetches between background tiles are shorter than this, but the sprite fetch period
+// at the end of each line is longer.
+const mapper4A12LowDots = 10
+
+// There are 341 dots on each of 262 scanlines.
+const (
+	dotsPerScanline = 341
+	dotsPerFrame = 262 * dotsPerScanline
+)
+
+func NewMapper4(nesFile *nesfile.NesFile) (Mapper) {
+	out := new(Mapper4)
+
+	for _, bank := range nesFile.PrgRom {
+		out.prg = append(out.prg, bank...)
+	}
+
+	if 0 == len(nesFile.ChrRom) {
+		out.chr = make([]byte, chrRamSize(nesFile))
+		out.ppuPtIsROM = false
+	} else {
+		for _, bank := range nesFile.ChrRom {
+			out.chr = append(out.chr, bank...)
+		}
+		out.ppuPtIsROM = true
+	}
+
+	// The CHR-RAM is banked like CHR-ROM, so setupRam isn't used.
+	out.cpuSram = make([]byte, prgRamSize(nesFile))
+	out.prgRamEnabled = true
+	out.fourScreen = (nesfile.FourScreen == nesFile.Mirroring)
+
+	// Initially R0-R7 are 0, so this maps the first banks everywhere, and the last banks at
+	// their fixed locations.
+	out.remap()
+
+	out.MapperAddressSpace.setupNametables(nesFile)
+	return out
+}
+
+func (mapper *Mapper4) ReadCPU(addr uint16) (val uint8) {
+	if addr >= 0x8000 {
+		return mapper.prgBanks[(addr >> 13) & 3][addr & 0x1fff]
+	}
+
+	if addr >= 0x6000 && !mapper.prgRamEnabled {
+		// Open bus.
+		return 0
+	}
+	return mapper.MapperAddressSpace.ReadCPU(addr)
+}
+
+func (mapper *Mapper4) WriteCPU(addr uint16, val uint8) (cycles uint64) {
+	if addr < 0x6000 {
+		return 0
+	}
+
+	if addr < 0x8000 {
+		if mapper.prgRamEnabled && !mapper.prgRamWriteProtected {
+			mapper.writePrgRam(addr, val)
+		}
+		return 0
+	}
+
+	// The registers are selected by the top 3 address bits and whether the address is even or
+	// odd.
+	even := 0 == (addr & 1)
+
+	switch addr & 0xe000 {
+	case 0x8000:
+		if even {
+			mapper.bankSelect = val
+		} else {
+			mapper.bankRegs[mapper.bankSelect & 7] = val
+		}
+		mapper.remap()
+	case 0xa000:
+		if even {
+			if !mapper.fourScreen {
+				if 0 == (val & 1) {
+					mapper.setMirroring(nesfile.Vertical)
+				} else {
+					mapper.setMirroring(nesfile.Horizontal)
+				}
+			}
+		} else {
+			mapper.prgRamEnabled = 0x80 == (val & 0x80)
+			mapper.prgRamWriteProtected = 0x40 == (val & 0x40)
+		}
+	case 0xc000:
+		if even {
+			mapper.irqLatch = val
+		} else {
+			mapper.irqCounter = 0
+			mapper.irqReload = true
+		}
+	case 0xe000:
+		if even {
+			// Disabling also acknowledges any pending interrupt.
+			mapper.irqEnabled = false
+			if nil != mapper.irq {
+				mapper.irq.ReleaseIRQ(cpu.IRQ_MAPPER)
+			}
+		} else {
+			mapper.irqEnabled = true
+		}
+	}
+
+	return 0
+}
+
+func (mapper *Mapper4) ReadPPU(addr uint16) (val uint8) {
+	if addr < 0x2000 {
+		return mapper.chrBanks[addr >> 10][addr & 0x3ff]
+	}
+	return mapper.MapperAddressSpace.ReadPPU(addr)
+}
+
+func (mapper *Mapper4) WritePPU(addr uint16, val uint8) (cycles uint64) {
+	if addr < 0x2000 {
+		if !mapper.ppuPtIsROM {
+			mapper.chrBanks[addr >> 10][addr & 0x3ff] = val
+		}
+		return 0
+	}
+	return mapper.MapperAddressSpace.WritePPU(addr, val)
+}
+
+// Get the 'index'-th 8K bank of PRG-ROM.  Negative indexes count from the end.
+func (mapper *Mapper4) prgBank(index int) []byte {
+	count := len(mapper.prg) / 0x2000
+	index %= count
+	if index < 0 {
+		index += count
+	}
+	return mapper.prg[index * 0x2000 : (index + 1) * 0x2000]
+}
+
+// Get the 'index'-th 1K bank of CHR.
+func (mapper *Mapper4) chrBank(index int) []byte {
+	index %= len(mapper.chr) / 0x400
+	return mapper.chr[index * 0x400 : (index + 1) * 0x400]
+}
+
+// Apply the bank registers and bank select modes to the PRG and CHR mappings.
+func (mapper *Mapper4) remap() {
+	// PRG mapping.  0xa000 is always R7 and 0xe000 is always the last bank.
+	if 0 == (mapper.bankSelect & 0x40) {
+		mapper.prgBanks[0] = mapper.prgBank(int(mapper.bankRegs[6] & 0x3f))
+		mapper.prgBanks[2] = mapper.prgBank(-2)
+	} else {
+		mapper.prgBanks[0] = mapper.prgBank(-2)
+		mapper.prgBanks[2] = mapper.prgBank(int(mapper.bankRegs[6] & 0x3f))
+	}
+	mapper.prgBanks[1] = mapper.prgBank(int(mapper.bankRegs[7] & 0x3f))
+	mapper.prgBanks[3] = mapper.prgBank(-1)
+
+	// CHR mapping.  The 2K banks ignore the low bit of R0 and R1.  With A12 inversion the 2K
+	// banks are at 0x1000 instead of 0x0000, and the 1K banks the other way around.
+	var twoK, oneK int
+	if 0 == (mapper.bankSelect & 0x80) {
+		twoK, oneK = 0, 4
+	} else {
+		twoK, oneK = 4, 0
+	}
+
+	mapper.chrBanks[twoK + 0] = mapper.chrBank(int(mapper.bankRegs[0] & 0xfe))
+	mapper.chrBanks[twoK + 1] = mapper.chrBank(int(mapper.bankRegs[0] | 1))
+	mapper.chrBanks[twoK + 2] = mapper.chrBank(int(mapper.bankRegs[1] & 0xfe))
+	mapper.chrBanks[twoK + 3] = mapper.chrBank(int(mapper.bankRegs[1] | 1))
+	for i := 0; i < 4; i++ {
+		mapper.chrBanks[oneK + i] = mapper.chrBank(int(mapper.bankRegs[2 + i]))
+	}
+}
+
+// Implements PPUFetchObserver.  Watches PPU address line A12 (0x1000) and clocks the scanline
+// counter when it rises after being low for a while.  With the usual setup of background tiles at
+// 0x0000 and sprites at 0x1000, this happens once per scanline when sprite fetching starts.
+func (mapper *Mapper4) PPUFetch(addr uint16, scanline int, dot int) {
+	if 0 == (addr & 0x1000) {
+		return
+	}
+
+	// How long since A12 was last high?  Rendering wraps from the pre-render line to line 0.
+	lowDots := (scanline - mapper.a12HighScanline) * dotsPerScanline + dot - mapper.a12HighDot
+	if lowDots < 0 {
+		lowDots += dotsPerFrame
+	}
+
+	if lowDots >= mapper4A12LowDots {
+		mapper.clockScanlineCounter()
+	}
+	mapper.a12HighScanline = scanline
+	mapper.a12HighDot = dot
+}
+
+// Called once per scanline (roughly).  Raises an IRQ when the counter hits 0.
+func (mapper *Mapper4) clockScanlineCounter() {
+	if 0 == mapper.irqCounter || mapper.irqReload {
+		mapper.irqCounter = mapper.irqLatch
+		mapper.irqReload = false
+	} else {
+		mapper.irqCounter--
+	}
+
+	if 0 == mapper.irqCounter && mapper.irqEnabled && nil != mapper.irq {
+		mapper.irq.AssertIRQ(cpu.IRQ_MAPPER)
+	}
+}
+
+func (mapper *Mapper4) Save(w *savestate.Writer) {
+	mapper.state(w)
+}
+
+func (mapper *Mapper4) Load(r *savestate.Reader) {
+	mapper.state(r)
+}
+
+func (mapper *Mapper4) state(s savestate.Stream) {
+	mapper.MapperAddressSpace.state(s)
+	if !mapper.ppuPtIsROM {
+		s.Bytes(mapper.chr)
+	}
+
+	s.Uint8(&mapper.bankSelect)
+	s.Bytes(mapper.bankRegs[:])
+	s.Bool(&mapper.prgRamEnabled)
+	s.Bool(&mapper.prgRamWriteProtected)
+	s.Uint8(&mapper.irqLatch)
+	s.Uint8(&mapper.irqCounter)
+	s.Bool(&mapper.irqReload)
+	s.Bool(&mapper.irqEnabled)
+	s.Int(&mapper.a12HighScanline)
+	s.Int(&mapper.a12HighDot)
+
+	if s.Loading() {
+		mapper.remap()
+	}
+}
@@ -0,0 +1,123 @@
+package mapper
+
+import (
+	"testing"
+
+	"cpu"
+	"nesfile"
+)
+
+// Records which sources are asserting the IRQ line.
+type testIRQLine map[cpu.IRQSource]bool
+
+func (line testIRQLine) AssertIRQ(source cpu.IRQSource) {
+	line[source] = true
+}
+
+func (line testIRQLine) ReleaseIRQ(source cpu.IRQSource) {
+	delete(line, source)
+}
+
+func newTestMapper4(t *testing.T) (*Mapper4, testIRQLine) {
+	nesFile := &nesfile.NesFile{
+		PrgRom: [][]byte{make([]byte, 0x4000), make([]byte, 0x4000)},
+		ChrRom: [][]byte{make([]byte, 0x2000)},
+	}
+	mapper, ok := NewMapper4(nesFile).(*Mapper4)
+	if !ok {
+		t.Fatal("NewMapper4 didn't make a Mapper4")
+	}
+	line := make(testIRQLine)
+	mapper.ConnectIRQ(line)
+	return mapper, line
+}
+
+// Feed 'mapper' the fetches the PPU makes while rendering 'scanline', with the background at
+// 0x0000 and sprites at 0x1000.  Each fetch takes 2 dots: background tiles are a nametable,
+// attribute and two pattern fetches, and sprites are two garbage nametable fetches and two
+// pattern fetches.
+func fetchScanline(mapper *Mapper4, scanline int) {
+	for dot := 1; dot <= 340; dot += 2 {
+		var addr uint16
+		switch {
+		case dot <= 256 || (dot >= 321 && dot <= 336):
+			addr = [4]uint16{0x2000, 0x23c0, 0x0000, 0x0008}[(dot - 1) / 2 % 4]
+		case dot <= 320:
+			addr = [4]uint16{0x2000, 0x2000, 0x1000, 0x1008}[(dot - 257) / 2 % 4]
+		default:
+			addr = 0x2000
+		}
+		mapper.PPUFetch(addr, scanline, dot)
+	}
+}
+
+func TestMapper4ScanlineCounter(t *testing.T) {
+	mapper, line := newTestMapper4(t)
+	mapper.WriteCPU(0xc000, 3)
+	mapper.WriteCPU(0xc001, 0)
+	mapper.WriteCPU(0xe001, 0)
+
+	// The first clock reloads the counter, then each line counts down one, and the interrupt
+	// is raised when it reaches 0.
+	for scanline, expected := range []uint8{3, 2, 1, 0} {
+		if line[cpu.IRQ_MAPPER] {
+			t.Fatalf("Interrupt raised before line %d", scanline)
+		}
+		fetchScanline(mapper, scanline)
+		if expected != mapper.irqCounter {
+			t.Fatalf("Expected the counter to be %d after line %d, got %d", expected, scanline,
+				 mapper.irqCounter)
+		}
+	}
+	if !line[cpu.IRQ_MAPPER] {
+		t.Fatal("Expected an interrupt when the counter reached 0")
+	}
+
+	// Writing 0xe000 acknowledges the interrupt and disables more.
+	mapper.WriteCPU(0xe000, 0)
+	if line[cpu.IRQ_MAPPER] {
+		t.Fatal("0xe000 didn't acknowledge the interrupt")
+	}
+
+	// At 0 the counter is reloaded from the latch, whatever it was when the count started.
+	mapper.WriteCPU(0xc000, 5)
+	fetchScanline(mapper, 4)
+	if 5 != mapper.irqCounter {
+		t.Fatalf("Expected the counter reloaded with 5, got %d", mapper.irqCounter)
+	}
+	fetchScanline(mapper, 5)
+	if 4 != mapper.irqCounter {
+		t.Fatalf("Expected the counter to be 4, got %d", mapper.irqCounter)
+	}
+
+	// Writing 0xc001 reloads it on the next clock even when it isn't 0.
+	mapper.WriteCPU(0xc000, 7)
+	mapper.WriteCPU(0xc001, 0)
+	fetchScanline(mapper, 6)
+	if 7 != mapper.irqCounter {
+		t.Fatalf("Expected 0xc001 to reload the counter with 7, got %d", mapper.irqCounter)
+	}
+
+	// The counter keeps running while interrupts are disabled, but doesn't raise them.
+	for scanline := 7; scanline < 14; scanline++ {
+		fetchScanline(mapper, scanline)
+	}
+	if 0 != mapper.irqCounter || line[cpu.IRQ_MAPPER] {
+		t.Errorf("Expected the counter at 0 with no interrupt, got %d and %v", mapper.irqCounter,
+			 line[cpu.IRQ_MAPPER])
+	}
+}
+
+// The counter is clocked once per line across the wrap from the pre-render line to line 0.
+func TestMapper4PreRenderLine(t *testing.T) {
+	mapper, _ := newTestMapper4(t)
+	mapper.WriteCPU(0xc000, 10)
+	fetchScanline(mapper, 239)
+	fetchScanline(mapper, 261)
+	fetchScanline(mapper, 0)
+
+	// One to reload it, then two to count down.
+	if 8 != mapper.irqCounter {
+		t.Errorf("Expected 3 clocks, the counter is %d", mapper.irqCounter)
+	}
+}
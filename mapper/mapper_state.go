@@ -0,0 +1,43 @@
+package mapper
+
+import "savestate"
+
+// Mappers with no registers of their own can use these as-is.  The others save the
+// MapperAddressSpace state, then their own.
+func (mas *MapperAddressSpace) Save(w *savestate.Writer) {
+	mas.state(w)
+}
+
+func (mas *MapperAddressSpace) Load(r *savestate.Reader) {
+	mas.state(r)
+}
+
+// Save or load the RAM in the cart address space and the nametable mirroring.
+func (mas *MapperAddressSpace) state(s savestate.Stream) {
+	s.Bytes(mas.cpuSram)
+	banks := mas.nametableBanks()
+	for _, bank := range banks {
+		s.Bytes(bank)
+	}
+
+	// The mirroring is saved as which physical nametable bank each nametable is mapped to.
+	for i := range mas.ppuNts {
+		var bank uint8
+		for j := range banks {
+			if &mas.ppuNts[i][0] == &banks[j][0] {
+				bank = uint8(j)
+			}
+		}
+
+		s.Uint8(&bank)
+		s.Check(int(bank) < len(banks), "nametable bank")
+
+		mas.ppuNts[i] = banks[bank & 3]
+	}
+
+	// CHR-RAM is saved too.  Mappers that bank CHR-RAM keep it themselves and leave these nil.
+	if !mas.ppuPtIsROM && nil != mas.ppuPt0 {
+		s.Bytes(mas.ppuPt0)
+		s.Bytes(mas.ppuPt1)
+	}
+}
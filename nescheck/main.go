@@ -0,0 +1,78 @@
+package main
+
+// Checks the headers of .nes files against the database of known carts, and reports where they
+// disagree.  Zip files with several ROMs in them have each one checked.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"nesfile"
+)
+
+// Check the ROM 'member' of the file at 'path' and print what's wrong with it.  Returns whether
+// it's fine.
+func check(path string, member string) bool {
+	name := path
+	if "" != member {
+		name = path + ":" + member
+	}
+
+	// Patches next to the ROM aren't applied; it's the dump that's being checked.
+	nesFile, err := nesfile.ReadPatchedNesFile(path, member, "")
+	var multiple *nesfile.MultipleRomsError
+	if errors.As(err, &multiple) {
+		ok := true
+		for _, member := range multiple.Names {
+			ok = check(path, member) && ok
+		}
+		return ok
+	}
+	if nil != err {
+		fmt.Printf("%s: %v\n", name, err)
+		return false
+	}
+
+	if nil == nesfile.DefaultDatabase.Lookup(nesFile) {
+		fmt.Printf("%s: not in the database (CRC32 %08X)\n", name, nesFile.CRC32)
+		return true
+	}
+	for _, mismatch := range nesFile.HeaderMismatches {
+		fmt.Printf("%s: %v\n", name, mismatch)
+	}
+	return 0 == len(nesFile.HeaderMismatches)
+}
+
+func main() {
+	dbPath := flag.String("db", "", "nes20db.xml to use as well as the built-in database")
+	flag.Parse()
+	if 0 == flag.NArg() {
+		fmt.Println("Usage: ", os.Args[0], " [-db nes20db.xml] somefile.nes...")
+		fmt.Println("Reports where the headers of the ROMs disagree with the database of known carts.")
+		fmt.Println("Exits with status 1 if any do, or can't be read.")
+		return
+	}
+
+	if "" != *dbPath {
+		if err := nesfile.DefaultDatabase.LoadXMLFile(*dbPath); nil != err {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	// Carts that aren't in the database can't be checked, but that's not their fault.
+	if 0 == nesfile.DefaultDatabase.Len() {
+		fmt.Fprintln(os.Stderr, "The database is empty, so no ROM can be checked.  Pass nes20db.xml")
+		fmt.Fprintln(os.Stderr, "with -db, or build a subset of it in with nesdbgen.")
+	}
+
+	ok := true
+	for _, path := range flag.Args() {
+		ok = check(path, "") && ok
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
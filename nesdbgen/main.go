@@ -0,0 +1,169 @@
+package main
+
+// Makes nesfile/nesdb.xml from the NES 2.0 header database (nes20db.xml).  The whole file is large,
+// so only the games asked for are kept: those matching the ROMs given, and those whose names
+// contain one of the -name strings.  Each game is copied as it is, with the comment naming it.
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"nesfile"
+)
+
+// What a game is matched on.  nes20db.xml has the hashes in upper case.
+type gameHashes struct {
+	Rom struct {
+		CRC32 string `xml:"crc32,attr"`
+		SHA1 string `xml:"sha1,attr"`
+	} `xml:"rom"`
+}
+
+const header = `<?xml version="1.0" encoding="UTF-8"?>
+<!--
+	The carts the emulator knows the right header for, in the format of the NES 2.0 header
+	database (nes20db.xml, from the NES 2.0 page on the nesdev wiki).  Each game is keyed by the
+	CRC32 and SHA-1 of its PRG-ROM followed by its CHR-ROM:
+
+	<game>
+		<rom crc32="0123ABCD" sha1="..."/>
+		<console region="0"/>
+		<pcb mapper="1" submapper="0" mirroring="H" battery="1"/>
+	</game>
+
+	Region is 0 for NTSC, 1 for PAL, 2 for either and 3 for Dendy.  Mirroring is H, V or 4 for
+	four-screen; anything else means the mapper controls it.  nesdbgen fills this with the games
+	from the upstream file that match given ROMs or names.  The whole upstream file can also be
+	dropped in here, or loaded at run time with nescheck's -db flag.
+-->
+<nes20db>
+`
+
+// The hashes of the ROMs at 'paths', as they're written in nes20db.xml.
+func romHashes(paths []string) (map[string]bool, error) {
+	hashes := make(map[string]bool)
+	for _, path := range paths {
+		nesFile, err := nesfile.ReadPatchedNesFile(path, "", "")
+		if nil != err {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		hashes[fmt.Sprintf("%08X", nesFile.CRC32)] = true
+		hashes[fmt.Sprintf("%X", nesFile.SHA1)] = true
+	}
+	return hashes, nil
+}
+
+// The games in 'db' that match 'hashes' or 'names', each with the comment before it.
+func subset(db []byte, hashes map[string]bool, names []string) ([]byte, int, error) {
+	var out bytes.Buffer
+	count := 0
+	decoder := xml.NewDecoder(bytes.NewReader(db))
+
+	// The comment naming the next game, and where it starts.
+	comment, start := "", int64(0)
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if io.EOF == err {
+			break
+		} else if nil != err {
+			return nil, 0, err
+		}
+
+		switch token := token.(type) {
+		case xml.Comment:
+			comment, start = strings.TrimSpace(string(token)), offset
+		case xml.StartElement:
+			if "game" != token.Name.Local {
+				comment = ""
+				continue
+			}
+			if "" == comment {
+				start = offset
+			}
+			var game gameHashes
+			if err := decoder.DecodeElement(&game, &token); nil != err {
+				return nil, 0, err
+			}
+
+			keep := hashes[strings.ToUpper(game.Rom.CRC32)] ||
+				hashes[strings.ToUpper(game.Rom.SHA1)]
+			lower := strings.ToLower(comment)
+			for _, name := range names {
+				if "" != lower && strings.Contains(lower, strings.ToLower(name)) {
+					keep = true
+				}
+			}
+			if keep {
+				out.WriteString("\t")
+				out.Write(bytes.TrimSpace(db[start:decoder.InputOffset()]))
+				out.WriteString("\n")
+				count++
+			}
+			comment = ""
+		}
+	}
+	return out.Bytes(), count, nil
+}
+
+// A flag that can be given more than once.
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+func main() {
+	var names stringList
+	flag.Var(&names, "name", "keep games whose names contain this (can be repeated)")
+	outPath := flag.String("o", "nesfile/nesdb.xml", "where to write the database")
+	flag.Parse()
+	if 0 == flag.NArg() {
+		fmt.Println("Usage: ", os.Args[0],
+			    " [-o nesdb.xml] [-name name...] nes20db.xml somefile.nes...")
+		fmt.Println("Writes the games in nes20db.xml that match the ROMs or names to the")
+		fmt.Println("built-in database, replacing what's there.")
+		return
+	}
+
+	db, err := ioutil.ReadFile(flag.Arg(0))
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	hashes, err := romHashes(flag.Args()[1:])
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	games, count, err := subset(db, hashes, names)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	out := append([]byte(header), games...)
+	out = append(out, "</nes20db>\n"...)
+
+	// Check the result parses before replacing anything.
+	if err := nesfile.NewDatabase().LoadXML(bytes.NewReader(out)); nil != err {
+		fmt.Println("Generated a broken database:", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(*outPath, out, 0644); nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Wrote", count, "games to", *outPath)
+}
@@ -0,0 +1,120 @@
+package main
+
+// Disassembles a PRG-ROM bank of a .nes file.  The reset, NMI and IRQ vectors, and the targets of
+// jumps and branches within the bank, are labelled.
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"disasm"
+	"nesfile"
+)
+
+// The vectors are in the last 6 bytes of the last bank.
+var vectorNames = []struct {
+	offset int
+	name string
+}{
+	{0x3ffa, "NMI"},
+	{0x3ffc, "RESET"},
+	{0x3ffe, "IRQ"},
+}
+
+func main() {
+	if len(os.Args) < 2 || len(os.Args) > 3 {
+		fmt.Println("Usage: ", os.Args[0], " somefile.nes <bank>")
+		fmt.Println("Disassembles the 16K PRG-ROM bank 'bank', or the last bank (which holds the")
+		fmt.Println("vectors) if none is given.")
+		return
+	}
+
+	nesFile, err := nesfile.ReadNesFile(os.Args[1])
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	banks := len(nesFile.PrgRom)
+
+	bank := banks - 1
+	if 3 == len(os.Args) {
+		var err error
+		bank, err = strconv.Atoi(os.Args[2])
+		if nil != err || bank < 0 || bank >= banks {
+			fmt.Println("There are", banks, "banks, numbered from 0")
+			os.Exit(1)
+		}
+	}
+
+	// Where the bank is in memory depends on the mapper, but almost all of them have the last
+	// bank fixed at 0xC000, and switch the others in at 0x8000.
+	start := uint16(0x8000)
+	if bank == banks - 1 {
+		start = 0xc000
+	}
+
+	insts := disasm.Disassemble(nesFile.PrgRom[bank], start)
+	labels := makeLabels(nesFile.PrgRom[banks - 1], insts)
+
+	for _, inst := range insts {
+		if label, ok := labels[inst.Addr]; ok {
+			fmt.Printf("%s:\n", label)
+		}
+
+		marker := " "
+		if inst.Undocumented {
+			marker = "*"
+		}
+		fmt.Printf("%04X  %-9s%s%s\n", inst.Addr, inst.HexBytes(), marker, inst.Format(labels))
+	}
+
+	// Vectors that point outside this bank are worth knowing about too.
+	fmt.Println()
+	for _, vector := range vectorNames {
+		fmt.Printf("; %-5s = $%04X\n", vector.name, readVector(nesFile.PrgRom[banks - 1], vector.offset))
+	}
+}
+
+// Read the vector at 'offset' in the last bank.
+func readVector(lastBank []byte, offset int) uint16 {
+	return uint16(lastBank[offset]) | (uint16(lastBank[offset + 1]) << 8)
+}
+
+// Label the vectors, and the targets of jumps and branches in 'insts'.  Only targets that are the
+// start of an instruction in 'insts' get a label.
+func makeLabels(lastBank []byte, insts []disasm.Instruction) (labels map[uint16]string) {
+	starts := make(map[uint16]bool)
+	for _, inst := range insts {
+		starts[inst.Addr] = true
+	}
+
+	labels = make(map[uint16]string)
+	for _, vector := range vectorNames {
+		addr := readVector(lastBank, vector.offset)
+		if starts[addr] {
+			// Several vectors can point at the same handler.
+			if existing, ok := labels[addr]; ok {
+				labels[addr] = existing + "_" + vector.name
+			} else {
+				labels[addr] = vector.name
+			}
+		}
+	}
+
+	var targets []int
+	for _, inst := range insts {
+		if inst.HasTarget && starts[inst.Target] {
+			targets = append(targets, int(inst.Target))
+		}
+	}
+	sort.Ints(targets)
+	for _, target := range targets {
+		addr := uint16(target)
+		if _, ok := labels[addr]; !ok {
+			labels[addr] = fmt.Sprintf("L%04X", addr)
+		}
+	}
+	return
+}
@@ -1,12 +1,15 @@
 package nesfile
 
-// This package parses the iNES file format into a NesFile structure.
+// This package parses the iNES file format, and its NES 2.0 extension, into a NesFile structure.
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
 )
 
 // See the PPU package for details on what mirroring means.
@@ -21,6 +24,10 @@ const (
 
 // The dump of the NES file.
 type NesFile struct {
+	// Which format the header was in.  For iNES files, the fields only NES 2.0 describes have
+	// the values usual for iNES carts.
+	Format Format
+
 	// Some details of the PPU address space mapping are specified in the header.
 	Mirroring int
 
@@ -28,87 +35,157 @@ type NesFile struct {
 	SramEnabled bool
 
 	// What address mapping hardware is in the cart?  Each set of address mapping
-	// hardware has a number that identifies it.
+	// hardware has a number that identifies it.  NES 2.0 mapper numbers are 12 bits, and iNES
+	// ones 8.
 	Mapper int
 
+	// NES 2.0 tells variants of some mappers apart with a submapper number.
+	Submapper int
+
 	// Each bank of PrgRom is 16K.
 	PrgRom [][]byte
 
 	// Each bank of ChrRom is 8K.
 	ChrRom [][]byte
+
+	// The sizes of the cart's RAM in bytes.  The NVRAM is battery-backed.  The mappers put up to
+	// 8K of PRG-RAM and PRG-NVRAM together at 0x6000, which is saved to disk if SramEnabled.
+	PrgRamSize int
+	PrgNvramSize int
+	ChrRamSize int
+	ChrNvramSize int
+
+	// Whether a 512-byte trainer came before the PRG-ROM.  It isn't kept.
+	HasTrainer bool
+
+	// The TV system the game was made for.  The emulator always runs with NTSC timing; this is
+	// for tools.
+	Timing Timing
+
+	// What the cart plugs into.  Vs. System games give the PPU and hardware they need, and other
+	// extended consoles their type.
+	ConsoleType ConsoleType
+	VsPPUType int
+	VsHardwareType int
+	ExtendedConsoleType int
+
+	// How many extra ROMs follow CHR-ROM.  They aren't kept.
+	MiscRoms int
+
+	// The controller or other device the game expects to be plugged in.  0 is unspecified, and 1
+	// standard controllers.
+	ExpansionDevice int
+
+	// Hashes of the PRG-ROM followed by the CHR-ROM, as they were in the file.  The database of
+	// known carts is keyed by these.
+	CRC32 uint32
+	SHA1 [sha1.Size]byte
+
+	// Where the header disagreed with the database and was corrected.  Empty if it agreed or the
+	// cart isn't in the database.
+	HeaderMismatches []Mismatch
 }
 
-// Read from 'file' into 'target' and die on error.
-func readAndCheck(file *os.File, target []byte) {
-	bytesRead, err := file.Read(target)
+// The file doesn't start with "NES\x1a", so it isn't an iNES file.
+var ErrBadMagic = errors.New("not an iNES file: first 4 bytes not magic value")
 
-	if len(target) != bytesRead {
-		fmt.Println("Wanted to read ", len(target), " bytes, got ", bytesRead)
-	}
+// The file ended before the end of a part the header says is there.
+type TruncatedError struct {
+	// "header", "trainer", "PRG-ROM" or "CHR-ROM".
+	Part string
 
-	if nil != err {
-		log.Fatal(err)
-	}
+	// How many bytes the part should have, and how many there were.
+	Expected int
+	Got int
 }
 
-// Read the provided file in iNES format and output it.  Dies if the file is malformed.
-func ReadNesFile(fileName string) (nesFile *NesFile) {
-	nesFile = new(NesFile)
+func (err *TruncatedError) Error() string {
+	return fmt.Sprintf("%s truncated: wanted %d bytes, got %d", err.Part, err.Expected, err.Got)
+}
 
-	// Open the provided file.
-	file, err := os.Open(fileName);
+// The header describes something that can't be right.
+type HeaderError struct {
+	Msg string
+}
+
+func (err *HeaderError) Error() string {
+	return "bad header: " + err.Msg
+}
+
+// Read 'size' bytes of 'part' of the file from 'r'.  Returns a TruncatedError if it's cut short.
+// The sizes come from the header, so the buffer grows as data arrives rather than being allocated
+// up front: a corrupt header can't make us allocate much more than the file holds.
+func readPart(r io.Reader, part string, size int) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(size)))
 	if nil != err {
-		log.Fatal(err)
+		return nil, err
+	}
+	if len(data) < size {
+		return nil, &TruncatedError{part, size, len(data)}
 	}
+	return data, nil
+}
+
+// Read an iNES or NES 2.0 file from 'r'.  Besides I/O errors, returns ErrBadMagic, a
+// *HeaderError or a *TruncatedError if the file is malformed.  It succeeds whatever the mapper;
+// mapper.Supported returns an *UnsupportedMapperError if the emulator can't run it.  The header is
+// trusted as it is; DefaultDatabase.Correct fixes known bad ones.
+func LoadNesFile(r io.Reader) (*NesFile, error) {
+	nesFile := new(NesFile)
 
 	// Read the full 16-byte header.
-	fileHeader := make([]byte, 16)
-	readAndCheck(file, fileHeader)
+	fileHeader, err := readPart(r, "header", 16)
+	if nil != err {
+		return nil, err
+	}
 
 	// Look for the magic value in the header: 'NES\x1a'
 	canonicalHeader := []byte{'N', 'E', 'S', '\x1a'}
 	if !bytes.Equal(canonicalHeader, fileHeader[0:4]) {
-		panic("error reading iNES file: first 4 bytes not magic value")
+		return nil, ErrBadMagic
 	}
 
-	// Read mirroring information from the header.  Look in the PPU package for details on what
-	// this means.
-	if 0 == (fileHeader[6] & 1) {
-		nesFile.Mirroring = Horizontal
-	} else {
-		nesFile.Mirroring = Vertical
+	prgSize, chrSize, err := nesFile.parseHeader(fileHeader)
+	if nil != err {
+		return nil, err
 	}
-
-	if 0 != fileHeader[6] & 8 {
-		nesFile.Mirroring = FourScreen
+	if 0 == prgSize {
+		return nil, &HeaderError{"no PRG-ROM"}
 	}
 
-	// Not impl'd for now but might as well set it correctly.
-	nesFile.SramEnabled = (2 == (fileHeader[6] & 2))
-
-	// The mapper is a byte whose nibbles are in the high 4 bits of each ROM control byte.
-	nesFile.Mapper = int((0xf & (fileHeader[6]>>4)) | (0xf0 & fileHeader[7]))
-
 	// I would be surprised if anyone ever had this, but it can happen.
-	hasTrainer := 0 != (fileHeader[6] & (1<<2))
-	if hasTrainer {
+	if nesFile.HasTrainer {
 		// There's a 512-byte thing to skip over.
-		ignoredTrainer := make([]byte, 512)
-		readAndCheck(file, ignoredTrainer)
+		if _, err := readPart(r, "trainer", 512); nil != err {
+			return nil, err
+		}
 	}
 
 	// Read in the ROM banks.
-	nesFile.PrgRom = make([][]byte, int(fileHeader[4]))
-	for i := range nesFile.PrgRom {
-		nesFile.PrgRom[i] = make([]byte, 1 << 14)
-		readAndCheck(file, nesFile.PrgRom[i])
+	prg, err := readPart(r, "PRG-ROM", prgSize)
+	if nil != err {
+		return nil, err
 	}
+	nesFile.PrgRom = splitBanks(prg, 1 << 14)
 
-	nesFile.ChrRom = make([][]byte, int(fileHeader[5]))
-	for i := range nesFile.ChrRom {
-		nesFile.ChrRom[i] = make([]byte, 1 << 13)
-		readAndCheck(file, nesFile.ChrRom[i])
+	chr, err := readPart(r, "CHR-ROM", chrSize)
+	if nil != err {
+		return nil, err
 	}
+	nesFile.ChrRom = splitBanks(chr, 1 << 13)
+
+	nesFile.CRC32 = crc32.Update(crc32.ChecksumIEEE(prg), crc32.IEEETable, chr)
+	hash := sha1.New()
+	hash.Write(prg)
+	hash.Write(chr)
+	copy(nesFile.SHA1[:], hash.Sum(nil))
+
+	return nesFile, nil
+}
 
-	return
+// Read the iNES or NES 2.0 file at 'fileName', which may be zipped or gzipped, and apply the patch
+// next to it if there is one.  A zip file must hold only one .nes file; use ReadNesFileMember to
+// pick one of several.  See LoadNesFile.
+func ReadNesFile(fileName string) (*NesFile, error) {
+	return ReadNesFileMember(fileName, "")
 }
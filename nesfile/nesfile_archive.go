@@ -0,0 +1,151 @@
+package nesfile
+
+// ROMs are often kept zipped or gzipped.  Both are recognised by their magic values, and the .nes
+// file inside is read as usual.
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Magic values at the start of zip and gzip files.
+var (
+	zipMagic = []byte{'P', 'K', 3, 4}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// The zip file has no .nes file in it.
+var ErrNoRom = errors.New("no .nes file in archive")
+
+// The zip file has several .nes files in it, and none was picked.
+type MultipleRomsError struct {
+	// The names of the .nes files, in the order they're stored.
+	Names []string
+}
+
+func (err *MultipleRomsError) Error() string {
+	return fmt.Sprintf("%d .nes files in archive: %s", len(err.Names), strings.Join(err.Names, ", "))
+}
+
+// Read the ROM at 'fileName'.  If it's a zip file, 'member' is the name of the .nes file in it to
+// read, or "" to read the only one.  Raw and gzipped files ignore 'member'.  If there's a patch
+// next to the ROM (see FindPatch), it's applied.  If the cart is in DefaultDatabase, the header is
+// corrected from it.
+func ReadNesFileMember(fileName string, member string) (*NesFile, error) {
+	return ReadPatchedNesFile(fileName, member, FindPatch(RomPath(fileName, member)))
+}
+
+// Read the ROM like ReadNesFileMember, but apply the IPS, UPS or BPS patch at 'patchPath' instead
+// of looking for one.  If 'patchPath' is "", it isn't patched.
+func ReadPatchedNesFile(fileName string, member string, patchPath string) (*NesFile, error) {
+	image, err := readImage(fileName, member)
+	if nil != err {
+		return nil, err
+	}
+
+	if "" != patchPath {
+		patch, err := ioutil.ReadFile(patchPath)
+		if nil != err {
+			return nil, err
+		}
+		image, err = ApplyPatch(image, patch)
+		if nil != err {
+			return nil, fmt.Errorf("%s: %w", patchPath, err)
+		}
+	}
+
+	nesFile, err := LoadNesFile(bytes.NewReader(image))
+	if nil != err {
+		return nil, err
+	}
+	nesFile.HeaderMismatches = DefaultDatabase.Correct(nesFile)
+	return nesFile, nil
+}
+
+// The path the ROM 'member' of 'fileName' is known by, which saves and patches are named after.
+// That's 'fileName' itself, unless 'member' was picked from a zip file, when it's the member's name
+// in the zip file's directory.
+func RomPath(fileName string, member string) string {
+	if "" == member {
+		return fileName
+	}
+	return filepath.Join(filepath.Dir(fileName), path.Base(member))
+}
+
+// Read the whole iNES file 'member' of the file at 'fileName', unzipping it if need be.
+func readImage(fileName string, member string) ([]byte, error) {
+	file, err := os.Open(fileName)
+	if nil != err {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if nil != err {
+		return nil, err
+	}
+
+	// Files too short to hold the magic fall through to LoadNesFile, which says they're truncated.
+	magic := make([]byte, len(zipMagic))
+	n, _ := file.ReadAt(magic, 0)
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		archive, err := zip.NewReader(file, info.Size())
+		if nil != err {
+			return nil, err
+		}
+		return readZipMember(archive, member)
+
+	case bytes.HasPrefix(magic, gzipMagic):
+		unzipped, err := gzip.NewReader(file)
+		if nil != err {
+			return nil, err
+		}
+		defer unzipped.Close()
+		return ioutil.ReadAll(unzipped)
+	}
+
+	return ioutil.ReadAll(file)
+}
+
+// The names of the .nes files in 'archive'.
+func zipRomNames(archive *zip.Reader) (names []string) {
+	for _, f := range archive.File {
+		if strings.EqualFold(".nes", path.Ext(f.Name)) && !f.FileInfo().IsDir() {
+			names = append(names, f.Name)
+		}
+	}
+	return
+}
+
+// Read the .nes file named 'member' from 'archive', or the only one if 'member' is "".
+func readZipMember(archive *zip.Reader, member string) ([]byte, error) {
+	if "" == member {
+		names := zipRomNames(archive)
+		switch len(names) {
+		case 0:
+			return nil, ErrNoRom
+		case 1:
+			member = names[0]
+		default:
+			return nil, &MultipleRomsError{names}
+		}
+	}
+
+	file, err := archive.Open(member)
+	if nil != err {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
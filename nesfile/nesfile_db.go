@@ -0,0 +1,232 @@
+package nesfile
+
+// Lots of dumps have the wrong mapper or mirroring in their headers.  The database of known carts,
+// keyed by the hashes of their ROMs, says what the header should have been.
+
+import (
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The built-in database.  See nesdb.xml.
+//go:embed nesdb.xml
+var builtinDatabase string
+
+// The database ReadNesFile corrects headers from.  It starts with the built-in carts; more can be
+// added with LoadXML.
+var DefaultDatabase = NewDatabase()
+
+func init() {
+	if err := DefaultDatabase.LoadXML(strings.NewReader(builtinDatabase)); nil != err {
+		panic(fmt.Sprint("built-in ROM database is broken: ", err))
+	}
+}
+
+// What the database knows about a cart.
+type RomInfo struct {
+	Mapper int
+	Submapper int
+
+	// One of the nesfile mirroring constants, or -1 if the mapper controls it and the header's
+	// value doesn't matter.
+	Mirroring int
+
+	Battery bool
+	Timing Timing
+}
+
+// Known carts, keyed by the hashes of their PRG-ROM followed by their CHR-ROM.
+type Database struct {
+	byCRC32 map[uint32]*RomInfo
+	bySHA1 map[[sha1.Size]byte]*RomInfo
+}
+
+// A field of the header that disagrees with the database.
+type Mismatch struct {
+	Field string
+	Header string
+	Database string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s: header says %s, database says %s", m.Field, m.Header, m.Database)
+}
+
+func NewDatabase() *Database {
+	return &Database{make(map[uint32]*RomInfo), make(map[[sha1.Size]byte]*RomInfo)}
+}
+
+// How many carts the database knows, counting each hash separately.
+func (db *Database) Len() int {
+	return len(db.byCRC32) + len(db.bySHA1)
+}
+
+// Add a cart to the database.  Either hash may be zero if it isn't known.
+func (db *Database) Add(crc32 uint32, sha [sha1.Size]byte, info *RomInfo) {
+	if 0 != crc32 {
+		db.byCRC32[crc32] = info
+	}
+	if [sha1.Size]byte{} != sha {
+		db.bySHA1[sha] = info
+	}
+}
+
+// The parts of nes20db.xml we use.
+type xmlDatabase struct {
+	Games []struct {
+		Rom struct {
+			CRC32 string `xml:"crc32,attr"`
+			SHA1 string `xml:"sha1,attr"`
+		} `xml:"rom"`
+		Console struct {
+			Region int `xml:"region,attr"`
+		} `xml:"console"`
+		Pcb struct {
+			Mapper int `xml:"mapper,attr"`
+			Submapper int `xml:"submapper,attr"`
+			Mirroring string `xml:"mirroring,attr"`
+			Battery int `xml:"battery,attr"`
+		} `xml:"pcb"`
+	} `xml:"game"`
+}
+
+// Add the carts in 'r', which is in the format of the NES 2.0 header database (nes20db.xml).
+func (db *Database) LoadXML(r io.Reader) error {
+	var parsed xmlDatabase
+	if err := xml.NewDecoder(r).Decode(&parsed); nil != err {
+		return err
+	}
+
+	for i, game := range parsed.Games {
+		info := &RomInfo{Mapper: game.Pcb.Mapper, Submapper: game.Pcb.Submapper,
+			Battery: 0 != game.Pcb.Battery, Timing: Timing(game.Console.Region & 3)}
+		switch game.Pcb.Mirroring {
+		case "H":
+			info.Mirroring = Horizontal
+		case "V":
+			info.Mirroring = Vertical
+		case "4":
+			info.Mirroring = FourScreen
+		default:
+			info.Mirroring = -1
+		}
+
+		var crc uint64
+		if "" != game.Rom.CRC32 {
+			var err error
+			crc, err = strconv.ParseUint(game.Rom.CRC32, 16, 32)
+			if nil != err {
+				return fmt.Errorf("game %d: bad CRC32 %q", i, game.Rom.CRC32)
+			}
+		}
+
+		var sha [sha1.Size]byte
+		if "" != game.Rom.SHA1 {
+			decoded, err := hex.DecodeString(game.Rom.SHA1)
+			if nil != err || sha1.Size != len(decoded) {
+				return fmt.Errorf("game %d: bad SHA-1 %q", i, game.Rom.SHA1)
+			}
+			copy(sha[:], decoded)
+		}
+
+		db.Add(uint32(crc), sha, info)
+	}
+	return nil
+}
+
+// Add the carts in the nes20db.xml file at 'path'.
+func (db *Database) LoadXMLFile(path string) error {
+	file, err := os.Open(path)
+	if nil != err {
+		return err
+	}
+	defer file.Close()
+	return db.LoadXML(file)
+}
+
+// What the database knows about the cart in 'nesFile', or nil if it isn't there.  The SHA-1 is
+// looked for first, as CRC32s of different carts can clash.
+func (db *Database) Lookup(nesFile *NesFile) *RomInfo {
+	if info, ok := db.bySHA1[nesFile.SHA1]; ok {
+		return info
+	}
+	return db.byCRC32[nesFile.CRC32]
+}
+
+// Where the header of 'nesFile' disagrees with the database.  Empty if it agrees, or if the cart
+// isn't in the database.
+func (db *Database) Check(nesFile *NesFile) (mismatches []Mismatch) {
+	info := db.Lookup(nesFile)
+	if nil == info {
+		return
+	}
+
+	check := func(field string, header, database interface{}) {
+		if header != database {
+			mismatches = append(mismatches,
+					    Mismatch{field, fmt.Sprint(header), fmt.Sprint(database)})
+		}
+	}
+	check("mapper", nesFile.Mapper, info.Mapper)
+	check("submapper", nesFile.Submapper, info.Submapper)
+	if info.Mirroring >= 0 {
+		check("mirroring", mirroringName(nesFile.Mirroring), mirroringName(info.Mirroring))
+	}
+	check("battery", nesFile.SramEnabled, info.Battery)
+	check("timing", timingName(nesFile.Timing), timingName(info.Timing))
+	return
+}
+
+// Correct the header of 'nesFile' from the database, and return where it was wrong.
+func (db *Database) Correct(nesFile *NesFile) []Mismatch {
+	mismatches := db.Check(nesFile)
+	if 0 == len(mismatches) {
+		return nil
+	}
+
+	info := db.Lookup(nesFile)
+	nesFile.Mapper = info.Mapper
+	nesFile.Submapper = info.Submapper
+	if info.Mirroring >= 0 {
+		nesFile.Mirroring = info.Mirroring
+	}
+	nesFile.Timing = info.Timing
+
+	// The PRG-RAM is battery-backed or not as the database says.
+	if info.Battery != nesFile.SramEnabled {
+		nesFile.SramEnabled = info.Battery
+		size := nesFile.PrgRamSize + nesFile.PrgNvramSize
+		if 0 == size {
+			size = 0x2000
+		}
+		if info.Battery {
+			nesFile.PrgRamSize, nesFile.PrgNvramSize = 0, size
+		} else {
+			nesFile.PrgRamSize, nesFile.PrgNvramSize = size, 0
+		}
+	}
+	return mismatches
+}
+
+func mirroringName(mirroring int) string {
+	switch mirroring {
+	case Horizontal:
+		return "horizontal"
+	case Vertical:
+		return "vertical"
+	case FourScreen:
+		return "four-screen"
+	}
+	return strconv.Itoa(mirroring)
+}
+
+func timingName(timing Timing) string {
+	return [...]string{"NTSC", "PAL", "multi-region", "Dendy"}[timing & 3]
+}
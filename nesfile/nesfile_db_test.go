@@ -0,0 +1,136 @@
+package nesfile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDatabase(t *testing.T) {
+	nesFile, err := LoadNesFile(bytes.NewReader(makeNesFile(1)))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// The database says it's a PAL MMC1 cart with a battery.
+	db := NewDatabase()
+	xml := fmt.Sprintf(`<nes20db><game>
+		<rom crc32="%08X" sha1="%X"/>
+		<console region="1"/>
+		<pcb mapper="1" submapper="0" mirroring="V" battery="1"/>
+	</game></nes20db>`, nesFile.CRC32, nesFile.SHA1)
+	if err := db.LoadXML(strings.NewReader(xml)); nil != err {
+		t.Fatal(err)
+	}
+
+	expected := []Mismatch{
+		{"mapper", "0", "1"},
+		{"mirroring", "horizontal", "vertical"},
+		{"battery", "false", "true"},
+		{"timing", "NTSC", "PAL"},
+	}
+	if mismatches := db.Correct(nesFile); !reflect.DeepEqual(expected, mismatches) {
+		t.Errorf("Expected %v, got %v", expected, mismatches)
+	}
+	if 1 != nesFile.Mapper || Vertical != nesFile.Mirroring || !nesFile.SramEnabled ||
+			0x2000 != nesFile.PrgNvramSize || PAL != nesFile.Timing {
+		t.Errorf("Corrected wrongly: %+v", nesFile)
+	}
+	if mismatches := db.Check(nesFile); 0 != len(mismatches) {
+		t.Error("Still disagrees after correcting:", mismatches)
+	}
+
+	// Carts that aren't there are left alone.
+	nesFile.CRC32++
+	nesFile.SHA1[0]++
+	if nil != db.Lookup(nesFile) {
+		t.Error("Found a cart that isn't in the database")
+	}
+}
+
+// ReadNesFile corrects headers from DefaultDatabase, which starts with the built-in carts.
+func TestDefaultDatabase(t *testing.T) {
+	if err := NewDatabase().LoadXML(strings.NewReader(builtinDatabase)); nil != err {
+		t.Fatal("The built-in database doesn't parse:", err)
+	}
+
+	romPath := filepath.Join(t.TempDir(), "game.nes")
+	if err := ioutil.WriteFile(romPath, makeNesFile(3), 0644); nil != err {
+		t.Fatal(err)
+	}
+	nesFile, err := LoadNesFile(bytes.NewReader(makeNesFile(3)))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// Put the cart in the default database as a CNROM, and take it out again afterwards.
+	info := &RomInfo{Mapper: 3, Mirroring: Vertical}
+	DefaultDatabase.Add(nesFile.CRC32, nesFile.SHA1, info)
+	defer delete(DefaultDatabase.byCRC32, nesFile.CRC32)
+	defer delete(DefaultDatabase.bySHA1, nesFile.SHA1)
+
+	nesFile, err = ReadNesFile(romPath)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 3 != nesFile.Mapper || Vertical != nesFile.Mirroring || 2 != len(nesFile.HeaderMismatches) {
+		t.Errorf("Expected the header to be corrected, got %+v", nesFile)
+	}
+}
+
+// Real dumps aren't checked in; put some in testdata, or in the NES_TEST_ROMS directory.  Each one
+// the built-in database knows is given a bad header, which ReadNesFile should put right.
+func TestBuiltinDatabase(t *testing.T) {
+	var paths []string
+	for _, dir := range []string{os.Getenv("NES_TEST_ROMS"), "testdata"} {
+		if "" != dir {
+			found, _ := filepath.Glob(filepath.Join(dir, "*.nes"))
+			paths = append(paths, found...)
+		}
+	}
+
+	tested := 0
+	for _, path := range paths {
+		file, err := ioutil.ReadFile(path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		nesFile, err := LoadNesFile(bytes.NewReader(file))
+		if nil != err {
+			t.Fatalf("%s: %v", path, err)
+		}
+		info := DefaultDatabase.Lookup(nesFile)
+		if nil == info {
+			continue
+		}
+
+		// Swap the mapper for another and flip the mirroring.  The hashes don't cover the header.
+		file[6] ^= 0x11
+		badPath := filepath.Join(t.TempDir(), filepath.Base(path))
+		if err := ioutil.WriteFile(badPath, file, 0644); nil != err {
+			t.Fatal(err)
+		}
+		nesFile, err = ReadNesFile(badPath)
+		if nil != err {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if 0 == len(nesFile.HeaderMismatches) {
+			t.Errorf("%s: the bad header wasn't noticed", path)
+		}
+		if mismatches := DefaultDatabase.Check(nesFile); 0 != len(mismatches) {
+			t.Errorf("%s: the header wasn't corrected: %v", path, mismatches)
+		}
+		if info.Mapper != nesFile.Mapper {
+			t.Errorf("%s: expected mapper %d, got %d", path, info.Mapper, nesFile.Mapper)
+		}
+		tested++
+	}
+	if 0 == tested {
+		t.Skip("No dumps the built-in database knows found in testdata or NES_TEST_ROMS")
+	}
+}
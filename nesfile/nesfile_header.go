@@ -0,0 +1,209 @@
+package nesfile
+
+// Parsing of the 16-byte header, in both the iNES and NES 2.0 formats.  See
+// http://wiki.nesdev.com/w/index.php/INES and http://wiki.nesdev.com/w/index.php/NES_2.0
+
+import "fmt"
+
+// Which format a file's header is in.
+type Format int
+
+const (
+	// The original iNES format.  Only the first 8 bytes mean anything reliable.
+	INES Format = iota
+
+	// NES 2.0 is backwards compatible with iNES, and uses the rest of the header to describe the
+	// cart fully.
+	NES2
+)
+
+// The CPU and PPU timing the game expects.
+type Timing int
+
+const (
+	NTSC Timing = iota
+	PAL
+
+	// Works with either.
+	MultiRegion
+
+	// The Dendy, a Famiclone with PAL-like video and NTSC-like CPU timing.
+	Dendy
+)
+
+// What the cart plugs into.
+type ConsoleType int
+
+const (
+	NES ConsoleType = iota
+	VsSystem
+	Playchoice10
+
+	// Something else, given by ExtendedConsoleType.
+	Extended
+)
+
+// NES 2.0 sizes aren't allowed to be larger than this many bytes.  Anything bigger is a corrupt
+// header rather than a real cart.
+const maxRomSize = 1 << 30
+
+// Is 'header' in NES 2.0 format?
+func isNES2(header []byte) bool {
+	return 0x08 == (header[7] & 0x0c)
+}
+
+// Fill in 'nesFile' from 'header'.  Returns the sizes of PRG-ROM and CHR-ROM in bytes.
+func (nesFile *NesFile) parseHeader(header []byte) (prgSize, chrSize int, err error) {
+	// Read mirroring information from the header.  Look in the PPU package for details on what
+	// this means.
+	if 0 == (header[6] & 1) {
+		nesFile.Mirroring = Horizontal
+	} else {
+		nesFile.Mirroring = Vertical
+	}
+
+	if 0 != header[6] & 8 {
+		nesFile.Mirroring = FourScreen
+	}
+
+	nesFile.SramEnabled = (2 == (header[6] & 2))
+	nesFile.HasTrainer = 0 != (header[6] & 4)
+
+	// The low nibble of the mapper number is in the high 4 bits of byte 6.
+	nesFile.Mapper = int(header[6] >> 4)
+
+	if isNES2(header) {
+		nesFile.Format = NES2
+		prgSize, chrSize, err = nesFile.parseNES2(header)
+		return
+	}
+
+	nesFile.Format = INES
+	nesFile.parseINES(header)
+	return 0x4000 * int(header[4]), 0x2000 * int(header[5]), nil
+}
+
+// Fill in the parts of 'nesFile' that iNES headers describe differently.  The rest gets the usual
+// values for an iNES cart.
+func (nesFile *NesFile) parseINES(header []byte) {
+	// iNES doesn't give RAM sizes.  Carts have 8K of PRG-RAM, and 8K of CHR-RAM if there's no
+	// CHR-ROM.
+	if nesFile.SramEnabled {
+		nesFile.PrgNvramSize = 0x2000
+	} else {
+		nesFile.PrgRamSize = 0x2000
+	}
+	if 0 == header[5] {
+		nesFile.ChrRamSize = 0x2000
+	}
+
+	// Old dumping tools wrote junk like "DiskDude!" into bytes 7-15, which were unused at the
+	// time.  If the end of the header isn't zeroes, byte 7 can't be trusted either.
+	junk := false
+	for _, b := range header[12:16] {
+		if 0 != b {
+			junk = true
+		}
+	}
+	if junk {
+		return
+	}
+
+	// The high nibble of the mapper number.
+	nesFile.Mapper |= int(header[7] & 0xf0)
+
+	if 0 != (header[7] & 1) {
+		nesFile.ConsoleType = VsSystem
+	} else if 0 != (header[7] & 2) {
+		nesFile.ConsoleType = Playchoice10
+	}
+
+	if 0 != (header[9] & 1) {
+		nesFile.Timing = PAL
+	}
+}
+
+// Fill in 'nesFile' from a NES 2.0 header, and return the ROM sizes.
+func (nesFile *NesFile) parseNES2(header []byte) (prgSize, chrSize int, err error) {
+	nesFile.Mapper |= int(header[7] & 0xf0) | (int(header[8] & 0x0f) << 8)
+	nesFile.Submapper = int(header[8] >> 4)
+
+	prgSize, err = nes2RomSize("PRG-ROM", header[4], header[9] & 0x0f, 0x4000)
+	if nil != err {
+		return 0, 0, err
+	}
+	chrSize, err = nes2RomSize("CHR-ROM", header[5], header[9] >> 4, 0x2000)
+	if nil != err {
+		return 0, 0, err
+	}
+
+	nesFile.PrgRamSize = nes2RamSize(header[10] & 0x0f)
+	nesFile.PrgNvramSize = nes2RamSize(header[10] >> 4)
+	nesFile.ChrRamSize = nes2RamSize(header[11] & 0x0f)
+	nesFile.ChrNvramSize = nes2RamSize(header[11] >> 4)
+
+	// Battery-backed RAM is saved whether or not the battery bit is set.
+	if 0 != nesFile.PrgNvramSize {
+		nesFile.SramEnabled = true
+	}
+
+	nesFile.Timing = Timing(header[12] & 3)
+	nesFile.ConsoleType = ConsoleType(header[7] & 3)
+	switch(nesFile.ConsoleType) {
+	case VsSystem:
+		nesFile.VsPPUType = int(header[13] & 0x0f)
+		nesFile.VsHardwareType = int(header[13] >> 4)
+	case Extended:
+		nesFile.ExtendedConsoleType = int(header[13] & 0x0f)
+	}
+
+	nesFile.MiscRoms = int(header[14] & 3)
+	nesFile.ExpansionDevice = int(header[15] & 0x3f)
+	return
+}
+
+// The size in bytes of ROM 'name', whose size is given by 'lsb' and the nibble 'msb'.  Normally
+// that's a count of 'unit'-sized banks.  If 'msb' is 0xF, 'lsb' is EEEEEEMM instead, and the size
+// is 2^E * (MM * 2 + 1) bytes.
+func nes2RomSize(name string, lsb uint8, msb uint8, unit int) (int, error) {
+	if 0xf != msb {
+		return unit * ((int(msb) << 8) | int(lsb)), nil
+	}
+
+	exponent := uint(lsb >> 2)
+	multiplier := int(lsb & 3) * 2 + 1
+	if exponent >= 30 || (multiplier << exponent) > maxRomSize {
+		return 0, &HeaderError{fmt.Sprintf("%s of 2^%d * %d bytes is too big", name, exponent,
+						   multiplier)}
+	}
+	return multiplier << exponent, nil
+}
+
+// The size in bytes of RAM given by a shift count.  0 means there's none, otherwise it's 64 bytes
+// shifted left by the count.
+func nes2RamSize(shift uint8) int {
+	if 0 == shift {
+		return 0
+	}
+	return 64 << shift
+}
+
+// Split 'rom' into banks of 'bankSize' bytes.  NES 2.0 ROMs don't have to be a whole number of
+// banks.  A short last bank is filled by repeating it, as the address lines that would select
+// past its end are ignored.
+func splitBanks(rom []byte, bankSize int) (banks [][]byte) {
+	for start := 0; start < len(rom); start += bankSize {
+		end := start + bankSize
+		if end <= len(rom) {
+			banks = append(banks, rom[start:end])
+			continue
+		}
+
+		bank := make([]byte, bankSize)
+		for i := range bank {
+			bank[i] = rom[start + i % (len(rom) - start)]
+		}
+		banks = append(banks, bank)
+	}
+	return
+}
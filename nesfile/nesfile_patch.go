@@ -0,0 +1,355 @@
+package nesfile
+
+// Soft-patching: IPS, UPS and BPS patches are applied to the whole iNES file, header included, as
+// it's loaded.  See http://www.romhacking.net/documents/ for the formats.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Magic values at the start of each kind of patch.
+var (
+	ipsMagic = []byte("PATCH")
+	upsMagic = []byte("UPS1")
+	bpsMagic = []byte("BPS1")
+)
+
+// The extensions FindPatch looks for, in order.
+var patchExtensions = []string{".ips", ".ups", ".bps"}
+
+// The patch isn't in a format we know.
+var ErrUnknownPatch = errors.New("not an IPS, UPS or BPS patch")
+
+// The patch is malformed.
+type PatchError struct {
+	Msg string
+}
+
+func (err *PatchError) Error() string {
+	return "bad patch: " + err.Msg
+}
+
+// A UPS or BPS patch's CRC32 didn't match.
+type ChecksumError struct {
+	// "patch" if the patch is corrupt, "source" if it's for a different ROM, or "target" if
+	// applying it went wrong.
+	Part string
+
+	Expected uint32
+	Got uint32
+}
+
+func (err *ChecksumError) Error() string {
+	return fmt.Sprintf("%s checksum is %08X, expected %08X", err.Part, err.Got, err.Expected)
+}
+
+// The patch next to the ROM at 'romPath', with the same name and a .ips, .ups or .bps extension.
+// Returns "" if there isn't one.
+func FindPatch(romPath string) string {
+	base := strings.TrimSuffix(romPath, filepath.Ext(romPath))
+	for _, ext := range patchExtensions {
+		if info, err := os.Stat(base + ext); nil == err && !info.IsDir() {
+			return base + ext
+		}
+	}
+	return ""
+}
+
+// Apply 'patch' to 'rom' and return the result.  The format is worked out from the patch's magic
+// value.  'rom' isn't changed.
+func ApplyPatch(rom []byte, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, ipsMagic):
+		return applyIPS(rom, patch[len(ipsMagic):])
+	case bytes.HasPrefix(patch, upsMagic):
+		return applyUPS(rom, patch)
+	case bytes.HasPrefix(patch, bpsMagic):
+		return applyBPS(rom, patch)
+	}
+	return nil, ErrUnknownPatch
+}
+
+// Reads the parts of a patch, keeping track of where it's got to.
+type patchReader struct {
+	data []byte
+	pos int
+
+	// Set once a read goes off the end.  Reads after that return nothing, or zero.
+	short bool
+}
+
+// How many bytes of the patch are left to read.
+func (r *patchReader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+// The next 'n' bytes of the patch.  'n' comes from the patch, so if there aren't that many left,
+// nothing is allocated for them; it returns nil and sets 'short'.
+func (r *patchReader) readBytes(n int) []byte {
+	if n < 0 || n > r.remaining() {
+		r.short = true
+		r.pos = len(r.data)
+		return nil
+	}
+	r.pos += n
+	return r.data[r.pos - n:r.pos]
+}
+
+func (r *patchReader) readByte() uint8 {
+	if b := r.readBytes(1); nil != b {
+		return b[0]
+	}
+	return 0
+}
+
+// A big-endian number of 'n' bytes, as IPS uses.
+func (r *patchReader) bigEndian(n int) (value int) {
+	for _, b := range r.readBytes(n) {
+		value = (value << 8) | int(b)
+	}
+	return
+}
+
+// The variable-length numbers UPS and BPS use.  Each byte holds 7 bits, least significant first,
+// and the last has its top bit set.  One is added at each step so every value has one encoding.
+func (r *patchReader) number() int {
+	value, shift := 0, 1
+	for !r.short {
+		b := r.readByte()
+		value += int(b & 0x7f) * shift
+		if 0 != (b & 0x80) || shift > maxRomSize {
+			break
+		}
+		shift <<= 7
+		value += shift
+	}
+	return value
+}
+
+// IPS patches are a list of records, each an offset and bytes to write there, ending with "EOF".
+// There's no checksum.
+func applyIPS(rom []byte, patch []byte) ([]byte, error) {
+	out := append([]byte{}, rom...)
+	r := &patchReader{data: patch}
+
+	// Writing past the end of the file makes it longer.
+	write := func(offset int, data []byte) {
+		if end := offset + len(data); end > len(out) {
+			out = append(out, make([]byte, end - len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+
+	for {
+		offset := r.bigEndian(3)
+		if r.short {
+			return nil, &PatchError{"IPS patch has no EOF marker"}
+		}
+		if 0x454f46 == offset { // "EOF"
+			break
+		}
+
+		size := r.bigEndian(2)
+		if 0 == size {
+			// A run of one value.
+			size = r.bigEndian(2)
+			write(offset, bytes.Repeat([]byte{r.readByte()}, size))
+		} else {
+			write(offset, r.readBytes(size))
+		}
+		if r.short {
+			return nil, &PatchError{fmt.Sprintf("IPS record at 0x%06X is truncated", offset)}
+		}
+	}
+
+	// Some patches give the length to truncate the file to after the EOF marker.
+	if len(patch) - r.pos >= 3 {
+		if length := r.bigEndian(3); length < len(out) {
+			out = out[:length]
+		}
+	}
+	return out, nil
+}
+
+// UPS and BPS patches end with the CRC32s of the source, the target and the patch itself.  Checks
+// the patch's and the source's, and returns the patch without them and the target's CRC32.
+func checkPatchFooter(rom []byte, patch []byte) ([]byte, uint32, error) {
+	if len(patch) < 4 + 12 {
+		return nil, 0, &PatchError{"too short"}
+	}
+	body := patch[:len(patch) - 12]
+	footer := patch[len(patch) - 12:]
+
+	patchCRC := binary.LittleEndian.Uint32(footer[8:])
+	if got := crc32.ChecksumIEEE(patch[:len(patch) - 4]); patchCRC != got {
+		return nil, 0, &ChecksumError{"patch", patchCRC, got}
+	}
+	sourceCRC := binary.LittleEndian.Uint32(footer[0:])
+	if got := crc32.ChecksumIEEE(rom); sourceCRC != got {
+		return nil, 0, &ChecksumError{"source", sourceCRC, got}
+	}
+	return body, binary.LittleEndian.Uint32(footer[4:]), nil
+}
+
+// Check the result of a UPS or BPS patch against the target CRC32 from the footer.
+func checkTarget(out []byte, targetCRC uint32) ([]byte, error) {
+	if got := crc32.ChecksumIEEE(out); targetCRC != got {
+		return nil, &ChecksumError{"target", targetCRC, got}
+	}
+	return out, nil
+}
+
+// Read the source and target sizes at the start of a UPS or BPS patch, and make the target.
+func readPatchSizes(r *patchReader, rom []byte) ([]byte, error) {
+	sourceSize := r.number()
+	targetSize := r.number()
+	if r.short || targetSize > maxRomSize {
+		return nil, &PatchError{"bad target size"}
+	}
+	if len(rom) != sourceSize {
+		return nil, &PatchError{fmt.Sprintf("patch is for a %d byte file, not %d bytes", sourceSize,
+						    len(rom))}
+	}
+	return make([]byte, targetSize), nil
+}
+
+// UPS patches are a list of hunks, each a number of bytes to skip and then bytes to XOR with the
+// source, ending with a zero.
+func applyUPS(rom []byte, patch []byte) ([]byte, error) {
+	body, targetCRC, err := checkPatchFooter(rom, patch)
+	if nil != err {
+		return nil, err
+	}
+
+	r := &patchReader{data: body, pos: len(upsMagic)}
+	out, err := readPatchSizes(r, rom)
+	if nil != err {
+		return nil, err
+	}
+	copy(out, rom)
+
+	// Bytes of the source past its end are zero.
+	source := func(i int) uint8 {
+		if i < len(rom) {
+			return rom[i]
+		}
+		return 0
+	}
+
+	for offset := 0; r.pos < len(body); {
+		offset += r.number()
+		for {
+			x := r.readByte()
+			if r.short {
+				return nil, &PatchError{"UPS patch is truncated"}
+			}
+
+			// The zero that ends a hunk can fall just past the end of the target.
+			if offset < len(out) {
+				out[offset] = source(offset) ^ x
+			} else if 0 != x {
+				return nil, &PatchError{fmt.Sprintf("UPS hunk at 0x%X is past the end", offset)}
+			}
+			offset++
+			if 0 == x {
+				break
+			}
+		}
+	}
+	return checkTarget(out, targetCRC)
+}
+
+// BPS actions.
+const (
+	bpsSourceRead = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+// BPS patches are a list of actions, each copying a run of bytes to the target from the source,
+// the patch, or elsewhere in the source or target.
+func applyBPS(rom []byte, patch []byte) ([]byte, error) {
+	body, targetCRC, err := checkPatchFooter(rom, patch)
+	if nil != err {
+		return nil, err
+	}
+
+	r := &patchReader{data: body, pos: len(bpsMagic)}
+	out, err := readPatchSizes(r, rom)
+	if nil != err {
+		return nil, err
+	}
+
+	// Skip the metadata.
+	metadataSize := r.number()
+	if r.short || metadataSize > r.remaining() {
+		return nil, &PatchError{"BPS metadata is past the end of the patch"}
+	}
+	r.readBytes(metadataSize)
+
+	// A signed offset relative to the last copy from the same place.
+	relative := func(last int) int {
+		n := r.number()
+		if 0 != (n & 1) {
+			return last - (n >> 1)
+		}
+		return last + (n >> 1)
+	}
+
+	offset, sourceOffset, targetOffset := 0, 0, 0
+	for r.pos < len(body) && !r.short {
+		n := r.number()
+		action := n & 3
+		length := (n >> 2) + 1
+		if offset + length > len(out) {
+			return nil, &PatchError{fmt.Sprintf("BPS action at 0x%X is past the end", offset)}
+		}
+
+		switch action {
+		case bpsSourceRead:
+			if offset + length > len(rom) {
+				return nil, &PatchError{"BPS read past the end of the source"}
+			}
+			copy(out[offset:], rom[offset:offset + length])
+
+		case bpsTargetRead:
+			if length > r.remaining() {
+				return nil, &PatchError{"BPS read past the end of the patch"}
+			}
+			copy(out[offset:], r.readBytes(length))
+
+		case bpsSourceCopy:
+			sourceOffset = relative(sourceOffset)
+			if sourceOffset < 0 || sourceOffset + length > len(rom) {
+				return nil, &PatchError{"BPS copy past the end of the source"}
+			}
+			copy(out[offset:], rom[sourceOffset:sourceOffset + length])
+			sourceOffset += length
+
+		case bpsTargetCopy:
+			// The copy can overlap what it's writing, repeating a pattern, so it's done a byte
+			// at a time.
+			targetOffset = relative(targetOffset)
+			if targetOffset < 0 || targetOffset >= offset {
+				return nil, &PatchError{"BPS copy from outside the target"}
+			}
+			for i := 0; i < length; i++ {
+				out[offset + i] = out[targetOffset]
+				targetOffset++
+			}
+		}
+		offset += length
+	}
+	if r.short {
+		return nil, &PatchError{"BPS patch is truncated"}
+	}
+	return checkTarget(out, targetCRC)
+}
@@ -0,0 +1,156 @@
+package nesfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Encode 'value' as a UPS or BPS number.
+func patchNumber(value int) (out []byte) {
+	for {
+		b := byte(value & 0x7f)
+		value >>= 7
+		if 0 == value {
+			return append(out, b | 0x80)
+		}
+		out = append(out, b)
+		value--
+	}
+}
+
+// Add the UPS or BPS footer to 'patch'.
+func addPatchFooter(patch []byte, source []byte, target []byte) []byte {
+	footer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(source))
+	patch = append(patch, footer...)
+	binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(target))
+	patch = append(patch, footer...)
+	binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(patch))
+	return append(patch, footer...)
+}
+
+func expectPatched(t *testing.T, name string, rom []byte, patch []byte, expected []byte) {
+	t.Helper()
+	out, err := ApplyPatch(rom, patch)
+	if nil != err {
+		t.Errorf("%s: %v", name, err)
+	} else if !bytes.Equal(expected, out) {
+		t.Errorf("%s: expected % x, got % x", name, expected, out)
+	}
+}
+
+func TestPatchNumber(t *testing.T) {
+	for _, value := range []int{0, 1, 0x7f, 0x80, 0x407f, 0x4080, 1 << 20} {
+		r := &patchReader{data: patchNumber(value)}
+		if got := r.number(); value != got || r.short || len(r.data) != r.pos {
+			t.Errorf("Encoded %d as % x, decoded %d", value, r.data, got)
+		}
+	}
+}
+
+func TestIPS(t *testing.T) {
+	rom := []byte{0, 1, 2, 3, 4, 5}
+	patch := []byte("PATCH")
+	patch = append(patch, 0, 0, 1, 0, 2, 0xaa, 0xbb)   // Write AA BB at 1
+	patch = append(patch, 0, 0, 5, 0, 0, 0, 3, 0xcc)   // Three CCs at 5, lengthening the file
+	patch = append(patch, 'E', 'O', 'F')
+	expectPatched(t, "IPS", rom, patch, []byte{0, 0xaa, 0xbb, 3, 4, 0xcc, 0xcc, 0xcc})
+
+	// Truncated to 4 bytes.
+	expectPatched(t, "IPS truncation", rom, append(patch, 0, 0, 4), []byte{0, 0xaa, 0xbb, 3})
+
+	var patchErr *PatchError
+	if _, err := ApplyPatch(rom, patch[:len(patch) - 3]); !errors.As(err, &patchErr) {
+		t.Error("Expected a PatchError without EOF, got", err)
+	}
+}
+
+func TestUPS(t *testing.T) {
+	rom := []byte{0, 1, 2, 3, 4, 5}
+	target := []byte{0, 1, 0x12, 3, 4, 5, 6}
+
+	patch := append([]byte("UPS1"), patchNumber(len(rom))...)
+	patch = append(patch, patchNumber(len(target))...)
+	patch = append(patch, patchNumber(2)...)
+	patch = append(patch, 0x10, 0)
+	patch = append(patch, patchNumber(2)...)
+	patch = append(patch, 6, 0)
+	patch = addPatchFooter(patch, rom, target)
+	expectPatched(t, "UPS", rom, patch, target)
+
+	// The wrong ROM.
+	var checksum *ChecksumError
+	if _, err := ApplyPatch([]byte{9, 1, 2, 3, 4, 5}, patch); !errors.As(err, &checksum) ||
+			"source" != checksum.Part {
+		t.Error("Expected a source checksum error, got", err)
+	}
+
+	// A corrupt patch.
+	patch[len(patch) - 13] ^= 1
+	if _, err := ApplyPatch(rom, patch); !errors.As(err, &checksum) || "patch" != checksum.Part {
+		t.Error("Expected a patch checksum error, got", err)
+	}
+}
+
+func TestBPS(t *testing.T) {
+	rom := []byte{0, 1, 2, 3, 4, 5}
+	target := []byte{0, 1, 0xaa, 4, 5, 0xaa, 4, 5, 0xaa}
+
+	action := func(action int, length int) []byte {
+		return patchNumber((length - 1) << 2 | action)
+	}
+	patch := append([]byte("BPS1"), patchNumber(len(rom))...)
+	patch = append(patch, patchNumber(len(target))...)
+	patch = append(patch, patchNumber(2)...)
+	patch = append(patch, "{}"...)                          // Metadata
+	patch = append(patch, action(bpsSourceRead, 2)...)      // 0 1
+	patch = append(patch, action(bpsTargetRead, 1)...)      // AA
+	patch = append(patch, 0xaa)
+	patch = append(patch, action(bpsSourceCopy, 2)...)      // 4 5, from 4
+	patch = append(patch, patchNumber(4 << 1)...)
+	patch = append(patch, action(bpsTargetCopy, 4)...)      // AA 4 5 AA, from 2
+	patch = append(patch, patchNumber(2 << 1)...)
+	patch = addPatchFooter(patch, rom, target)
+	expectPatched(t, "BPS", rom, patch, target)
+
+	// Lengths from the patch are checked against what's left of it before anything is read or
+	// allocated, rather than running out of memory.
+	huge := append([]byte("BPS1"), patchNumber(len(rom))...)
+	huge = append(huge, patchNumber(len(target))...)
+	huge = append(huge, patchNumber(1 << 40)...)
+	huge = addPatchFooter(huge, rom, target)
+	var patchErr *PatchError
+	if _, err := ApplyPatch(rom, huge); !errors.As(err, &patchErr) {
+		t.Error("Expected a PatchError for huge metadata, got", err)
+	}
+}
+
+// A patch next to the ROM is applied when it's loaded.
+func TestFindPatch(t *testing.T) {
+	dir := t.TempDir()
+	romPath := filepath.Join(dir, "game.nes")
+	if err := ioutil.WriteFile(romPath, makeNesFile(1), 0644); nil != err {
+		t.Fatal(err)
+	}
+
+	if "" != FindPatch(romPath) {
+		t.Error("Found a patch that isn't there")
+	}
+
+	patch := append([]byte("PATCH"), 0, 0, 16, 0, 1, 0x42, 'E', 'O', 'F')
+	if err := ioutil.WriteFile(filepath.Join(dir, "game.ips"), patch, 0644); nil != err {
+		t.Fatal(err)
+	}
+	nesFile, err := ReadNesFile(romPath)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 0x42 != nesFile.PrgRom[0][0] {
+		t.Errorf("Expected the patch to be applied, PRG-ROM starts with %d", nesFile.PrgRom[0][0])
+	}
+}
@@ -0,0 +1,204 @@
+package nesfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestParseINES(t *testing.T) {
+	// Mapper 0x41, battery, vertical mirroring, 2 PRG banks and no CHR.
+	header := []byte{'N', 'E', 'S', 0x1a, 2, 0, 0x13, 0x40, 0, 0, 0, 0, 0, 0, 0, 0}
+	nesFile := new(NesFile)
+	prgSize, chrSize, err := nesFile.parseHeader(header)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if INES != nesFile.Format || 0x41 != nesFile.Mapper || Vertical != nesFile.Mirroring ||
+			!nesFile.SramEnabled || 0x8000 != prgSize || 0 != chrSize {
+		t.Errorf("Parsed wrongly: %+v, PRG %d, CHR %d", nesFile, prgSize, chrSize)
+	}
+	if 0x2000 != nesFile.PrgNvramSize || 0x2000 != nesFile.ChrRamSize {
+		t.Errorf("Expected 8K of PRG-NVRAM and CHR-RAM, got %+v", nesFile)
+	}
+
+	// Junk at the end of the header means byte 7 is junk too.
+	copy(header[7:], "DiskDude!")
+	nesFile = new(NesFile)
+	if _, _, err := nesFile.parseHeader(header); nil != err || 1 != nesFile.Mapper {
+		t.Errorf("Expected mapper 1 despite the junk, got %d (%v)", nesFile.Mapper, err)
+	}
+	if 0x2000 != nesFile.PrgNvramSize || 0x2000 != nesFile.ChrRamSize {
+		t.Errorf("Expected the usual RAM sizes despite the junk, got %+v", nesFile)
+	}
+}
+
+func TestParseNES2(t *testing.T) {
+	header := []byte{'N', 'E', 'S', 0x1a,
+		0x02,       // PRG-ROM: 0x302 16K banks
+		0x35,       // CHR-ROM: 2^13 * 3 bytes
+		0x40,       // Mapper bits 0-3
+		0x19,       // Mapper bits 4-7, NES 2.0, Vs. System
+		0x21,       // Submapper 2, mapper bits 8-11
+		0xf3,       // CHR-ROM size is exponent-multiplier
+		0x70,       // 8K of PRG-NVRAM
+		0x07,       // 8K of CHR-RAM
+		0x03,       // Dendy
+		0x12,       // Vs. hardware 1, PPU 2
+		0x00,
+		0x01}       // Standard controllers
+	nesFile := new(NesFile)
+	prgSize, chrSize, err := nesFile.parseHeader(header)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	expected := NesFile{Format: NES2, Mapper: 0x114, Submapper: 2, SramEnabled: true,
+		PrgNvramSize: 0x2000, ChrRamSize: 0x2000, Timing: Dendy, ConsoleType: VsSystem,
+		VsPPUType: 2, VsHardwareType: 1, ExpansionDevice: 1}
+	if !reflect.DeepEqual(expected, *nesFile) || 0x302 * 0x4000 != prgSize || 3 << 13 != chrSize {
+		t.Errorf("Expected %+v, got %+v, PRG %d, CHR %d", expected, *nesFile, prgSize, chrSize)
+	}
+
+	header[9] = 0x0f
+	header[4] = 0xfc
+	if _, _, err := new(NesFile).parseHeader(header); nil == err {
+		t.Error("Expected an error for a huge PRG-ROM")
+	}
+}
+
+func TestSplitBanks(t *testing.T) {
+	banks := splitBanks([]byte{1, 2, 3, 4, 5, 6}, 4)
+	if 2 != len(banks) || !bytes.Equal([]byte{1, 2, 3, 4}, banks[0]) ||
+			!bytes.Equal([]byte{5, 6, 5, 6}, banks[1]) {
+		t.Error("Split wrongly:", banks)
+	}
+}
+
+// A file with one PRG bank and one CHR bank.  The PRG-ROM starts with 'first'.
+func makeNesFile(first byte) []byte {
+	header := []byte{'N', 'E', 'S', 0x1a, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	file := append(header, make([]byte, 0x6000)...)
+	file[16] = first
+	return file
+}
+
+func TestLoadNesFile(t *testing.T) {
+	file := makeNesFile(0xaa)
+	header := file[:16]
+	nesFile, err := LoadNesFile(bytes.NewReader(file))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 1 != len(nesFile.PrgRom) || 1 != len(nesFile.ChrRom) || 0xaa != nesFile.PrgRom[0][0] {
+		t.Errorf("Loaded wrongly: %+v", nesFile)
+	}
+
+	if _, err := LoadNesFile(bytes.NewReader([]byte("PK\x03\x04 not a NES file"))); ErrBadMagic != err {
+		t.Error("Expected ErrBadMagic, got", err)
+	}
+
+	// Cut short in the header, PRG-ROM and CHR-ROM.
+	for _, c := range []struct{ length int; truncated TruncatedError } {
+		{ 10, TruncatedError{"header", 16, 10} },
+		{ 0x1010, TruncatedError{"PRG-ROM", 0x4000, 0x1000} },
+		{ 0x4010, TruncatedError{"CHR-ROM", 0x2000, 0} },
+	} {
+		_, err := LoadNesFile(bytes.NewReader(file[:c.length]))
+		var truncated *TruncatedError
+		if !errors.As(err, &truncated) || c.truncated != *truncated {
+			t.Errorf("Expected %v, got %v", &c.truncated, err)
+		}
+	}
+
+	noPrg := append([]byte{}, header...)
+	noPrg[4] = 0
+	var headerErr *HeaderError
+	if _, err := LoadNesFile(bytes.NewReader(noPrg)); !errors.As(err, &headerErr) {
+		t.Error("Expected a HeaderError, got", err)
+	}
+}
+
+func TestReadArchives(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, fill func(f *os.File)) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		fill(f)
+		if err := f.Close(); nil != err {
+			t.Fatal(err)
+		}
+		return path
+	}
+	zipped := func(names ...string) func(f *os.File) {
+		return func(f *os.File) {
+			w := zip.NewWriter(f)
+			for i, name := range names {
+				member, _ := w.Create(name)
+				member.Write(makeNesFile(byte(i + 1)))
+			}
+			w.Close()
+		}
+	}
+	expectFirst := func(nesFile *NesFile, err error, first byte) {
+		t.Helper()
+		if nil != err {
+			t.Error(err)
+		} else if first != nesFile.PrgRom[0][0] {
+			t.Errorf("Expected the ROM starting with %d, got %d", first, nesFile.PrgRom[0][0])
+		}
+	}
+
+	// The only .nes file is picked, whatever the case of its extension.
+	nesFile, err := ReadNesFile(write("one.zip", zipped("readme.txt", "game.NES")))
+	expectFirst(nesFile, err, 2)
+
+	several := write("several.zip", zipped("a.nes", "b/b.nes"))
+	_, err = ReadNesFile(several)
+	var multiple *MultipleRomsError
+	if !errors.As(err, &multiple) || !reflect.DeepEqual([]string{"a.nes", "b/b.nes"}, multiple.Names) {
+		t.Error("Expected a MultipleRomsError, got", err)
+	}
+	nesFile, err = ReadNesFileMember(several, "b/b.nes")
+	expectFirst(nesFile, err, 2)
+
+	if _, err := ReadNesFile(write("none.zip", zipped("readme.txt"))); ErrNoRom != err {
+		t.Error("Expected ErrNoRom, got", err)
+	}
+
+	gzipped := write("game.nes.gz", func(f *os.File) {
+		w := gzip.NewWriter(f)
+		w.Write(makeNesFile(7))
+		w.Close()
+	})
+	nesFile, err = ReadNesFile(gzipped)
+	expectFirst(nesFile, err, 7)
+}
+
+// A corrupt header claiming a huge ROM fails as truncated without allocating it.
+func TestLoadHugeHeader(t *testing.T) {
+	// NES 2.0, with a PRG-ROM of 2^29 bytes.
+	header := []byte{'N', 'E', 'S', 0x1a, 0x74, 0, 0, 0x08, 0, 0x0f, 0, 0, 0, 0, 0, 0}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := LoadNesFile(bytes.NewReader(append(header, make([]byte, 100)...)))
+	runtime.ReadMemStats(&after)
+
+	var truncated *TruncatedError
+	if !errors.As(err, &truncated) || 1 << 29 != truncated.Expected || 100 != truncated.Got {
+		t.Error("Expected a TruncatedError, got", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1 << 20 {
+		t.Errorf("Allocated %d bytes", allocated)
+	}
+}
@@ -1,9 +1,6 @@
 package ppu
 
-import (
-	"mapper"
-	"wrapper"
-)
+import "mapper"
 
 const (
 	// The display is 240 pixels high but the top and bottom 8 are usually cut off by the
@@ -12,12 +9,31 @@ const (
 	DisplayHeight = 240
 
 	DisplayWidth = 256
+
+	// Lines 0-239 are visible, 240 is the post-render line, VBlank is 241-260, and this line
+	// prepares for rendering line 0.
+	VBlankScanLine = 241
+	PreRenderScanLine = 261
+
+	// Each scan line is this many PPU cycles long.
+	DotsPerScanLine = 341
 )
 
+// The PPU draws into one of these.
+type Video interface {
+	// Set the ('x', 'y')-th pixel to ('r', 'g', 'b').
+	SetPixel(x, y int, r, g, b byte)
+}
+
 type PPU struct {
 	// Set to true to enable some debugging logging.
 	Debug bool
 
+	// The PPU can only draw 8 sprites on each line, which makes sprites flicker in games that
+	// cycle through which ones are drawn.  Set to true to draw all of them.  The sprite
+	// overflow flag and mapper-visible fetches are unaffected.
+	NoSpriteLimit bool
+
 	// PPU Address space.  0x0000 -> 0x3FFF is mirrored repeatedly at 0x4000 and above.
 	// [0x0000 -> 0x3EFF] is dealt with by the cart.
 	cartMapper mapper.Mapper
@@ -103,36 +119,151 @@ type PPU struct {
 	// THEN it's buffered and returned on a subsequent read.
 	bufferedReadData uint8
 
-	// The graphics window we render into.
-	window *wrapper.GraphicsWindow
+	// Where we draw the picture.
+	video Video
+
+	// Set if the mapper wants to watch the rendering fetches.
+	fetchObserver mapper.PPUFetchObserver
+
+	// Told about every access to PPU memory, if set.
+	watcher AccessWatcher
+
+	// Where the PPU is in the frame.  Each scan line has 341 dots (PPU cycles), and each frame
+	// has 262 scan lines.  See PreRenderScanLine above.
+	scanLine int
+	dot int
+
+	// Every other frame is one dot shorter when rendering is enabled.
+	oddFrame bool
+
+	// The background pipeline.  Tile data is fetched over 8 dots into these latches, then
+	// loaded into the low 8 bits of the shift registers below.
+	nameTableLatch byte
+	attributeLatch byte
+	patternLowLatch byte
+	patternHighLatch byte
+
+	// Shift registers holding the pattern and attribute bits for the current and next tile.  The
+	// pixel being output is in bit 15 - loopyX, and they shift left one bit every dot.  The
+	// attribute bits are expanded to 8 bits each when loaded so they shift in step.
+	patternLowShift uint16
+	patternHighShift uint16
+	attributeLowShift uint16
+	attributeHighShift uint16
+
+	// Sprite evaluation copies the (up to) 8 sprites on the next line into secondary OAM.
+	// secondaryOAMCount is how many it found, and secondaryHasSpriteZero is set if sprite 0 is
+	// one of them.
+	secondaryOAM [32]byte
+	secondaryOAMCount int
+	secondaryHasSpriteZero bool
+
+	// Where sprite evaluation is in OAM: which sprite, and which of its 4 bytes.  evalDone is
+	// set once there is nothing left to look at on this line.
+	evalSprite int
+	evalByte int
+	evalDone bool
+
+	// The last byte read by sprite evaluation.  Reads of OAMDATA return this while it's
+	// running.
+	oamLatch byte
+
+	// The sprites that will be drawn on the next line, fetched during dots 257 to 320.  Only
+	// the first 8 are used unless NoSpriteLimit is set.
+	lineSprites [64]lineSprite
+	lineSpriteCount int
 
-	// What scan line are we rendering?
-	scanLineCounter uint16
+	// The NMI output is the VBlank flag AND'd with the NMI enable bit in ppuCtrl.  The CPU is
+	// interrupted when it goes from low to high.
+	nmiOutput bool
+
+	// Set when the NMI output goes high, until PollNMI() is called.
+	nmiPending bool
+
+	// Set when a frame has been fully rendered, until FrameComplete() is called.
+	frameComplete bool
+}
+
+// A sprite that is on the line being rendered.
+type lineSprite struct {
+	// The sprite's X coordinate.
+	x byte
+
+	// The sprite's attribute byte.
+	attr byte
+
+	// Where the row of the sprite's pattern that's on this line starts.  The high byte is 8 bytes
+	// later.
+	patternAddr uint16
+
+	// The row of the sprite's pattern that's on this line.  Already flipped horizontally if the
+	// sprite is flipped.
+	patternLow byte
+	patternHigh byte
+
+	// Is this sprite 0?  Needed for sprite 0 hit detection.
+	isSpriteZero bool
 }
 
-func NewPPU(cartMapper mapper.Mapper, window *wrapper.GraphicsWindow) (ppu *PPU) {
+func NewPPU(cartMapper mapper.Mapper, video Video) (ppu *PPU) {
 	ppu = new(PPU)
-	ppu.window = window
+	ppu.video = video
 	ppu.cartMapper = cartMapper
+	if observer, ok := cartMapper.(mapper.PPUFetchObserver); ok {
+		ppu.fetchObserver = observer
+	}
 	ppu.addressLatch = 0
-	ppu.scanLineCounter = 0
+
+	// Power up at the start of the first visible line, like the emulators that made the
+	// reference nestest.log.
+	ppu.scanLine = 0
+	ppu.dot = 0
 	return
 }
 
-// Enter the VBlank period.  Returns true if the CPU should handle a NMI, false otherwise.
-func (ppu *PPU) EnterVBlankShouldNMI() bool {
-	// Turn on the "we're in VBlank" flag.
-	ppu.ppuStatus |= 0x80
+// Called with every read or write of PPU memory (not registers).  Used by the debugger.
+type AccessWatcher func(addr uint16, val uint8, write bool)
+
+// Tell 'watcher' about every access to PPU memory.  nil turns watching off.
+func (ppu *PPU) SetWatcher(watcher AccessWatcher) {
+	ppu.watcher = watcher
+}
+
+// Read 'addr' in PPU memory without side effects.
+func (ppu *PPU) Peek(addr uint16) uint8 {
+	addr &= 0x3fff
+	if addr < 0x3f00 {
+		return ppu.cartMapper.ReadPPU(addr)
+	}
+	return ppu.pal[addr & 0x1f]
+}
+
+// The scanline and dot the PPU is about to draw.
+func (ppu *PPU) Position() (scanline, dot int) {
+	return ppu.scanLine, ppu.dot
+}
 
-	// If this bit is set, the CPU wants an NMI to occur when VBlank happens.
-	return 0x80 == (ppu.ppuCtrl & 0x80)
+// Returns true if a NMI should be sent to the CPU.  A NMI is only reported once.
+func (ppu *PPU) PollNMI() bool {
+	pending := ppu.nmiPending
+	ppu.nmiPending = false
+	return pending
 }
 
-// Exit the VBlank period.
-func (ppu *PPU) ExitVBlank() {
-	// Turn off the sprite zero and VBlank flags.
-	ppu.ppuStatus &= 0x3f
+// Returns true if a frame has been completely rendered since the last call, meaning it's time to
+// show it.  This happens as VBlank starts.
+func (ppu *PPU) FrameComplete() bool {
+	complete := ppu.frameComplete
+	ppu.frameComplete = false
+	return complete
+}
 
-	// Start rendering line 0.
-	ppu.scanLineCounter = 0
+// Recompute the NMI output after the VBlank flag or the NMI enable bit changes.  A NMI happens on
+// the rising edge, so enabling NMIs during VBlank causes one immediately.
+func (ppu *PPU) updateNMI() {
+	output := (0x80 == (ppu.ppuStatus & 0x80)) && (0x80 == (ppu.ppuCtrl & 0x80))
+	if output && !ppu.nmiOutput {
+		ppu.nmiPending = true
+	}
+	ppu.nmiOutput = output
 }
@@ -33,12 +33,19 @@ func (ppu *PPU) ReadRegister(mmioreg uint16)(val uint8) {
 		val = ppu.ppuStatus
 		// Side-effects upon reading: the VBlank bit is cleared,
 		ppu.ppuStatus &= 0x7f
+		ppu.updateNMI()
 		// and the address latch is reset.
 		ppu.addressLatch = 0
 		return
 	case OAMADDR:
 		return ppu.oamAddr
 	case OAMDATA:
+		// While sprites are being evaluated, the PPU is using OAM and reads see whatever
+		// it's looking at.
+		if ppu.renderingEnabled() && ppu.scanLine < DisplayHeight && ppu.dot >= 1 &&
+				ppu.dot <= DisplayWidth {
+			return ppu.oamLatch
+		}
 		val = ppu.oamData[ppu.oamAddr]
 		return
 	case PPUSCROLL:
@@ -63,6 +70,9 @@ func (ppu *PPU) ReadRegister(mmioreg uint16)(val uint8) {
                         // ppu.bufferedReadData = ppu.nts[bufAddr / 0x400][bufAddr & 0x3ff]
                 }
 
+		if nil != ppu.watcher {
+			ppu.watcher(ppu.loopyV, ppu.Peek(ppu.loopyV), false)
+		}
 		ppu.incVRAMAddress()
 		return
 	default:
@@ -89,6 +99,8 @@ func (ppu *PPU) WriteRegister(mmioreg uint16, val uint8) {
 		ppu.ppuCtrl = val
 		ppu.loopyT &= ^uint16(0x0c00)
 		ppu.loopyT |= uint16(val & 3) << 10
+		// Enabling NMIs during VBlank causes one right away.
+		ppu.updateNMI()
 	case PPUMASK:
 		ppu.ppuMask = val
 	case PPUSTATUS:
@@ -138,6 +150,9 @@ func (ppu *PPU) WriteRegister(mmioreg uint16, val uint8) {
 				ppu.pal[addr ^ 0x10] = val
 			}
                 }
+		if nil != ppu.watcher {
+			ppu.watcher(ppu.loopyV, val, true)
+		}
 		ppu.incVRAMAddress()
 	default:
 		panic("trying to read unknown ppu reg")
@@ -0,0 +1,312 @@
+package ppu
+
+// Sprites are found for each line the way the PPU does it, described at
+// http://wiki.nesdev.com/w/index.php/PPU_sprite_evaluation
+//
+// During dots 1 to 64 of a visible line, secondary OAM is cleared to 0xFF.  During dots 65 to 256,
+// the PPU walks OAM looking for sprites on the next line and copies up to 8 of them into secondary
+// OAM.  Odd dots read from OAM and even dots write to secondary OAM.  Then during dots 257 to 320
+// the patterns of the sprites in secondary OAM are fetched.
+//
+// Once 8 sprites are found, the PPU keeps looking to set the sprite overflow flag, but a hardware
+// bug makes it look at the wrong bytes of OAM.  The bug is emulated, so the flag is set in the
+// same places, and at the same dot, as it is on hardware.
+
+// The sprite half of rendering.
+func (ppu *PPU) tickSprites(preRenderLine bool) {
+	dot := ppu.dot
+
+	// There is no sprite evaluation on the pre-render line, since no sprites are drawn on line 0.
+	if !preRenderLine {
+		if dot >= 1 && dot <= 64 {
+			ppu.clearSecondaryOAM()
+		} else if dot >= 65 && dot <= 256 {
+			ppu.evaluateSprites()
+		}
+	}
+
+	if 257 == dot {
+		if preRenderLine {
+			ppu.lineSpriteCount = 0
+		} else {
+			ppu.lineSpriteCount = ppu.secondaryOAMCount
+			if ppu.NoSpriteLimit {
+				ppu.findExtraSprites()
+			}
+		}
+	}
+
+	if dot < 257 || dot > 320 {
+		return
+	}
+
+	// The low pattern byte is fetched 4 dots into each sprite's slot and the high byte 2 dots
+	// after that.
+	slot := (dot - 257) / 8
+	switch (dot - 257) & 7 {
+	case 4:
+		ppu.fetchSpritePattern(slot, 0)
+	case 6:
+		ppu.fetchSpritePattern(slot, 8)
+	}
+}
+
+// The height of sprites, in pixels.
+func (ppu *PPU) spriteHeight() int {
+	// This bit controls whether or not we using 8x16 sprites instead of the default 8x8.
+	if 0x20 == (ppu.ppuCtrl & 0x20) {
+		return 16
+	}
+	return 8
+}
+
+// Is a sprite whose first byte is 'y' on the next line?
+func (ppu *PPU) spriteOnNextLine(y byte) bool {
+	// The first byte of the sprite data is the Y coordinate minus one, which is how far the line
+	// being evaluated is past it.
+	row := ppu.scanLine - int(y)
+	return row >= 0 && row < ppu.spriteHeight()
+}
+
+// Dots 1 to 64 write 0xFF to each byte of secondary OAM, one byte every 2 dots.  Reads of OAMDATA
+// return 0xFF during this time.
+func (ppu *PPU) clearSecondaryOAM() {
+	if 1 == (ppu.dot & 1) {
+		ppu.oamLatch = 0xff
+	} else {
+		ppu.secondaryOAM[(ppu.dot - 1) / 2] = ppu.oamLatch
+	}
+}
+
+// Dots 65 to 256 look for the sprites on the next line.
+func (ppu *PPU) evaluateSprites() {
+	if 65 == ppu.dot {
+		ppu.evalSprite = 0
+		ppu.evalByte = 0
+		ppu.evalDone = false
+		ppu.secondaryOAMCount = 0
+		ppu.secondaryHasSpriteZero = false
+	}
+
+	// Odd dots read the next byte of OAM.
+	if 1 == (ppu.dot & 1) {
+		ppu.oamLatch = ppu.oamData[(ppu.evalSprite * 4 + ppu.evalByte) & 0xff]
+		return
+	}
+
+	// Once all 64 sprites are looked at, the rest of the dots do nothing interesting.
+	if ppu.evalDone {
+		return
+	}
+
+	if ppu.secondaryOAMCount < 8 {
+		// The byte read is always written to secondary OAM, but unless it's the Y coordinate
+		// of a sprite on the next line, it's overwritten by the next one.
+		ppu.secondaryOAM[ppu.secondaryOAMCount * 4 + ppu.evalByte] = ppu.oamLatch
+
+		if 0 == ppu.evalByte && !ppu.spriteOnNextLine(ppu.oamLatch) {
+			ppu.nextEvalSprite()
+			return
+		}
+
+		// Copy the other 3 bytes of the sprite.
+		ppu.evalByte++
+		if 4 == ppu.evalByte {
+			if 0 == ppu.evalSprite {
+				ppu.secondaryHasSpriteZero = true
+			}
+			ppu.evalByte = 0
+			ppu.secondaryOAMCount++
+			ppu.nextEvalSprite()
+		}
+		return
+	}
+
+	// Secondary OAM is full, so now we're only looking for overflow.  The byte read is treated
+	// as a Y coordinate.
+	if ppu.spriteOnNextLine(ppu.oamLatch) {
+		// The sprite overflow flag.  The PPU then reads the rest of the sprite, but stops
+		// looking for good.
+		ppu.ppuStatus |= 0x20
+		ppu.evalDone = true
+		return
+	}
+
+	// This is the hardware bug: moving to the next sprite also moves to the next byte, so the
+	// next "Y coordinate" may well be a tile number, attribute or X coordinate instead.
+	ppu.evalByte = (ppu.evalByte + 1) & 3
+	ppu.nextEvalSprite()
+}
+
+// Move sprite evaluation to the next sprite in OAM.
+func (ppu *PPU) nextEvalSprite() {
+	ppu.evalSprite++
+	if 64 == ppu.evalSprite {
+		ppu.evalSprite = 0
+		ppu.evalDone = true
+	}
+}
+
+// Without the sprite limit, sprites after the 8th on the next line go in the extra line sprite
+// slots.  They're not fetched at all on hardware, so their patterns are read right away, and without
+// telling the mapper.
+func (ppu *PPU) findExtraSprites() {
+	height := ppu.spriteHeight()
+	found := 0
+
+	// 256 bytes of sprite memory / 4 bytes per sprite == 64 sprites.
+	for i := 0; i < 64; i++ {
+		spriteOffset := i * 4
+		if !ppu.spriteOnNextLine(ppu.oamData[spriteOffset]) {
+			continue
+		}
+
+		// The first ones found are already in secondary OAM.
+		found++
+		if found <= ppu.secondaryOAMCount {
+			continue
+		}
+
+		sprite := &ppu.lineSprites[ppu.lineSpriteCount]
+		ppu.lineSpriteCount++
+		ppu.setLineSprite(sprite, ppu.oamData[spriteOffset:spriteOffset + 4], height)
+		sprite.isSpriteZero = false
+
+		sprite.patternLow = ppu.cartMapper.ReadPPU(sprite.patternAddr)
+		sprite.patternHigh = ppu.cartMapper.ReadPPU(sprite.patternAddr + 8)
+		if 0x40 == (sprite.attr & 0x40) {
+			sprite.patternLow = reverseBits(sprite.patternLow)
+			sprite.patternHigh = reverseBits(sprite.patternHigh)
+		}
+	}
+}
+
+// Fill in 'sprite' from the 4 bytes of sprite data in 'data'.
+func (ppu *PPU) setLineSprite(sprite *lineSprite, data []byte, height int) {
+	// The third byte is the sprite's attribute byte.  Has the upper two bits of the 4-bit palette
+	// entry, flipping, and priority.
+	sprite.attr = data[2]
+
+	// X position on the screen.
+	sprite.x = data[3]
+
+	// The second byte tells us what tile to use.
+	row := ppu.scanLine - int(data[0])
+	sprite.patternAddr = ppu.spritePatternAddress(data[1], sprite.attr, row, height)
+}
+
+// The address of the low pattern byte for 'row' of a sprite with tile 'tile' and attribute byte
+// 'attr'.  The high byte is 8 bytes later.
+func (ppu *PPU) spritePatternAddress(tile byte, attr byte, row int, height int) uint16 {
+	tileNoToRender := uint16(tile)
+
+	// Sprite pattern table that we use for each sprite.
+	var ptBaseAddr uint16
+
+	// For 8x8 sprites, ptBaseAddr is set via ppuCtrl.
+	// For 8x16 sprites, ptBaseAddr is set per-sprite.
+	if 8 == height {
+		if 8 == (ppu.ppuCtrl & 8) {
+			ptBaseAddr = 0x1000
+		}
+	} else if 1 == (tileNoToRender & 1) {
+		// For 8x16 sprites, odd tile numbers are fetched from the pattern table at 0x1000,
+		// and the lowest bit is dropped.
+		ptBaseAddr = 0x1000
+		tileNoToRender &= 0xfffe
+	}
+
+	// If this bit is set the sprite is flipped vertically.
+	if 0x80 == (attr & 0x80) {
+		row = height - 1 - row
+	}
+
+	// If we're rendering the [8, 16) line of a large sprite, the data is actually in the next
+	// tile.
+	if row >= 8 {
+		row -= 8
+		tileNoToRender++
+	}
+
+	// Each tile is 16 bytes, 8 bytes of the 0-th bit, and 8 bytes of the 1st bit.
+	return ptBaseAddr + tileNoToRender * 16 + uint16(row)
+}
+
+// Fetch one pattern byte of the 'slot'-th sprite in secondary OAM.  'offset' is 0 for the low byte
+// and 8 for the high byte.  The PPU always fetches patterns for 8 sprites.  Empty slots fetch tile
+// 0xFF, and some mappers count scanlines by watching these fetches.
+func (ppu *PPU) fetchSpritePattern(slot int, offset uint16) {
+	if slot >= ppu.secondaryOAMCount {
+		ppu.fetch(ppu.spritePatternAddress(0xff, 0, 0, ppu.spriteHeight()) + offset)
+		return
+	}
+
+	sprite := &ppu.lineSprites[slot]
+	if 0 == offset {
+		ppu.setLineSprite(sprite, ppu.secondaryOAM[slot * 4:slot * 4 + 4], ppu.spriteHeight())
+		sprite.isSpriteZero = (0 == slot) && ppu.secondaryHasSpriteZero
+	}
+
+	val := ppu.fetch(sprite.patternAddr + offset)
+
+	// If this bit is set the sprite is flipped horizontally.  Flip the bits now so drawing
+	// doesn't have to care.
+	if 0x40 == (sprite.attr & 0x40) {
+		val = reverseBits(val)
+	}
+
+	if 0 == offset {
+		sprite.patternLow = val
+	} else {
+		sprite.patternHigh = val
+	}
+}
+
+// Reverse the order of the bits in 'b'.
+func reverseBits(b byte) (out byte) {
+	for i := 0; i < 8; i++ {
+		out = (out << 1) | (b & 1)
+		b >>= 1
+	}
+	return
+}
+
+// The sprite palette index for the 'x'-th pixel.  Also returns whether the sprite is drawn in front
+// of the background and whether it's sprite 0.
+func (ppu *PPU) spritePixel(x int) (val byte, hasPriority bool, isSpriteZero bool) {
+	if !ppu.shouldRenderSprites() {
+		return
+	}
+
+	// This flag controls whether or not sprites are shown in the left 8 pixels.
+	if x < 8 && 0 == (ppu.ppuMask & 4) {
+		return
+	}
+
+	// Among sprites, the one with the lowest index has the highest priority.  The line sprites
+	// are in index order, so the first non-transparent pixel found wins.
+	// http://wiki.nesdev.com/w/index.php/PPU_sprite_priority
+	for i := 0; i < ppu.lineSpriteCount; i++ {
+		sprite := &ppu.lineSprites[i]
+
+		// Sprites are 8 pixels wide and do not wrap around the screen.
+		offset := x - int(sprite.x)
+		if offset < 0 || offset > 7 {
+			continue
+		}
+
+		pixel := (sprite.patternLow >> uint(7 - offset)) & 1
+		pixel |= ((sprite.patternHigh >> uint(7 - offset)) & 1) << 1
+		if 0 == pixel {
+			continue
+		}
+
+		// The attribute byte has the upper 2 palette bits and the priority of the sprite
+		// with respect to the background.
+		val = pixel | ((sprite.attr & 3) << 2)
+		hasPriority = (0 == (sprite.attr & 0x20))
+		isSpriteZero = sprite.isSpriteZero
+		return
+	}
+	return
+}
@@ -0,0 +1,76 @@
+package ppu
+
+import "savestate"
+
+// Save the PPU registers, memory and where it is in the frame.
+func (ppu *PPU) Save(w *savestate.Writer) {
+	ppu.state(w)
+}
+
+// Load what Save saved.
+func (ppu *PPU) Load(r *savestate.Reader) {
+	ppu.state(r)
+}
+
+// Save or load the PPU's state.  The rendering pipeline is included so that a state saved in the
+// middle of a frame picks up exactly where it left off.
+func (ppu *PPU) state(s savestate.Stream) {
+	s.Bytes(ppu.pal[:])
+	for _, color := range ppu.pal {
+		s.Check(int(color) < len(Palette), "palette entry")
+	}
+	s.Bytes(ppu.oamData[:])
+
+	s.Uint8(&ppu.ppuCtrl)
+	s.Uint8(&ppu.ppuMask)
+	s.Uint8(&ppu.ppuStatus)
+	s.Uint8(&ppu.oamAddr)
+	s.Uint16(&ppu.loopyT)
+	s.Uint16(&ppu.loopyV)
+	s.Uint8(&ppu.loopyX)
+	s.Uint8(&ppu.addressLatch)
+	s.Uint8(&ppu.bufferedReadData)
+
+	s.Int(&ppu.scanLine)
+	s.Int(&ppu.dot)
+	s.Check(ppu.scanLine >= 0 && ppu.scanLine <= PreRenderScanLine, "scan line")
+	s.Check(ppu.dot >= 0 && ppu.dot < DotsPerScanLine, "dot")
+	s.Bool(&ppu.oddFrame)
+
+	s.Uint8(&ppu.nameTableLatch)
+	s.Uint8(&ppu.attributeLatch)
+	s.Uint8(&ppu.patternLowLatch)
+	s.Uint8(&ppu.patternHighLatch)
+	s.Uint16(&ppu.patternLowShift)
+	s.Uint16(&ppu.patternHighShift)
+	s.Uint16(&ppu.attributeLowShift)
+	s.Uint16(&ppu.attributeHighShift)
+
+	s.Bytes(ppu.secondaryOAM[:])
+	s.Int(&ppu.secondaryOAMCount)
+	s.Bool(&ppu.secondaryHasSpriteZero)
+	s.Int(&ppu.evalSprite)
+	s.Int(&ppu.evalByte)
+	s.Check(ppu.secondaryOAMCount >= 0 && ppu.secondaryOAMCount <= len(ppu.secondaryOAM) / 4,
+		"secondary OAM count")
+	s.Check(ppu.evalSprite >= 0 && ppu.evalSprite < len(ppu.oamData) / 4, "sprite evaluation")
+	s.Check(ppu.evalByte >= 0 && ppu.evalByte < 4, "sprite evaluation")
+	s.Bool(&ppu.evalDone)
+	s.Uint8(&ppu.oamLatch)
+
+	s.Int(&ppu.lineSpriteCount)
+	s.Check(ppu.lineSpriteCount >= 0 && ppu.lineSpriteCount <= len(ppu.lineSprites),
+		"line sprite count")
+	for i := range ppu.lineSprites {
+		sprite := &ppu.lineSprites[i]
+		s.Uint8(&sprite.x)
+		s.Uint8(&sprite.attr)
+		s.Uint16(&sprite.patternAddr)
+		s.Uint8(&sprite.patternLow)
+		s.Uint8(&sprite.patternHigh)
+		s.Bool(&sprite.isSpriteZero)
+	}
+
+	s.Bool(&ppu.nmiOutput)
+	s.Bool(&ppu.nmiPending)
+}
@@ -0,0 +1,205 @@
+package savestate
+
+// This package provides the save state format.  A save state is a header followed by the state of
+// each component of the machine, written one value at a time in a fixed order.
+//
+// Components describe their state once, as a function taking a Stream, and that function is used
+// both to save and to load.  That way the order values are written in always matches the order
+// they're read in.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Bump this whenever anything about what's saved changes.  States from other versions are refused
+// rather than loaded into the wrong fields.
+const Version = 5
+
+// Every save state starts with these bytes, followed by the version.
+var magic = []byte{'N', 'E', 'S', 'S'}
+
+var (
+	ErrNotSaveState = errors.New("not a save state")
+	ErrWrongVersion = errors.New("save state is from a different version")
+)
+
+// A value in the state is out of range for what it's loaded into, so the state is corrupt.
+type CorruptError struct {
+	// What was out of range.
+	What string
+}
+
+func (err *CorruptError) Error() string {
+	return "save state is corrupt: bad " + err.What
+}
+
+// Implemented by Writer and Reader.  Each method saves or loads the value pointed to.
+type Stream interface {
+	// True if values are being loaded, false if they're being saved.  Components that keep
+	// state derived from what's saved (e.g. slices into ROM) rebuild it after loading.
+	Loading() bool
+
+	Bool(val *bool)
+	Uint8(val *uint8)
+	Uint16(val *uint16)
+	Uint64(val *uint64)
+
+	// Ints are saved as 64 bits.
+	Int(val *int)
+
+	// Saves or loads all of 'val', whose length must be the same when saving and loading.
+	Bytes(val []byte)
+
+	// When loading, fails with a *CorruptError about 'what' unless 'ok'.  Components check every
+	// loaded value they use as an index before using it, so a corrupt state is refused rather
+	// than crashing.  Does nothing when saving.
+	Check(ok bool, what string)
+
+	// The first error encountered, if any.  Once there's an error, everything else is ignored.
+	Err() error
+}
+
+// Saves state to an io.Writer.
+type Writer struct {
+	w io.Writer
+	err error
+}
+
+// Create a Writer that saves to 'w', and write the header.
+func NewWriter(w io.Writer) (out *Writer) {
+	out = new(Writer)
+	out.w = w
+	out.Bytes(magic)
+	version := uint16(Version)
+	out.Uint16(&version)
+	return
+}
+
+func (sw *Writer) Loading() bool {
+	return false
+}
+
+func (sw *Writer) Err() error {
+	return sw.err
+}
+
+// Write 'val' in little endian order.
+func (sw *Writer) write(val interface{}) {
+	if nil != sw.err {
+		return
+	}
+	sw.err = binary.Write(sw.w, binary.LittleEndian, val)
+}
+
+func (sw *Writer) Bool(val *bool) {
+	sw.write(*val)
+}
+
+func (sw *Writer) Uint8(val *uint8) {
+	sw.write(*val)
+}
+
+func (sw *Writer) Uint16(val *uint16) {
+	sw.write(*val)
+}
+
+func (sw *Writer) Uint64(val *uint64) {
+	sw.write(*val)
+}
+
+func (sw *Writer) Int(val *int) {
+	sw.write(int64(*val))
+}
+
+func (sw *Writer) Bytes(val []byte) {
+	if nil != sw.err {
+		return
+	}
+	_, sw.err = sw.w.Write(val)
+}
+
+func (sw *Writer) Check(ok bool, what string) {
+}
+
+// Loads state from an io.Reader.
+type Reader struct {
+	r io.Reader
+	err error
+}
+
+// Create a Reader that loads from 'r'.  Reads and checks the header, and returns an error if it's
+// not a save state this version can load.
+func NewReader(r io.Reader) (out *Reader, err error) {
+	out = new(Reader)
+	out.r = r
+
+	header := make([]byte, len(magic))
+	var version uint16
+	out.Bytes(header)
+	out.Uint16(&version)
+
+	if nil != out.err {
+		return nil, out.err
+	} else if !bytes.Equal(magic, header) {
+		return nil, ErrNotSaveState
+	} else if Version != version {
+		return nil, ErrWrongVersion
+	}
+	return
+}
+
+func (sr *Reader) Loading() bool {
+	return true
+}
+
+func (sr *Reader) Err() error {
+	return sr.err
+}
+
+// Read into 'val' in little endian order.
+func (sr *Reader) read(val interface{}) {
+	if nil != sr.err {
+		return
+	}
+	sr.err = binary.Read(sr.r, binary.LittleEndian, val)
+}
+
+func (sr *Reader) Bool(val *bool) {
+	sr.read(val)
+}
+
+func (sr *Reader) Uint8(val *uint8) {
+	sr.read(val)
+}
+
+func (sr *Reader) Uint16(val *uint16) {
+	sr.read(val)
+}
+
+func (sr *Reader) Uint64(val *uint64) {
+	sr.read(val)
+}
+
+func (sr *Reader) Int(val *int) {
+	var wide int64
+	sr.read(&wide)
+	if nil == sr.err {
+		*val = int(wide)
+	}
+}
+
+func (sr *Reader) Bytes(val []byte) {
+	if nil != sr.err {
+		return
+	}
+	_, sr.err = io.ReadFull(sr.r, val)
+}
+
+func (sr *Reader) Check(ok bool, what string) {
+	if nil == sr.err && !ok {
+		sr.err = &CorruptError{what}
+	}
+}
@@ -0,0 +1,95 @@
+package savestate
+
+import (
+	"bytes"
+	"testing"
+)
+
+type testState struct {
+	b bool
+	u8 uint8
+	u16 uint16
+	u64 uint64
+	i int
+	data [4]byte
+}
+
+func (ts *testState) state(s Stream) {
+	s.Bool(&ts.b)
+	s.Uint8(&ts.u8)
+	s.Uint16(&ts.u16)
+	s.Uint64(&ts.u64)
+	s.Int(&ts.i)
+	s.Bytes(ts.data[:])
+}
+
+func TestRoundTrip(t *testing.T) {
+	saved := testState{true, 0x12, 0x3456, 0x789abcdef0, -5, [4]byte{1, 2, 3, 4}}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	saved.state(w)
+	if nil != w.Err() {
+		t.Fatal(w.Err())
+	}
+
+	r, err := NewReader(buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var loaded testState
+	loaded.state(r)
+	if nil != r.Err() {
+		t.Fatal(r.Err())
+	}
+
+	if saved != loaded {
+		t.Errorf("Loaded %+v, saved %+v", loaded, saved)
+	}
+}
+
+func TestBadHeader(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("NES\x1a\x01\x00"))); ErrNotSaveState != err {
+		t.Errorf("Expected ErrNotSaveState, got %v", err)
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte("NESS\xff\x00"))); ErrWrongVersion != err {
+		t.Errorf("Expected ErrWrongVersion, got %v", err)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	var val uint64 = 1
+	w.Uint64(&val)
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len() - 1]))
+	if nil != err {
+		t.Fatal(err)
+	}
+	r.Uint64(&val)
+	if nil == r.Err() {
+		t.Error("Expected an error loading a truncated state")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.Check(false, "nothing")
+	if nil != w.Err() {
+		t.Fatal("Check failed while saving:", w.Err())
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if nil != err {
+		t.Fatal(err)
+	}
+	r.Check(true, "first")
+	r.Check(false, "second")
+	r.Check(false, "third")
+	if corrupt, ok := r.Err().(*CorruptError); !ok || "second" != corrupt.What {
+		t.Errorf("Expected a CorruptError about the second check, got %v", r.Err())
+	}
+}
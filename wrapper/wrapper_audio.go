@@ -0,0 +1,109 @@
+package wrapper
+
+// Wraps system interaction (graphics, input, sound) behind a shim.
+
+import (
+	"github.com/veandco/go-sdl2/sdl"
+	"time"
+)
+
+const (
+	// How much audio we try to keep queued up, in seconds.  More is safer but laggier.
+	audioTargetLatency = 0.05
+
+	// The most the sample rate is nudged up or down to keep the queue at the target.  Half a
+	// percent is not audible as a change in pitch.
+	audioMaxRateDelta = 0.005
+)
+
+// Plays signed 16-bit mono samples through the default audio device.
+//
+// Samples are pushed to SDL's audio queue.  The emulator and the sound card each run off their own
+// clock, so the queue slowly fills up or drains unless the rate samples are generated at is
+// adjusted to match.  See RateAdjustment() for how that's done.
+type AudioSink struct {
+	device sdl.AudioDeviceID
+
+	// The rate the device plays at, in Hz.  May differ from the rate asked for.
+	SampleRate int
+
+	// How many bytes of audio we try to keep in the queue.
+	targetQueued uint32
+
+	// Scratch space for converting samples to bytes.
+	bytes []byte
+}
+
+// Open the default audio device at (about) 'sampleRate' Hz.  Dies if any error is encountered.
+func NewAudioSink(sampleRate int) (as *AudioSink) {
+	as = new(AudioSink)
+
+	desired := sdl.AudioSpec{
+		Freq:     int32(sampleRate),
+		Format:   sdl.AUDIO_S16LSB,
+		Channels: 1,
+		Samples:  1024,
+	}
+	var obtained sdl.AudioSpec
+
+	var err error
+	as.device, err = sdl.OpenAudioDevice("", false, &desired, &obtained,
+					     sdl.AUDIO_ALLOW_FREQUENCY_CHANGE)
+	if nil != err {
+		panic(err)
+	}
+
+	as.SampleRate = int(obtained.Freq)
+	as.targetQueued = uint32(audioTargetLatency * float64(as.SampleRate)) * 2
+
+	// The device starts paused.
+	sdl.PauseAudioDevice(as.device, false)
+	return
+}
+
+// Queue 'samples' for playback.  If the emulator is running ahead of real time and the queue is
+// well past its target, this waits for it to drain, which also keeps the emulator from running
+// too fast.
+func (as *AudioSink) Queue(samples []int16) {
+	for sdl.GetQueuedAudioSize(as.device) > 2 * as.targetQueued {
+		time.Sleep(time.Millisecond)
+	}
+
+	if cap(as.bytes) < 2 * len(samples) {
+		as.bytes = make([]byte, 2 * len(samples))
+	}
+	as.bytes = as.bytes[:2 * len(samples)]
+
+	// Little-endian, as requested from the device.
+	for i, sample := range samples {
+		as.bytes[2 * i] = byte(sample)
+		as.bytes[2 * i + 1] = byte(uint16(sample) >> 8)
+	}
+
+	if err := sdl.QueueAudio(as.device, as.bytes); nil != err {
+		panic(err)
+	}
+}
+
+// How much faster or slower than SampleRate samples should be generated, as a multiplier near
+// 1.0.  When the queue is below its target this is above 1 so that more samples are made, and
+// vice versa.  Feeding SampleRate * RateAdjustment() back into the sample generator keeps the
+// queue hovering around the target without crackling (from running dry) or drifting latency (from
+// overfilling).
+func (as *AudioSink) RateAdjustment() float64 {
+	queued := float64(sdl.GetQueuedAudioSize(as.device))
+	target := float64(as.targetQueued)
+
+	adjustment := 1 + audioMaxRateDelta * (target - queued) / target
+	if adjustment < 1 - audioMaxRateDelta {
+		adjustment = 1 - audioMaxRateDelta
+	} else if adjustment > 1 + audioMaxRateDelta {
+		adjustment = 1 + audioMaxRateDelta
+	}
+	return adjustment
+}
+
+// The rate samples should be generated at right now: SampleRate nudged by RateAdjustment().
+func (as *AudioSink) OutputRate() float64 {
+	return float64(as.SampleRate) * as.RateAdjustment()
+}
@@ -4,6 +4,7 @@ package wrapper
 
 import (
 	"github.com/veandco/go-sdl2/sdl"
+	"image"
 	"reflect"
 	"unsafe"
 )
@@ -52,6 +53,18 @@ func (gw *GraphicsWindow) lockTexture() {
 	sliceHeader.Data = uintptr(pixels)
 }
 
+// Copy 'frame' into the graphics buffer and make it visible.  'frame' must be the size of the
+// window.
+func (gw *GraphicsWindow) Show(frame *image.RGBA) {
+	for y := 0; y < gw.Height; y++ {
+		row := frame.Pix[y * frame.Stride : y * frame.Stride + 4 * gw.Width]
+		for x := 0; x < gw.Width; x++ {
+			gw.SetPixel(x, y, row[4 * x], row[4 * x + 1], row[4 * x + 2])
+		}
+	}
+	gw.Blit()
+}
+
 // Make the graphics buffer visible to the window.  Pixels written via SetPixel are not visible
 // until Blit() is called.
 func (gw *GraphicsWindow) Blit() {
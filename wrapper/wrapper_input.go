@@ -21,6 +21,22 @@ const (
 	// "System" inputs.
 	KEY_RESET
 	KEY_QUIT
+	KEY_SPRITE_LIMIT
+	KEY_SAVE_STATE
+	KEY_LOAD_STATE
+	KEY_DEBUG
+
+	// Select the save state slot.  KEY_SLOT_0 + n selects slot n.
+	KEY_SLOT_0
+	KEY_SLOT_1
+	KEY_SLOT_2
+	KEY_SLOT_3
+	KEY_SLOT_4
+	KEY_SLOT_5
+	KEY_SLOT_6
+	KEY_SLOT_7
+	KEY_SLOT_8
+	KEY_SLOT_9
 )
 
 // Create a new InputProvider.  An InputProvider maps user key presses to buttons/events that occur
@@ -28,6 +44,7 @@ const (
 func NewInputProvider() (out *InputProvider) {
 	out = new(InputProvider)
 	out.pressed = make(map[int]bool)
+	out.handled = make(map[int]bool)
 
 	if sdl.NumJoysticks() == 0 {
 		out.joy = nil
@@ -47,6 +64,9 @@ type InputProvider struct {
 	// Maybe this is too obvious but pressed[KEY_UP] is true if the user has pressed 'up.
 	pressed map[int]bool
 
+	// Set once WasKeyPressed has reported a key press, until the key is released.
+	handled map[int]bool
+
 	joy *sdl.Joystick
 }
 
@@ -67,6 +87,20 @@ var keyMapping = map[sdl.Keycode]int {
 	// Not-game-accessible bindings.
 	sdl.K_r: KEY_RESET,
 	sdl.K_q: KEY_QUIT,
+	sdl.K_l: KEY_SPRITE_LIMIT,
+	sdl.K_F5: KEY_SAVE_STATE,
+	sdl.K_F9: KEY_LOAD_STATE,
+	sdl.K_F12: KEY_DEBUG,
+	sdl.K_0: KEY_SLOT_0,
+	sdl.K_1: KEY_SLOT_1,
+	sdl.K_2: KEY_SLOT_2,
+	sdl.K_3: KEY_SLOT_3,
+	sdl.K_4: KEY_SLOT_4,
+	sdl.K_5: KEY_SLOT_5,
+	sdl.K_6: KEY_SLOT_6,
+	sdl.K_7: KEY_SLOT_7,
+	sdl.K_8: KEY_SLOT_8,
+	sdl.K_9: KEY_SLOT_9,
 }
 
 // These are hardcoded button IDs for the PlayStation controller I use.
@@ -107,3 +141,18 @@ func (ip *InputProvider) IsKeyPressed(nesKey int) bool {
 	// Return the information the user actually wants.
 	return ip.pressed[nesKey]
 }
+
+// Returns true once each time the provided key is pressed, rather than the whole time it's held
+// down.  For keys that do something once, like toggling a setting.
+func (ip *InputProvider) WasKeyPressed(nesKey int) bool {
+	if !ip.IsKeyPressed(nesKey) {
+		ip.handled[nesKey] = false
+		return false
+	}
+
+	if ip.handled[nesKey] {
+		return false
+	}
+	ip.handled[nesKey] = true
+	return true
+}